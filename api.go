@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Обработчики API партнёров /api/v1/. Поиск отдаёт JSON вместо страницы каталога;
+// удержания, оплата и бронирования используют те же обработчики, что и сайт,
+// от имени аккаунта партнёра
+
+// Предложение номера на период с итоговой ценой
+type RoomOffer struct {
+	Room
+	Quote Quote `json:"quote"`
+}
+
+// Функция подбора свободных номеров на период: /api/v1/rooms?check_in=&check_out=&guests=&hotel=&currency=
+func searchRoomOffers(start, end time.Time, guests int, hotel, currency string, now time.Time) ([]RoomOffer, error) {
+	busy, err := busyItems("room", start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogMu.RLock()
+	candidates := append([]Room(nil), rooms...)
+	catalogMu.RUnlock()
+
+	offers := []RoomOffer{}
+	for _, room := range candidates {
+		if busy[room.ID] || room.Capacity < guests || (hotel != "" && room.Hotel != hotel) {
+			continue
+		}
+		quote, err := quoteRoom(room, start, end, now, busy).Convert(currency)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, RoomOffer{room, quote})
+	}
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].Quote.Total.Amount < offers[j].Quote.Total.Amount
+	})
+	return offers, nil
+}
+
+// Обработчик поиска автомобилей для партнёров; параметры те же, что у /cars
+func apiCarsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		offers, err := searchCarOffers(carSearchFromQuery(r.URL.Query()), time.Now())
+		switch {
+		case err == nil:
+		case errors.Is(err, errInvalidDateRange):
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		case errors.Is(err, errUnknownCurrency), errors.Is(err, errNoExchangeRate):
+			writeError(w, r, http.StatusBadRequest, "Конвертация в эту валюту недоступна")
+			return
+		default:
+			log.Println("Ошибка поиска автомобилей:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		for i := range offers {
+			offers[i].Quote = offers[i].Quote.Localized(r)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", offers)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик поиска номеров для партнёров
+func apiRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		start, end, err := parseDateRange(q.Get("check_in"), q.Get("check_out"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		}
+		guests, _ := strconv.Atoi(q.Get("guests"))
+
+		offers, err := searchRoomOffers(start, end, guests, q.Get("hotel"), q.Get("currency"), time.Now())
+		switch {
+		case err == nil:
+		case errors.Is(err, errUnknownCurrency), errors.Is(err, errNoExchangeRate):
+			writeError(w, r, http.StatusBadRequest, "Конвертация в эту валюту недоступна")
+			return
+		default:
+			log.Println("Ошибка поиска номеров:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		for i := range offers {
+			offers[i].Quote = offers[i].Quote.Localized(r)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", offers)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
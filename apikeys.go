@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API для партнёров (турагентств): поиск и бронирование автомобилей и номеров.
+// Ключи выдаёт администратор, в базе хранится только SHA-256 ключа. Каждый
+// партнёр привязан к аккаунту пользователя, на который оформляются его бронирования
+const (
+	apiKeyPrefix     = "bk_"
+	apiScopeSearch   = "search" // только поиск и расчёт цен
+	apiScopeBook     = "book"   // поиск, удержание, оплата и отмена бронирований
+	defaultRateLimit = 60       // запросов в минуту на ключ
+)
+
+var errPartnerNotFound = errors.New("партнёр не найден")
+
+// Партнёр и выданный ему ключ
+type Partner struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIKey struct {
+	ID            int        `json:"id"`
+	PartnerID     int        `json:"partner_id"`
+	Prefix        string     `json:"prefix"` // начало ключа, чтобы партнёр мог его узнать
+	Scope         string     `json:"scope"`
+	RateLimit     int        `json:"rate_limit"`
+	RequestsToday int        `json:"requests_today"`
+	RequestsTotal int        `json:"requests_total"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Ключ, которым подписан текущий запрос API
+type apiKeyAuth struct {
+	KeyID     int
+	PartnerID int
+	UserID    int
+	Scope     string
+	RateLimit int
+}
+
+type apiKeyCtxKey struct{}
+
+// Функция возвращает ключ API текущего запроса, если он есть
+func apiKeyFromContext(r *http.Request) (apiKeyAuth, bool) {
+	key, ok := r.Context().Value(apiKeyCtxKey{}).(apiKeyAuth)
+	return key, ok
+}
+
+// Ключ с правом бронирования может и искать
+func (k apiKeyAuth) allows(scope string) bool {
+	return k.Scope == apiScopeBook || k.Scope == scope
+}
+
+// Корзины токенов для ключей: по одному ограничителю на каждое значение лимита,
+// внутри него — корзина на ключ
+var (
+	apiLimitersMu sync.Mutex
+	apiLimiters   = map[int]*rateLimiter{}
+)
+
+func apiKeyLimiter(perMinute int) *rateLimiter {
+	apiLimitersMu.Lock()
+	defer apiLimitersMu.Unlock()
+
+	l, ok := apiLimiters[perMinute]
+	if !ok {
+		l = newRateLimiter(perMinute, time.Minute/time.Duration(perMinute))
+		apiLimiters[perMinute] = l
+	}
+	return l
+}
+
+// Ключ берётся из заголовка Authorization: Bearer или X-API-Key
+func apiKeyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// Функция поиска действующего ключа по значению из запроса
+func lookupAPIKey(raw string) (apiKeyAuth, error) {
+	var key apiKeyAuth
+	err := db.QueryRow(`SELECT k.id, k.partner_id, p.user_id, k.scope, k.rate_limit
+		FROM api_keys k JOIN partners p ON p.id = k.partner_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL`, hashToken(raw)).
+		Scan(&key.KeyID, &key.PartnerID, &key.UserID, &key.Scope, &key.RateLimit)
+	return key, err
+}
+
+// Функция учёта запроса: время последнего использования и счётчик за день
+func recordAPIKeyUsage(keyID int) error {
+	_, err := db.Exec(`WITH used AS (UPDATE api_keys SET last_used_at = NOW() WHERE id = $1)
+		INSERT INTO api_key_usage (key_id, day, requests) VALUES ($1, CURRENT_DATE, 1)
+		ON CONFLICT (key_id, day) DO UPDATE SET requests = api_key_usage.requests + 1`, keyID)
+	return err
+}
+
+// Middleware для маршрутов /api/v1/: проверяет ключ, его права и лимит запросов.
+// Обработчики получают пользователя партнёра через currentUserID
+func withAPIKey(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		raw := apiKeyFromRequest(r)
+		if !strings.HasPrefix(raw, apiKeyPrefix) {
+			writeErrorCode(w, r, http.StatusUnauthorized, "api_key_required", "Требуется ключ API")
+			return
+		}
+		key, err := lookupAPIKey(raw)
+		if err == sql.ErrNoRows {
+			writeErrorCode(w, r, http.StatusUnauthorized, "api_key_invalid", "Ключ API недействителен или отозван")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if !key.allows(scope) {
+			writeErrorCode(w, r, http.StatusForbidden, "insufficient_scope", "Ключ API не даёт доступа к этой операции")
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(key.RateLimit))
+		if ok, wait := apiKeyLimiter(key.RateLimit).allow(strconv.Itoa(key.KeyID), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeErrorCode(w, r, http.StatusTooManyRequests, "rate_limited", "Превышен лимит запросов для ключа API")
+			return
+		}
+		if err := recordAPIKeyUsage(key.KeyID); err != nil {
+			log.Printf("Ошибка учёта запроса ключа %d: %v", key.KeyID, err)
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, key)))
+	}
+}
+
+// Функция регистрации партнёра для существующего аккаунта. Если аккаунта нет, возвращает sql.ErrNoRows
+func createPartner(name, email string) (Partner, error) {
+	p := Partner{Name: name, Email: email}
+	err := db.QueryRow(`INSERT INTO partners (name, user_id)
+		SELECT $1, id FROM users WHERE lower(email) = $2
+		RETURNING id, user_id, created_at`, name, email).Scan(&p.ID, &p.UserID, &p.CreatedAt)
+	return p, err
+}
+
+// Функция выпуска ключа. Полное значение возвращается только здесь
+func issueAPIKey(partnerID int, scope string, rateLimit int) (string, APIKey, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	raw := apiKeyPrefix + token
+
+	key := APIKey{PartnerID: partnerID, Prefix: raw[:len(apiKeyPrefix)+8], Scope: scope, RateLimit: rateLimit}
+	err = db.QueryRow(`INSERT INTO api_keys (partner_id, prefix, key_hash, scope, rate_limit)
+		SELECT id, $2, $3, $4, $5 FROM partners WHERE id = $1
+		RETURNING id, created_at`, partnerID, key.Prefix, hashToken(raw), scope, rateLimit).Scan(&key.ID, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return "", APIKey{}, errPartnerNotFound
+	}
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	return raw, key, nil
+}
+
+// Функция отзыва ключа; отозванный ключ сразу перестаёт приниматься
+func revokeAPIKey(id int) (bool, error) {
+	res, err := db.Exec(`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Функция списка ключей партнёра со счётчиками запросов
+func listAPIKeys(partnerID int) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT k.id, k.partner_id, k.prefix, k.scope, k.rate_limit,
+			COALESCE(SUM(u.requests) FILTER (WHERE u.day = CURRENT_DATE), 0), COALESCE(SUM(u.requests), 0),
+			k.created_at, k.last_used_at, k.revoked_at
+		FROM api_keys k LEFT JOIN api_key_usage u ON u.key_id = k.id
+		WHERE k.partner_id = $1
+		GROUP BY k.id ORDER BY k.id`, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.PartnerID, &k.Prefix, &k.Scope, &k.RateLimit, &k.RequestsToday, &k.RequestsTotal,
+			&k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Запрос регистрации партнёра
+type PartnerRequest struct {
+	Name  string `json:"name" validate:"required,max=255"`
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// Обработчик партнёров: GET — список, POST — регистрация партнёра для аккаунта с указанным email
+func adminPartnersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		rows, err := db.Query(`SELECT p.id, p.name, p.user_id, u.email, p.created_at
+			FROM partners p JOIN users u ON u.id = p.user_id ORDER BY p.id`)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		defer rows.Close()
+
+		partners := []Partner{}
+		for rows.Next() {
+			var p Partner
+			if err := rows.Scan(&p.ID, &p.Name, &p.UserID, &p.Email, &p.CreatedAt); err != nil {
+				log.Printf("Ошибка SQL: %v", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+				return
+			}
+			partners = append(partners, p)
+		}
+		writeSuccess(w, r, http.StatusOK, "", partners)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req PartnerRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		partner, err := createPartner(req.Name, normalizeEmail(req.Email))
+		if err == sql.ErrNoRows {
+			writeError(w, r, http.StatusNotFound, "Пользователь не найден")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Партнёр добавлен", partner)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос выпуска ключа API; rate_limit — запросов в минуту
+type APIKeyRequest struct {
+	PartnerID int    `json:"partner_id" validate:"required"`
+	Scope     string `json:"scope" validate:"required,oneof=search|book"`
+	RateLimit int    `json:"rate_limit" validate:"min=1,max=10000"`
+}
+
+// Обработчик ключей API: GET ?partner_id= — ключи партнёра с использованием, POST — выпуск ключа
+func adminAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		partnerID, err := strconv.Atoi(r.URL.Query().Get("partner_id"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Некорректный идентификатор")
+			return
+		}
+
+		keys, err := listAPIKeys(partnerID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		writeSuccess(w, r, http.StatusOK, "", keys)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req APIKeyRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+		if req.RateLimit == 0 {
+			req.RateLimit = defaultRateLimit
+		}
+
+		raw, key, err := issueAPIKey(req.PartnerID, req.Scope, req.RateLimit)
+		if errors.Is(err, errPartnerNotFound) {
+			writeError(w, r, http.StatusNotFound, "Партнёр не найден")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка выпуска ключа API: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Ключ API выпущен, сохраните его: повторно он не показывается", map[string]interface{}{
+			"key":     raw,
+			"api_key": key,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос отзыва ключа API
+type APIKeyRevokeRequest struct {
+	ID int `json:"id" validate:"required"`
+}
+
+// Обработчик отзыва ключа API
+func adminRevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var req APIKeyRevokeRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		found, err := revokeAPIKey(req.ID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "Ключ API не найден")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Ключ API отозван", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: запрос без ключа или с чужим ключом не доходит до обработчика
+func TestWithAPIKeyRejectsMissingAndUnknownKey(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	handler := withAPIKey(apiScopeSearch, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("обработчик не должен вызываться")
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/api/v1/cars", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"api_key_required"`)
+
+	mock.ExpectQuery("SELECT k.id, k.partner_id").
+		WithArgs(hashToken("bk_unknown")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "partner_id", "user_id", "scope", "rate_limit"}))
+
+	req := httptest.NewRequest("GET", "/api/v1/cars", nil)
+	req.Header.Set("X-API-Key", "bk_unknown")
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"api_key_invalid"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: ключ только для поиска не даёт бронировать, ключ с правом бронирования
+// работает от имени аккаунта партнёра, а сверх лимита запросы отклоняются
+func TestWithAPIKeyScopeAndRateLimit(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	keyRows := func(id int, scope string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "partner_id", "user_id", "scope", "rate_limit"}).AddRow(id, 3, 42, scope, 1)
+	}
+	var seenUser int
+	handler := withAPIKey(apiScopeBook, func(w http.ResponseWriter, r *http.Request) {
+		seenUser, _ = currentUserID(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/holds", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	mock.ExpectQuery("SELECT k.id, k.partner_id").WithArgs(hashToken("bk_search")).WillReturnRows(keyRows(901, apiScopeSearch))
+	rr := request("bk_search")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"insufficient_scope"`)
+
+	mock.ExpectQuery("SELECT k.id, k.partner_id").WithArgs(hashToken("bk_book")).WillReturnRows(keyRows(902, apiScopeBook))
+	mock.ExpectExec("INSERT INTO api_key_usage").WithArgs(902).WillReturnResult(sqlmock.NewResult(0, 1))
+	rr = request("bk_book")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, 42, seenUser)
+
+	mock.ExpectQuery("SELECT k.id, k.partner_id").WithArgs(hashToken("bk_book")).WillReturnRows(keyRows(902, apiScopeBook))
+	rr = request("bk_book")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"rate_limited"`)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: просроченный и неизвестный токены подтверждения дают разные ответы
+func TestHandleConfirmExpiredAndInvalid(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT id, COALESCE\\(confirmation_sent_at").
+		WithArgs(hashToken("old"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired"}).AddRow(3, true))
+	mock.ExpectQuery("SELECT id, COALESCE\\(confirmation_sent_at").
+		WithArgs(hashToken("unknown"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired"}))
+
+	rr := httptest.NewRecorder()
+	handleConfirm(rr, httptest.NewRequest("GET", "/confirm?token=old", nil))
+	assert.Equal(t, http.StatusGone, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handleConfirm(rr, httptest.NewRequest("GET", "/confirm?token=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: неизвестный email и неверный пароль неотличимы по ответу
+func TestHandleLoginUniformFailure(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT id, password, is_confirmed, COALESCE\\(locale, ''\\), totp_enabled, locked_until FROM users").
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_confirmed", "locale", "totp_enabled", "locked_until"}))
+	mock.ExpectQuery("SELECT id, password, is_confirmed, COALESCE\\(locale, ''\\), totp_enabled, locked_until FROM users").
+		WithArgs("john.doe@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_confirmed", "locale", "totp_enabled", "locked_until"}).AddRow(1, "password123", true, "", false, nil))
+	mock.ExpectQuery("UPDATE users SET").
+		WithArgs(1, loginLockThreshold, loginLockDuration.Seconds(), defaultLocale).
+		WillReturnRows(sqlmock.NewRows([]string{"locked", "email", "locale"}).AddRow(false, "john.doe@example.com", "ru"))
+
+	unknown := httptest.NewRecorder()
+	handleLogin(unknown, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"nobody@example.com","password":"x"}`)))
+	wrong := httptest.NewRecorder()
+	handleLogin(wrong, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"John.Doe@example.com","password":"x"}`)))
+
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: повторная регистрация отвечает так же, как первая
+func TestHandleRegisterExistingEmail(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	emailSender = &MockEmailSender{}
+
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs("John", "Doe", "john.doe@example.com", "password123", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	payload := `{"first_name":"John","last_name":"Doe","email":"John.Doe@example.com","password":"password123"}`
+	rr := httptest.NewRecorder()
+	handleRegister(rr, httptest.NewRequest("POST", "/register", strings.NewReader(payload)))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"success","message":"Пользователь успешно зарегистрирован. Проверьте email для подтверждения."}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
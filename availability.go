@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Формат дат, который отправляют поля <input type="date">
+const dateLayout = "2006-01-02"
+
+var errInvalidDateRange = errors.New("некорректный период дат")
+
+// Функция разбора периода аренды. Дата возврата должна быть позже даты получения.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	start, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, errInvalidDateRange
+	}
+	end, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, errInvalidDateRange
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, errInvalidDateRange
+	}
+	return start, end, nil
+}
+
+// Количество суток аренды между датой получения и датой возврата
+func rentalDays(start, end time.Time) int {
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// Функция возвращает идентификаторы объектов, занятых бронированиями, обслуживанием
+// или временными удержаниями хотя бы в один из дней периода [start, end)
+func busyItems(itemType string, start, end time.Time) (map[int]bool, error) {
+	return busyItemsExcept(itemType, start, end, 0)
+}
+
+// То же без бронирования exceptBookingID: при изменении бронирования оно
+// не должно повышать загрузку, по которой считается его новая цена
+func busyItemsExcept(itemType string, start, end time.Time, exceptBookingID int) (map[int]bool, error) {
+	rows, err := db.Query(`
+		SELECT item_id FROM bookings
+		WHERE item_type = $1 AND `+activeBookingSQL+` AND start_date < $3 AND end_date > $2
+			AND id <> $4
+		UNION
+		SELECT item_id FROM maintenance_blocks
+		WHERE item_type = $1 AND start_date < $3 AND end_date > $2
+		UNION
+		SELECT item_id FROM holds
+		WHERE item_type = $1 AND expires_at > NOW() AND start_date < $3 AND end_date > $2`,
+		itemType, start, end, exceptBookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	busy := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		busy[id] = true
+	}
+	return busy, rows.Err()
+}
+
+// Общий интерфейс *sql.DB и *sql.Tx, чтобы проверки доступности работали внутри транзакций
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Функция проверки, свободен ли конкретный объект в период [start, end)
+func itemAvailable(q dbtx, itemType string, itemID int, start, end time.Time) (bool, error) {
+	return itemAvailableFor(q, itemType, itemID, start, end, 0, 0)
+}
+
+// Проверка доступности для конкретного пользователя: его собственные временные
+// удержания не мешают, а бронирование exceptBookingID не учитывается — при переносе
+// дат бронирование не должно конфликтовать само с собой
+func itemAvailableFor(q dbtx, itemType string, itemID int, start, end time.Time, userID, exceptBookingID int) (bool, error) {
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM bookings
+			WHERE item_type = $1 AND item_id = $2 AND `+activeBookingSQL+` AND start_date < $4 AND end_date > $3
+				AND id <> $5
+		) OR EXISTS (
+			SELECT 1 FROM maintenance_blocks
+			WHERE item_type = $1 AND item_id = $2 AND start_date < $4 AND end_date > $3
+		) OR EXISTS (
+			SELECT 1 FROM holds
+			WHERE item_type = $1 AND item_id = $2 AND expires_at > NOW() AND start_date < $4 AND end_date > $3
+				AND user_id <> $6
+		)`, itemType, itemID, start, end, exceptBookingID, userID).Scan(&taken)
+	if err != nil {
+		return false, err
+	}
+	return !taken, nil
+}
+
+// Функция блокировки объекта до конца транзакции, чтобы два запроса
+// не могли одновременно забронировать один и тот же автомобиль
+func lockItem(tx *sql.Tx, itemType string, itemID int) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1), $2)`, itemType, itemID)
+	return err
+}
+
+// Структура периода обслуживания
+type MaintenanceBlock struct {
+	CarID     int    `json:"car_id" validate:"required"`
+	StartDate string `json:"start_date" validate:"required,date"`
+	EndDate   string `json:"end_date" validate:"required,date"`
+	Reason    string `json:"reason" validate:"max=500"`
+}
+
+// Обработчик для добавления периода обслуживания автомобиля
+func adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var block MaintenanceBlock
+		if !decodeAndValidate(w, r, &block) {
+			return
+		}
+
+		start, end, err := parseDateRange(block.StartDate, block.EndDate)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		}
+
+		_, err = db.Exec(`INSERT INTO maintenance_blocks (item_type, item_id, start_date, end_date, reason) VALUES ('car', $1, $2, $3, $4)`,
+			block.CarID, start, end, block.Reason)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Период обслуживания добавлен", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
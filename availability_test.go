@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест разбора периода аренды
+func TestParseDateRange(t *testing.T) {
+	start, end, err := parseDateRange("2025-03-01", "2025-03-04")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rentalDays(start, end), "Ожидалось 3 суток аренды")
+
+	// Дата возврата совпадает с датой получения
+	_, _, err = parseDateRange("2025-03-01", "2025-03-01")
+	assert.ErrorIs(t, err, errInvalidDateRange)
+
+	// Дата возврата раньше даты получения
+	_, _, err = parseDateRange("2025-03-04", "2025-03-01")
+	assert.ErrorIs(t, err, errInvalidDateRange)
+
+	// Неверный формат даты
+	_, _, err = parseDateRange("01.03.2025", "2025-03-04")
+	assert.ErrorIs(t, err, errInvalidDateRange)
+}
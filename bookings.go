@@ -0,0 +1,645 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Статусы бронирования
+const (
+	bookingPending   = "pending"    // Создано, ожидает оплаты
+	bookingConfirmed = "confirmed"  // Оплачено
+	bookingCheckedIn = "checked_in" // Гость заселился или клиент получил автомобиль
+	bookingCompleted = "completed"  // Проживание или аренда завершены
+	bookingCancelled = "cancelled"
+	bookingNoShow    = "no_show" // Клиент не приехал
+)
+
+// Разрешённые переходы между статусами. Из completed, cancelled и no_show переходов нет
+var bookingTransitions = map[string][]string{
+	bookingPending:   {bookingConfirmed, bookingCancelled},
+	bookingConfirmed: {bookingCheckedIn, bookingCancelled, bookingNoShow},
+	bookingCheckedIn: {bookingCompleted},
+}
+
+// Условие SQL, при котором бронирование занимает объект. Неоплаченное
+// бронирование занимает его, как удержание, только до expires_at
+const activeBookingSQL = "status IN ('pending', 'confirmed', 'checked_in') AND (status <> 'pending' OR expires_at IS NULL OR expires_at > NOW())"
+
+var (
+	errBookingNotFound   = errors.New("бронирование не найдено")
+	errInvalidTransition = errors.New("недопустимый переход статуса")
+)
+
+// Проверка, разрешён ли переход из одного статуса в другой
+func canTransition(from, to string) bool {
+	for _, next := range bookingTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция смены статуса бронирования с проверкой перехода.
+// Строка блокируется до конца транзакции, поэтому параллельные переходы не конфликтуют
+func transitionBooking(tx *sql.Tx, bookingID int, to string) error {
+	var from string
+	var expired bool
+	err := tx.QueryRow(`SELECT status, COALESCE(expires_at <= NOW(), false) FROM bookings WHERE id = $1 FOR UPDATE`, bookingID).
+		Scan(&from, &expired)
+	if err == sql.ErrNoRows {
+		return errBookingNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s → %s", errInvalidTransition, from, to)
+	}
+	// Истёкшее неоплаченное бронирование уже не держит объект, его нельзя подтвердить
+	if from == bookingPending && to == bookingConfirmed && expired {
+		return fmt.Errorf("%w: %w", errInvalidTransition, errHoldExpired)
+	}
+	_, err = tx.Exec(`UPDATE bookings SET status = $1, updated_at = NOW() WHERE id = $2`, to, bookingID)
+	return err
+}
+
+// Правила отмены: бесплатно не позднее чем за FreeHours часов до начала,
+// позже — возвращается LateRefundPercent процентов оплаты
+type CancellationPolicy struct {
+	Name              string `json:"name"`
+	FreeHours         int    `json:"free_hours"`
+	LateRefundPercent int    `json:"late_refund_percent"`
+}
+
+var cancellationPolicies = map[string]CancellationPolicy{
+	"flexible": {"flexible", 24, 50},
+	"moderate": {"moderate", 72, 50},
+	"strict":   {"strict", 168, 0},
+}
+
+// Правила отмены для объекта каталога
+func policyFor(itemType string, itemID int) CancellationPolicy {
+	name := "moderate"
+	switch itemType {
+	case "car":
+		if car, ok := findCar(itemID); ok && car.Policy != "" {
+			name = car.Policy
+		}
+	case "room":
+		if room, ok := findRoom(itemID); ok && room.Policy != "" {
+			name = room.Policy
+		}
+	}
+	return cancellationPolicies[name]
+}
+
+// Сумма возврата при отмене в момент now. Бронирование начинается в полночь UTC даты начала
+func (p CancellationPolicy) Refund(paid Money, start, now time.Time) Money {
+	if start.Sub(now) >= time.Duration(p.FreeHours)*time.Hour {
+		return paid
+	}
+	return paid.Percent(p.LateRefundPercent)
+}
+
+// Структура запроса на аренду автомобиля
+type CarBookingRequest struct {
+	CarID           int    `json:"car_id" validate:"required"`
+	PickupDate      string `json:"pickup_date" validate:"required,date"`
+	DropoffDate     string `json:"dropoff_date" validate:"required,date"`
+	PickupBranchID  int    `json:"pickup_branch_id"`  // 0 — филиал, где стоит автомобиль
+	DropoffBranchID int    `json:"dropoff_branch_id"` // 0 — возврат в филиал получения
+}
+
+// Обработчик бронирования автомобиля
+func handleCarBooking(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req CarBookingRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		// Получить автомобиль можно не раньше завтрашнего дня
+		start, end, err := parseDateRange(req.PickupDate, req.DropoffDate)
+		if err != nil || !time.Now().Before(start) {
+			writeError(w, r, http.StatusBadRequest, "Некорректный период аренды")
+			return
+		}
+
+		car, ok := findCar(req.CarID)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "Автомобиль не найден")
+			return
+		}
+		if req.PickupBranchID == 0 {
+			req.PickupBranchID = car.BranchID
+		}
+		if car.BranchID != req.PickupBranchID {
+			writeErrorCode(w, r, http.StatusConflict, "wrong_branch", "Автомобиль недоступен в выбранном филиале")
+			return
+		}
+		if req.DropoffBranchID == 0 {
+			req.DropoffBranchID = req.PickupBranchID
+		}
+		if _, ok := findBranch(req.DropoffBranchID); !ok {
+			writeError(w, r, http.StatusBadRequest, "Филиал возврата не найден")
+			return
+		}
+
+		busy, err := busyItems("car", start, end)
+		if err != nil {
+			log.Println("Ошибка расчёта стоимости:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		offer, err := carOffer(car, start, end, time.Now(), busy, req.PickupBranchID, req.DropoffBranchID, car.Price.Currency)
+		if err != nil {
+			log.Println("Ошибка расчёта стоимости:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка расчёта стоимости")
+			return
+		}
+		total := offer.TotalPrice
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		defer tx.Rollback()
+
+		// Проверка и вставка выполняются под блокировкой автомобиля
+		if err := lockItem(tx, "car", car.ID); err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		available, err := itemAvailableFor(tx, "car", car.ID, start, end, userID, 0)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if !available {
+			writeErrorCode(w, r, http.StatusConflict, "item_unavailable", "Автомобиль уже забронирован на эти даты")
+			return
+		}
+
+		// Неоплаченное бронирование держит автомобиль столько же, сколько удержание
+		var bookingID int
+		var expiresAt time.Time
+		err = tx.QueryRow(`INSERT INTO bookings (item_type, item_id, user_id, start_date, end_date, total_price, currency, pickup_branch_id, dropoff_branch_id, one_way_fee, expires_at)
+			VALUES ('car', $1, $2, $3, $4, $5, $6, $7, $8, $9, NOW() + make_interval(secs => $10)) RETURNING id, expires_at`,
+			car.ID, userID, start, end, total.Amount, total.Currency, req.PickupBranchID, req.DropoffBranchID, offer.OneWayFee.Amount,
+			holdTTL.Seconds()).Scan(&bookingID, &expiresAt)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Автомобиль забронирован", map[string]interface{}{
+			"booking_id":  bookingID,
+			"total_price": total,
+			"one_way_fee": offer.OneWayFee,
+			"quote":       offer.Quote.Localized(r),
+			"expires_at":  expiresAt,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Ссылка на бронирование в запросах приёмки и отмены
+type BookingRef struct {
+	BookingID int `json:"booking_id" validate:"required"`
+}
+
+// Обработчик приёма автомобиля в филиале: прокат завершается,
+// а автомобиль числится в филиале возврата
+func adminReturnHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var req BookingRef
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		defer tx.Rollback()
+
+		var carID, branchID int
+		err = tx.QueryRow(`SELECT item_id, dropoff_branch_id FROM bookings WHERE id = $1 AND item_type = 'car'`,
+			req.BookingID).Scan(&carID, &branchID)
+		if err == nil {
+			err = transitionBooking(tx, req.BookingID, bookingCompleted)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Println("Ошибка приёма автомобиля:", err)
+			writeError(w, r, http.StatusConflict, "Выданный автомобиль по этой аренде не найден")
+			return
+		}
+
+		moveCar(carID, branchID)
+
+		writeSuccess(w, r, http.StatusOK, "Автомобиль принят", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос смены статуса бронирования администратором
+type BookingStatusRequest struct {
+	BookingID int    `json:"booking_id" validate:"required"`
+	Status    string `json:"status" validate:"required,oneof=checked_in|no_show|completed"`
+}
+
+// Обработчик смены статуса бронирования сотрудником: заселение или выдача автомобиля,
+// отметка о неявке, завершение проживания
+func adminBookingStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		// Отмена и оплата идут через свои обработчики с расчётом возврата и платежом
+		var req BookingStatusRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		// Завершение аренды автомобиля выполняется через приём в филиале
+		var itemType string
+		var itemID int
+		err := db.QueryRow(`SELECT item_type, item_id FROM bookings WHERE id = $1`, req.BookingID).Scan(&itemType, &itemID)
+		if err == sql.ErrNoRows {
+			writeError(w, r, http.StatusNotFound, "Бронирование не найдено")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if itemType == "car" && req.Status == bookingCompleted {
+			writeError(w, r, http.StatusBadRequest, "Используйте приём автомобиля в филиале")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		defer tx.Rollback()
+
+		err = transitionBooking(tx, req.BookingID, req.Status)
+		if errors.Is(err, errBookingNotFound) {
+			writeError(w, r, http.StatusNotFound, "Бронирование не найдено")
+			return
+		}
+		if errors.Is(err, errInvalidTransition) {
+			writeErrorCode(w, r, http.StatusConflict, "invalid_status_transition", "Недопустимый переход статуса")
+			return
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		// Неявка освобождает оставшиеся дни
+		if req.Status == bookingNoShow {
+			notifyWaitlist(itemType, itemID)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Статус бронирования обновлён", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Бронирование в списке пользователя
+type Booking struct {
+	ID                 int                `json:"id"`
+	ItemType           string             `json:"item_type"`
+	ItemID             int                `json:"item_id"`
+	ItemName           string             `json:"item_name"`
+	StartDate          string             `json:"start_date"`
+	EndDate            string             `json:"end_date"`
+	Status             string             `json:"status"`
+	Total              Money              `json:"total"`
+	Paid               Money              `json:"paid"`
+	RefundAmount       *Money             `json:"refund_amount,omitempty"`       // Возвращено при отмене
+	RefundStatus       string             `json:"refund_status,omitempty"`       // Состояние возврата refund_amount
+	CancellationRefund *Money             `json:"cancellation_refund,omitempty"` // Будет возвращено, если отменить сейчас
+	Policy             CancellationPolicy `json:"cancellation_policy"`
+	TripID             *int               `json:"trip_id,omitempty"`
+	PackageDiscount    *Money             `json:"package_discount,omitempty"` // Скидка за пакет, учтённая в Total
+	ExpiresAt          *time.Time         `json:"expires_at,omitempty"`       // Срок оплаты только что созданного бронирования
+}
+
+// Состояния возврата денег при отмене или изменении бронирования
+const (
+	refundPending   = "pending"   // Возврат записан, провайдер ещё не ответил
+	refundCompleted = "completed" // Деньги возвращены
+	refundFailed    = "failed"    // Провайдер отказал, возврат выполняется вручную
+)
+
+// Возврат по отменённому бронированию или поездке
+type RefundResult struct {
+	Amount Money
+	Status string // Пусто, если возвращать нечего
+}
+
+// Название объекта для отображения
+func itemName(itemType string, itemID int) string {
+	switch itemType {
+	case "car":
+		if car, ok := findCar(itemID); ok {
+			return car.Model
+		}
+	case "room":
+		if room, ok := findRoom(itemID); ok {
+			return room.Hotel + ", " + room.Type
+		}
+	}
+	return ""
+}
+
+// Сумма, фактически списанная по бронированию
+func paidAmount(q dbtx, bookingID int, currency string) (Money, error) {
+	paid := Money{Currency: currency}
+	err := q.QueryRow(`SELECT COALESCE(SUM(amount - refunded_amount), 0) FROM payments
+		WHERE booking_id = $1 AND status IN ('captured', 'refunded')`, bookingID).Scan(&paid.Amount)
+	return paid, err
+}
+
+// Функция загрузки бронирований пользователя с суммами оплаты и условиями отмены
+func loadUserBookings(userID int, now time.Time) ([]Booking, error) {
+	rows, err := db.Query(`SELECT id, item_type, item_id, start_date, end_date, status, total_price, currency, refund_amount, COALESCE(refund_status, ''), trip_id
+		FROM bookings WHERE user_id = $1 ORDER BY start_date DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookings := []Booking{}
+	for rows.Next() {
+		var b Booking
+		var start, end time.Time
+		var refund sql.NullInt64
+		if err := rows.Scan(&b.ID, &b.ItemType, &b.ItemID, &start, &end, &b.Status, &b.Total.Amount, &b.Total.Currency, &refund, &b.RefundStatus, &b.TripID); err != nil {
+			return nil, err
+		}
+		b.StartDate = start.Format(dateLayout)
+		b.EndDate = end.Format(dateLayout)
+		b.ItemName = itemName(b.ItemType, b.ItemID)
+		b.Policy = policyFor(b.ItemType, b.ItemID)
+		if refund.Valid {
+			b.RefundAmount = &Money{refund.Int64, b.Total.Currency}
+		}
+		bookings = append(bookings, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range bookings {
+		b := &bookings[i]
+		b.Paid, err = paidAmount(db, b.ID, b.Total.Currency)
+		if err != nil {
+			return nil, err
+		}
+		start, _ := time.Parse(dateLayout, b.StartDate)
+		if canTransition(b.Status, bookingCancelled) && now.Before(start) {
+			refund := b.Policy.Refund(b.Paid, start, now)
+			b.CancellationRefund = &refund
+		}
+	}
+	return bookings, nil
+}
+
+// Обработчик списка бронирований текущего пользователя
+func handleMyBookings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		bookings, err := loadUserBookings(userID, time.Now())
+		if err != nil {
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", bookings)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Функция отмены бронирования пользователем с возвратом по правилам отмены.
+// Статус меняется до обращения к провайдеру: если возврат не пройдёт,
+// бронирование останется отменённым, а возврат получит статус failed для ручной обработки
+func cancelBooking(userID, bookingID int, now time.Time) (RefundResult, error) {
+	return cancelTripBooking(userID, bookingID, now, nil)
+}
+
+// То же для бронирования, которое отменяется вместе с другими бронированиями
+// поездки из cancelling: они не считаются оставшимися в пакете
+func cancelTripBooking(userID, bookingID int, now time.Time, cancelling map[int]bool) (RefundResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return RefundResult{}, err
+	}
+	defer tx.Rollback()
+
+	var itemType string
+	var itemID int
+	var start time.Time
+	var currency string
+	var tripID sql.NullInt64
+	err = tx.QueryRow(`SELECT item_type, item_id, start_date, currency, trip_id FROM bookings WHERE id = $1 AND user_id = $2`,
+		bookingID, userID).Scan(&itemType, &itemID, &start, &currency, &tripID)
+	if err == sql.ErrNoRows {
+		return RefundResult{}, errBookingNotFound
+	}
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if !now.Before(start) {
+		return RefundResult{}, fmt.Errorf("%w: бронирование уже началось", errInvalidTransition)
+	}
+	if err := transitionBooking(tx, bookingID, bookingCancelled); err != nil {
+		return RefundResult{}, err
+	}
+
+	paid, err := paidAmount(tx, bookingID, currency)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	refund := policyFor(itemType, itemID).Refund(paid, start, now)
+	if tripID.Valid {
+		withdrawn, err := withdrawPackageDiscount(tx, int(tripID.Int64), cancelling)
+		if err != nil {
+			return RefundResult{}, err
+		}
+		refund.Amount -= min(withdrawn, refund.Amount)
+	}
+	// Пока провайдер не ответил, возврат записан как ожидающий
+	var status sql.NullString
+	if refund.Amount > 0 {
+		status = sql.NullString{String: refundPending, Valid: true}
+	}
+	_, err = tx.Exec(`UPDATE bookings SET refund_amount = $1, refund_status = $2 WHERE id = $3`, refund.Amount, status, bookingID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefundResult{}, err
+	}
+
+	result := RefundResult{Amount: refund}
+	if refund.Amount > 0 {
+		result.Status = settleRefund(bookingID, refund)
+	}
+	notifyWaitlist(itemType, itemID)
+	return result, nil
+}
+
+// Функция возврата по отменённому бронированию: результат записывается
+// в refund_status, чтобы неудавшийся возврат был виден пользователю и поддержке
+func settleRefund(bookingID int, amount Money) string {
+	status := refundCompleted
+	if err := refundBooking(bookingID, amount); err != nil {
+		log.Printf("Возврат %s по бронированию #%d не выполнен: %v", amount, bookingID, err)
+		status = refundFailed
+	}
+	if _, err := db.Exec(`UPDATE bookings SET refund_status = $1 WHERE id = $2`, status, bookingID); err != nil {
+		log.Printf("Ошибка SQL: %v", err)
+	}
+	return status
+}
+
+// Функция распределения возврата по списанным платежам бронирования
+func refundBooking(bookingID int, amount Money) error {
+	rows, err := db.Query(`SELECT id, amount - refunded_amount FROM payments
+		WHERE booking_id = $1 AND status = 'captured' ORDER BY id`, bookingID)
+	if err != nil {
+		return err
+	}
+	type refundable struct {
+		paymentID int
+		left      int64
+	}
+	var payments []refundable
+	for rows.Next() {
+		var p refundable
+		if err := rows.Scan(&p.paymentID, &p.left); err != nil {
+			rows.Close()
+			return err
+		}
+		payments = append(payments, p)
+	}
+	rows.Close()
+
+	remaining := amount.Amount
+	for _, p := range payments {
+		if remaining == 0 {
+			break
+		}
+		part := p.left
+		if part > remaining {
+			part = remaining
+		}
+		if _, err := refundPayment(p.paymentID, Money{part, amount.Currency}); err != nil {
+			return err
+		}
+		remaining -= part
+	}
+	return nil
+}
+
+// Обработчик отмены бронирования пользователем
+func handleCancelBooking(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req BookingRef
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		refund, err := cancelBooking(userID, req.BookingID, time.Now())
+		if errors.Is(err, errBookingNotFound) {
+			writeError(w, r, http.StatusNotFound, "Бронирование не найдено")
+			return
+		}
+		if errors.Is(err, errInvalidTransition) {
+			writeErrorCode(w, r, http.StatusConflict, "booking_not_cancellable", "Это бронирование нельзя отменить")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка отмены бронирования: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		message := "Бронирование отменено"
+		if refund.Status == refundFailed {
+			message = "Бронирование отменено, но вернуть деньги не удалось. Мы вернём их вручную"
+		}
+		writeSuccess(w, r, http.StatusOK, message, map[string]interface{}{
+			"refund":        refund.Amount,
+			"refund_status": refund.Status,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
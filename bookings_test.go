@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест разрешённых переходов статусов бронирования
+func TestCanTransition(t *testing.T) {
+	assert.True(t, canTransition(bookingPending, bookingConfirmed))
+	assert.True(t, canTransition(bookingConfirmed, bookingCheckedIn))
+	assert.True(t, canTransition(bookingConfirmed, bookingNoShow))
+	assert.True(t, canTransition(bookingCheckedIn, bookingCompleted))
+
+	assert.False(t, canTransition(bookingPending, bookingCheckedIn), "Нельзя заселиться без оплаты")
+	assert.False(t, canTransition(bookingCheckedIn, bookingCancelled), "Нельзя отменить начавшееся бронирование")
+	assert.False(t, canTransition(bookingCancelled, bookingConfirmed))
+	assert.False(t, canTransition(bookingCompleted, bookingCancelled))
+}
+
+// Тест расчёта возврата по правилам отмены
+func TestCancellationPolicyRefund(t *testing.T) {
+	policy := cancellationPolicies["moderate"] // бесплатно за 72 часа, позже 50%
+	start := date("2025-07-10")
+	paid := major(300, "USD")
+
+	assert.Equal(t, paid, policy.Refund(paid, start, start.Add(-72*time.Hour)))
+	assert.Equal(t, major(150, "USD"), policy.Refund(paid, start, start.Add(-71*time.Hour)))
+	assert.Equal(t, Money{0, "USD"}, cancellationPolicies["strict"].Refund(paid, start, start.Add(-24*time.Hour)))
+}
+
+// Тест: неоплаченное бронирование с истёкшим сроком нельзя подтвердить
+func TestTransitionBookingExpiredPending(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status, COALESCE\\(expires_at <= NOW\\(\\), false\\) FROM bookings").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "expired"}).AddRow(bookingPending, true))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	err = transitionBooking(tx, 7, bookingConfirmed)
+	assert.ErrorIs(t, err, errHoldExpired)
+	assert.ErrorIs(t, err, errInvalidTransition)
+	tx.Rollback()
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: смена статуса неизвестного бронирования возвращает 404, ошибка базы — 500
+func TestAdminBookingStatusLookupErrors(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT item_type, item_id FROM bookings").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"item_type", "item_id"}))
+	mock.ExpectQuery("SELECT item_type, item_id FROM bookings").WithArgs(5).
+		WillReturnError(sqlmock.ErrCancelled)
+
+	body := `{"booking_id": 5, "status": "completed"}`
+	rr := httptest.NewRecorder()
+	adminBookingStatusHandler(rr, httptest.NewRequest("POST", "/admin/bookings/status", strings.NewReader(body)))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	adminBookingStatusHandler(rr, httptest.NewRequest("POST", "/admin/bookings/status", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: возврат при отмене записывается как ожидающий, а отказ провайдера
+// сохраняется в refund_status и возвращается клиенту
+func TestCancelBookingRefundFailed(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	defer func(prev PaymentProvider) { paymentProvider = prev }(paymentProvider)
+	paymentProvider = newSandboxProvider("secret")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT item_type, item_id, start_date, currency, trip_id FROM bookings").
+		WithArgs(9, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"item_type", "item_id", "start_date", "currency", "trip_id"}).
+			AddRow("room", 1, date("2025-07-01"), "USD", nil))
+	mock.ExpectQuery("SELECT status, COALESCE\\(expires_at <= NOW\\(\\), false\\) FROM bookings").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "expired"}).AddRow(bookingConfirmed, false))
+	mock.ExpectExec("UPDATE bookings SET status").WithArgs(bookingCancelled, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount - refunded_amount\\), 0\\) FROM payments").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(20000))
+	mock.ExpectExec("UPDATE bookings SET refund_amount = \\$1, refund_status = \\$2 WHERE id = \\$3").
+		WithArgs(int64(20000), refundPending, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Провайдер не знает платёж — возврат не проходит
+	mock.ExpectQuery("SELECT id, amount - refunded_amount FROM payments").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "left"}).AddRow(5, 20000))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, booking_id, provider, provider_ref").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "booking_id", "provider", "provider_ref", "status", "amount", "refunded_amount", "currency"}).
+			AddRow(5, 9, "sandbox", "sb_unknown", paymentCaptured, int64(20000), int64(0), "USD"))
+	mock.ExpectRollback()
+	mock.ExpectExec("UPDATE bookings SET refund_status = \\$1 WHERE id = \\$2").
+		WithArgs(refundFailed, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Лист ожидания обрабатывается после отмены; его ошибка только логируется
+	mock.ExpectBegin().WillReturnError(assert.AnError)
+
+	refund, err := cancelBooking(3, 9, time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, RefundResult{Amount: major(200, "USD"), Status: refundFailed}, refund)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: бронирование автомобиля с получением сегодня или в прошлом отклоняется
+func TestHandleCarBookingRejectsPastPickup(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	today := time.Now().UTC().Format(dateLayout)
+	for _, pickup := range []string{"2020-01-01", today} {
+		mock.ExpectQuery("SELECT user_id, mfa FROM sessions").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "mfa"}).AddRow(3, false))
+
+		body := `{"car_id": 1, "pickup_date": "` + pickup + `", "dropoff_date": "2099-01-01"}`
+		req := httptest.NewRequest("POST", "/cars/book", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "token"})
+		rr := httptest.NewRecorder()
+		handleCarBooking(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "получение %s", pickup)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Филиал проката, где выдают и принимают автомобили
+type Branch struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name" validate:"required,max=100"`
+	City    string `json:"city" validate:"required,max=100"`
+	Address string `json:"address" validate:"max=255"`
+}
+
+var branches = []Branch{
+	{1, "Алматы Центр", "Алматы", "пр. Абая, 10"},
+	{2, "Астана Аэропорт", "Астана", "Аэропорт Нурсултан Назарбаев"},
+	{3, "Москва Шереметьево", "Москва", "Аэропорт Шереметьево, терминал B"},
+}
+
+// Защищает cars, rooms и branches: каталог меняется из админки и при возврате автомобилей
+var catalogMu sync.RWMutex
+
+// Доплата за возврат автомобиля в другой филиал, в целых единицах базовой валюты
+var oneWayFee = major(int64(envInt("ONE_WAY_FEE", 30)), baseCurrency)
+
+// Функция поиска филиала по идентификатору
+func findBranch(id int) (Branch, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for _, b := range branches {
+		if b.ID == id {
+			return b, true
+		}
+	}
+	return Branch{}, false
+}
+
+// Функция поиска автомобиля по идентификатору
+func findCar(id int) (Car, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for _, c := range cars {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Car{}, false
+}
+
+// Функция перемещения автомобиля в другой филиал
+func moveCar(carID, branchID int) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	for i := range cars {
+		if cars[i].ID == carID {
+			cars[i].BranchID = branchID
+			return
+		}
+	}
+}
+
+// Доплата за аренду в одну сторону в валюте автомобиля
+func oneWayFeeFor(pickupBranchID, dropoffBranchID int, currency string) (Money, error) {
+	if dropoffBranchID == 0 || dropoffBranchID == pickupBranchID {
+		return Money{Currency: currency}, nil
+	}
+	return convertMoney(oneWayFee, currency)
+}
+
+// Каталог хранится в памяти, поэтому после перезапуска местоположение
+// автомобилей восстанавливается по последнему завершённому прокату
+func restoreCarLocations() error {
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (item_id) item_id, dropoff_branch_id FROM bookings
+		WHERE item_type = 'car' AND status = 'completed' AND dropoff_branch_id IS NOT NULL
+		ORDER BY item_id, end_date DESC, id DESC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var carID, branchID int
+		if err := rows.Scan(&carID, &branchID); err != nil {
+			return err
+		}
+		moveCar(carID, branchID)
+	}
+	return rows.Err()
+}
+
+// Обработчик списка филиалов
+func handleBranches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		catalogMu.RLock()
+		defer catalogMu.RUnlock()
+		writeSuccess(w, r, http.StatusOK, "", branches)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик для добавления филиала администратором
+func adminBranchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var branch Branch
+		if !decodeAndValidate(w, r, &branch) {
+			return
+		}
+
+		catalogMu.Lock()
+		branch.ID = len(branches) + 1
+		branches = append(branches, branch)
+		catalogMu.Unlock()
+
+		log.Println("Добавлен филиал:", branch.Name)
+		writeSuccess(w, r, http.StatusCreated, "Филиал добавлен", branch)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
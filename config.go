@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Функция чтения целочисленного параметра из переменной окружения
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// Функция чтения строкового параметра из переменной окружения
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Адрес сайта для ссылок в письмах
+var appBaseURL = envString("APP_BASE_URL", "http://localhost:8080")
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Политика CORS для всего сервера. Разрешённые источники задаются списком через
+// запятую в CORS_ALLOWED_ORIGINS (по умолчанию — адрес самого сайта). Для них
+// ответ разрешает cookie сессии (Allow-Credentials), поэтому вместо * в
+// Allow-Origin возвращается конкретный источник. Значение * разрешает любые
+// источники, но уже без cookie — браузеры не принимают * вместе с credentials
+type corsPolicy struct {
+	origins     map[string]bool
+	anyOrigin   bool
+	methods     map[string]bool
+	headers     map[string]bool
+	allowMethod string
+	allowHeader string
+	maxAge      int
+}
+
+// Заголовки ответа, которые может читать скрипт на другом источнике
+const corsExposeHeaders = "X-Request-ID, Retry-After, Idempotent-Replayed, X-RateLimit-Limit"
+
+var cors = newCORSPolicy(
+	strings.Split(envString("CORS_ALLOWED_ORIGINS", appBaseURL), ","),
+	strings.Split(envString("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE"), ","),
+	strings.Split(envString("CORS_ALLOWED_HEADERS", "Content-Type,Authorization,X-API-Key,Idempotency-Key,X-Request-ID,X-CSRF-Token"), ","),
+	envInt("CORS_MAX_AGE_SECONDS", 600),
+)
+
+func newCORSPolicy(origins, methods, headers []string, maxAge int) *corsPolicy {
+	p := &corsPolicy{
+		origins: map[string]bool{},
+		methods: map[string]bool{},
+		headers: map[string]bool{},
+		maxAge:  maxAge,
+	}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			p.anyOrigin = true
+		} else if origin != "" {
+			p.origins[normalizeOrigin(origin)] = true
+		}
+	}
+
+	var allowMethods, allowHeaders []string
+	for _, m := range methods {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			p.methods[m] = true
+			allowMethods = append(allowMethods, m)
+		}
+	}
+	for _, h := range headers {
+		if h = strings.TrimSpace(h); h != "" {
+			p.headers[http.CanonicalHeaderKey(h)] = true
+			allowHeaders = append(allowHeaders, h)
+		}
+	}
+	p.allowMethod = strings.Join(allowMethods, ", ")
+	p.allowHeader = strings.Join(allowHeaders, ", ")
+	return p
+}
+
+// Источник сравнивается как схема://хост[:порт] в нижнем регистре, без пути и слеша в конце
+func normalizeOrigin(origin string) string {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return strings.ToLower(strings.TrimRight(origin, "/"))
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// Функция проверки источника. Второй результат — можно ли передавать cookie
+func (p *corsPolicy) allowOrigin(origin string) (bool, bool) {
+	if p.origins[normalizeOrigin(origin)] {
+		return true, true
+	}
+	return p.anyOrigin, false
+}
+
+// Функция проверки заголовков из Access-Control-Request-Headers
+func (p *corsPolicy) allowHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.TrimSpace(h); h != "" && !p.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Промежуточный обработчик CORS. Запросы без Origin (тот же сайт, curl,
+// серверы партнёров) проходят без изменений. Предварительные запросы OPTIONS
+// обрабатываются здесь и до обработчиков не доходят
+func withCORS(p *corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		allowed, credentials := p.allowOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed || !p.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] ||
+				!p.allowHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+				// Без заголовков Allow-* браузер не отправит основной запрос
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		if !allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if credentials {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", p.allowMethod)
+			w.Header().Set("Access-Control-Allow-Headers", p.allowHeader)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
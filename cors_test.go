@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: предварительный запрос с разрешённого источника получает разрешения
+// с credentials и не доходит до обработчика
+func TestCORSPreflight(t *testing.T) {
+	policy := newCORSPolicy([]string{"https://bookeasy.kz/", "https://partner.example"}, []string{"GET", "POST"}, []string{"Content-Type", "Idempotency-Key"}, 600)
+	handler := withCORS(policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("обработчик не должен вызываться")
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/login", nil)
+	req.Header.Set("Origin", "https://BookEasy.kz")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, idempotency-key")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://BookEasy.kz", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+
+	// Чужой источник, неразрешённый метод или заголовок
+	for _, c := range []struct{ origin, method, headers string }{
+		{"https://evil.example", "POST", ""},
+		{"https://partner.example", "DELETE", ""},
+		{"https://partner.example", "POST", "X-Custom"},
+	} {
+		req := httptest.NewRequest("OPTIONS", "/login", nil)
+		req.Header.Set("Origin", c.origin)
+		req.Header.Set("Access-Control-Request-Method", c.method)
+		req.Header.Set("Access-Control-Request-Headers", c.headers)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code, c.origin+" "+c.method)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// Тест: обычные запросы проходят к обработчику, заголовки CORS получает только
+// разрешённый источник; * разрешает любой источник, но без cookie
+func TestCORSSimpleRequests(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })
+
+	handler := withCORS(newCORSPolicy([]string{"https://bookeasy.kz"}, []string{"GET"}, nil, 0), next)
+	req := httptest.NewRequest("GET", "/messages", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, 1, called)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", rr.Header().Get("Vary"))
+
+	handler = withCORS(newCORSPolicy([]string{"*"}, []string{"GET"}, nil, 0), next)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, 2, called)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID")
+}
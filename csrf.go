@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Защита от CSRF для изменяющих запросов (POST, PUT, DELETE и т. д.).
+// Токен привязан к сессии: это SHA-256 от секретного значения cookie сессии,
+// поэтому другой сайт не может его вычислить, а после входа или выхода он
+// меняется. До входа вместо сессии используется случайное значение из cookie
+// csrf_seed. Страницы получают токен через /csrf-token и передают его
+// в заголовке X-CSRF-Token (см. static/csrf.js)
+const (
+	csrfHeader     = "X-CSRF-Token"
+	csrfSeedCookie = "csrf_seed"
+)
+
+// Функция вычисления токена для текущего браузера; false, если нет ни сессии, ни csrf_seed
+func csrfToken(r *http.Request) (string, bool) {
+	for _, name := range []string{sessionCookieName, csrfSeedCookie} {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return hashToken("csrf:" + cookie.Value), true
+		}
+	}
+	return "", false
+}
+
+// Запросы, которые не проверяются: безопасные методы, webhook платёжного
+// провайдера (подписан HMAC) и запросы партнёров с ключом API, которые не
+// используют cookie
+func csrfExempt(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	if r.URL.Path == "/payments/webhook" {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/v1/") && apiKeyFromRequest(r) != ""
+}
+
+// Промежуточный обработчик проверки CSRF-токена
+func withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csrfExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected, ok := csrfToken(r)
+		got := r.Header.Get(csrfHeader)
+		if !ok || got == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(got)) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			writeErrorCode(w, r, http.StatusForbidden, "csrf_invalid", "Недействительный CSRF-токен, обновите страницу")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Обработчик выдачи CSRF-токена странице. Если браузер ещё не вошёл и у него нет
+// csrf_seed, cookie создаётся здесь
+func handleCSRFToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodGet {
+		token, ok := csrfToken(r)
+		if !ok {
+			seed, err := randomToken()
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfSeedCookie,
+				Value:    seed,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			token = hashToken("csrf:" + seed)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", map[string]string{"token": token})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: изменяющий запрос проходит только с токеном своей сессии
+func TestWithCSRF(t *testing.T) {
+	called := 0
+	handler := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+	send := func(method, path, token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if token != "" {
+			req.Header.Set(csrfHeader, token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	session := &http.Cookie{Name: sessionCookieName, Value: "session-a"}
+
+	rr := send("POST", "/clear-messages", "", session)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"csrf_invalid"`)
+
+	rr = send("POST", "/clear-messages", hashToken("csrf:session-b"), session)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "токен другой сессии")
+
+	send("POST", "/clear-messages", hashToken("csrf:session-a"), session)
+	send("DELETE", "/admin/cars", hashToken("csrf:seed"), &http.Cookie{Name: csrfSeedCookie, Value: "seed"})
+	assert.Equal(t, 2, called)
+
+	// Безопасные методы, webhook и запросы партнёров с ключом API не проверяются
+	send("GET", "/messages", "", session)
+	send("POST", "/payments/webhook", "")
+	req := httptest.NewRequest("POST", "/api/v1/holds", nil)
+	req.Header.Set("X-API-Key", "bk_key")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 5, called)
+
+	rr = send("POST", "/api/v1/holds", "", session)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "без ключа API запрос считается браузерным")
+}
+
+// Тест: до входа /csrf-token выдаёт cookie csrf_seed и соответствующий ему токен
+func TestHandleCSRFToken(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleCSRFToken(rr, httptest.NewRequest("GET", "/csrf-token", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	cookies := rr.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, csrfSeedCookie, cookies[0].Name)
+		assert.True(t, cookies[0].HttpOnly)
+	}
+	var resp struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, hashToken("csrf:"+cookies[0].Value), resp.Data.Token)
+
+	// После входа токен вычисляется из сессии, новая cookie не нужна
+	req := httptest.NewRequest("GET", "/csrf-token", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "session-a"})
+	rr = httptest.NewRecorder()
+	handleCSRFToken(rr, req)
+	assert.Empty(t, rr.Result().Cookies())
+	assert.Contains(t, rr.Body.String(), hashToken("csrf:session-a"))
+}
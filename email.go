@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/smtp"
+	"strings"
+)
+
+// Интерфейс отправки писем; в тестах подменяется заглушкой
+type EmailSender interface {
+	SendEmail(to []string, subject, body string) error
+}
+
+// Отправка писем через SMTP-сервер почтового ящика поддержки
+type smtpEmailSender struct {
+	host     string
+	port     string
+	from     string
+	password string
+}
+
+func (s *smtpEmailSender) SendEmail(to []string, subject, body string) error {
+	auth := smtp.PlainAuth("", s.from, s.password, s.host)
+	msg := []byte("To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"\r\n" + body + "\r\n")
+	return smtp.SendMail(s.host+":"+s.port, auth, s.from, to, msg)
+}
+
+var emailSender EmailSender = &smtpEmailSender{
+	host:     envString("SMTP_HOST", "smtp.mail.ru"),
+	port:     envString("SMTP_PORT", "587"),
+	from:     envString("SMTP_FROM", "bookeasy_help@mail.ru"),
+	password: envString("SMTP_PASSWORD", "L1sFSSHs1qax2Cy3ssxN"),
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Архивы с данными пользователей и их параметры: срок действия ссылки,
+// минимальный интервал между запросами и периодичность очистки
+const exportsDir = "./exports"
+
+var (
+	exportLifetime      = time.Duration(envInt("EXPORT_HOURS", 48)) * time.Hour
+	exportCooldown      = time.Duration(envInt("EXPORT_COOLDOWN_MINUTES", 60)) * time.Minute
+	exportPurgeInterval = time.Duration(envInt("EXPORT_PURGE_MINUTES", 30)) * time.Minute
+)
+
+// Статусы выгрузки данных
+const (
+	exportPending = "pending"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+var errExportTooSoon = errors.New("выгрузка уже запрошена недавно")
+
+// Сообщение чата пользователя
+type ExportMessage struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+}
+
+// Обращение в поддержку. attachment — путь к вложению внутри архива
+type SupportTicket struct {
+	ID         int    `json:"id"`
+	Email      string `json:"email"`
+	Message    string `json:"message"`
+	Attachment string `json:"attachment,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// Все данные пользователя для выгрузки. files — загруженные файлы:
+// путь внутри архива и путь на диске
+type userExport struct {
+	Profile  Profile
+	Bookings []Booking
+	Messages []ExportMessage
+	Tickets  []SupportTicket
+	files    map[string]string
+}
+
+// Функция регистрации запроса на выгрузку. Пока предыдущий архив готовится
+// или с прошлого запроса прошло меньше exportCooldown, новый не создаётся
+func requestExport(userID int) (int, error) {
+	var exportID int
+	err := db.QueryRow(`INSERT INTO data_exports (user_id, status)
+		SELECT $1, $2 WHERE NOT EXISTS (
+			SELECT 1 FROM data_exports WHERE user_id = $1
+			AND (status = $2 OR created_at > NOW() - make_interval(secs => $3))
+		) RETURNING id`, userID, exportPending, exportCooldown.Seconds()).Scan(&exportID)
+	if err == sql.ErrNoRows {
+		return 0, errExportTooSoon
+	}
+	return exportID, err
+}
+
+// Функция сбора данных пользователя из базы
+func collectUserData(userID int) (userExport, error) {
+	data := userExport{files: map[string]string{}}
+
+	var err error
+	data.Profile, err = loadProfile(userID)
+	if err != nil {
+		return data, err
+	}
+	if data.Profile.AvatarURL != "" {
+		name := filepath.Base(data.Profile.AvatarURL)
+		data.files["avatar/"+name] = filepath.Join(avatarsDir, name)
+	}
+
+	data.Bookings, err = loadUserBookings(userID, time.Now())
+	if err != nil {
+		return data, err
+	}
+
+	rows, err := db.Query(`SELECT id, content FROM messages WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+	data.Messages = []ExportMessage{}
+	for rows.Next() {
+		var m ExportMessage
+		if err := rows.Scan(&m.ID, &m.Content); err != nil {
+			return data, err
+		}
+		data.Messages = append(data.Messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return data, err
+	}
+
+	rows, err = db.Query(`SELECT id, email, message, COALESCE(attachment, ''), created_at
+		FROM support_messages WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+	data.Tickets = []SupportTicket{}
+	for rows.Next() {
+		var t SupportTicket
+		var attachment string
+		var created time.Time
+		if err := rows.Scan(&t.ID, &t.Email, &t.Message, &attachment, &created); err != nil {
+			return data, err
+		}
+		t.CreatedAt = created.Format(time.RFC3339)
+		if attachment != "" {
+			t.Attachment = "attachments/" + attachment
+			data.files[t.Attachment] = filepath.Join(uploadsDir, attachment)
+		}
+		data.Tickets = append(data.Tickets, t)
+	}
+	return data, rows.Err()
+}
+
+// Функция записи ZIP-архива: данные в JSON-файлах и загруженные файлы.
+// Файлы, которых уже нет на диске, пропускаются
+func writeExportArchive(w io.Writer, data userExport) error {
+	zw := zip.NewWriter(w)
+
+	documents := []struct {
+		name  string
+		value interface{}
+	}{
+		{"profile.json", data.Profile},
+		{"bookings.json", data.Bookings},
+		{"messages.json", data.Messages},
+		{"support_tickets.json", data.Tickets},
+	}
+	for _, doc := range documents {
+		f, err := zw.Create(doc.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc.value); err != nil {
+			return err
+		}
+	}
+
+	for name, diskPath := range data.files {
+		src, err := os.Open(diskPath)
+		if os.IsNotExist(err) {
+			log.Printf("Файл %s для выгрузки не найден", diskPath)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f, err := zw.Create(name)
+		if err == nil {
+			_, err = io.Copy(f, src)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// Фоновая задача сборки архива. По готовности пользователю отправляется
+// письмо со ссылкой; в базе хранится только хеш токена из ссылки
+func buildExport(exportID, userID int, email, locale string) {
+	name, err := createExportFile(userID)
+	if err != nil {
+		log.Printf("Ошибка сборки выгрузки %d: %v", exportID, err)
+		if _, err := db.Exec(`UPDATE data_exports SET status = $1 WHERE id = $2`, exportFailed, exportID); err != nil {
+			log.Printf("Ошибка обновления выгрузки %d: %v", exportID, err)
+		}
+		return
+	}
+
+	token, err := randomToken()
+	if err == nil {
+		_, err = db.Exec(`UPDATE data_exports SET status = $1, file = $2, token_hash = $3,
+			completed_at = NOW(), expires_at = NOW() + make_interval(secs => $4) WHERE id = $5`,
+			exportReady, name, hashToken(token), exportLifetime.Seconds(), exportID)
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения выгрузки %d: %v", exportID, err)
+		removeExportFile(name)
+		return
+	}
+
+	subject, body := renderEmail(locale, "data_export", appBaseURL, token, int(exportLifetime.Hours()))
+	if err := emailSender.SendEmail([]string{email}, subject, body); err != nil {
+		log.Printf("Ошибка отправки письма о выгрузке %d: %v", exportID, err)
+	}
+}
+
+// Функция создания файла архива; возвращает имя файла в exportsDir
+func createExportFile(userID int) (string, error) {
+	data, err := collectUserData(userID)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(exportsDir, 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(exportsDir, "export-*.zip")
+	if err != nil {
+		return "", err
+	}
+	err = writeExportArchive(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return filepath.Base(f.Name()), nil
+}
+
+// Функция удаления файла архива
+func removeExportFile(name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(exportsDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Ошибка удаления архива %s: %v", name, err)
+	}
+}
+
+// Функция удаления просроченных архивов и выгрузок, прерванных перезапуском сервера
+func purgeExpiredExports() error {
+	rows, err := db.Query(`DELETE FROM data_exports
+		WHERE expires_at < NOW() OR status = $1
+			OR (status = $2 AND created_at < NOW() - INTERVAL '1 hour')
+		RETURNING COALESCE(file, '')`, exportFailed, exportPending)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		removeExportFile(name)
+	}
+	return rows.Err()
+}
+
+// Фоновая задача, периодически удаляющая просроченные архивы
+func runExportPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := purgeExpiredExports(); err != nil {
+			log.Printf("Ошибка удаления просроченных архивов: %v", err)
+		}
+	}
+}
+
+// Обработчик запроса выгрузки данных. Архив собирается в фоне,
+// ссылка на скачивание приходит на email аккаунта
+func handleRequestExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var email string
+		if err := db.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+
+		exportID, err := requestExport(userID)
+		switch {
+		case errors.Is(err, errExportTooSoon):
+			writeErrorCode(w, r, http.StatusTooManyRequests, "export_too_soon", "Архив уже готовится или был запрошен недавно, проверьте почту")
+			return
+		case err != nil:
+			log.Println("Ошибка создания выгрузки:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных")
+			return
+		}
+
+		go buildExport(exportID, userID, email, requestLocale(r))
+
+		writeSuccess(w, r, http.StatusAccepted, "Мы готовим архив с вашими данными и пришлём ссылку на email", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик скачивания архива по ссылке из письма. Кроме токена
+// требуется вход в тот же аккаунт, чтобы пересланная ссылка не раскрыла данные
+func handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var name string
+		err = db.QueryRow(`SELECT file FROM data_exports
+			WHERE token_hash = $1 AND user_id = $2 AND status = $3 AND expires_at > NOW()`,
+			hashToken(r.URL.Query().Get("token")), userID, exportReady).Scan(&name)
+		switch {
+		case err == sql.ErrNoRows:
+			writeErrorCode(w, r, http.StatusNotFound, "token_invalid", "Ссылка недействительна или устарела")
+			return
+		case err != nil:
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="bookeasy-data.zip"`)
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeFile(w, r, filepath.Join(exportsDir, filepath.Base(name)))
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: архив содержит JSON-файлы и вложения, отсутствующие на диске файлы пропускаются
+func TestWriteExportArchive(t *testing.T) {
+	dir := t.TempDir()
+	attachment := filepath.Join(dir, "attachment-1.pdf")
+	if err := os.WriteFile(attachment, []byte("%PDF-1.4"), 0o600); err != nil {
+		t.Fatalf("Ошибка создания файла: %v", err)
+	}
+
+	data := userExport{
+		Profile:  Profile{FirstName: "Ivan", Email: "ivan@example.com"},
+		Messages: []ExportMessage{{ID: 1, Content: "Привет"}},
+		Tickets:  []SupportTicket{{ID: 2, Email: "ivan@example.com", Message: "Помогите", Attachment: "attachments/attachment-1.pdf"}},
+		files: map[string]string{
+			"attachments/attachment-1.pdf": attachment,
+			"avatar/missing.png":           filepath.Join(dir, "missing.png"),
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeExportArchive(&buf, data))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Ошибка чтения архива: %v", err)
+	}
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Ошибка чтения %s: %v", f.Name, err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(b)
+	}
+
+	assert.Len(t, contents, 5)
+	assert.Contains(t, contents["profile.json"], `"email": "ivan@example.com"`)
+	assert.Equal(t, "null\n", contents["bookings.json"])
+	assert.Contains(t, contents["messages.json"], "Привет")
+	assert.Contains(t, contents["support_tickets.json"], `"attachment": "attachments/attachment-1.pdf"`)
+	assert.Equal(t, "%PDF-1.4", contents["attachments/attachment-1.pdf"])
+}
+
+// Тест: повторная выгрузка не создаётся, пока не прошёл интервал между запросами
+func TestRequestExportTooSoon(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("INSERT INTO data_exports").
+		WithArgs(7, exportPending, exportCooldown.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err = requestExport(7)
+
+	assert.ErrorIs(t, err, errExportTooSoon)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
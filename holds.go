@@ -0,0 +1,506 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Сколько держится удержание объекта, пока пользователь оформляет и оплачивает заказ
+var holdTTL = time.Duration(envInt("HOLD_TTL_MINUTES", 10)) * time.Minute
+
+// Сколько действующих удержаний может быть у одного пользователя одновременно
+var maxActiveHolds = envInt("MAX_ACTIVE_HOLDS", 5)
+
+// Как часто фоновая задача удаляет истёкшие удержания
+var holdReapInterval = time.Duration(envInt("HOLD_REAP_SECONDS", 60)) * time.Second
+
+var (
+	errHoldNotFound = errors.New("удержание не найдено")
+	errHoldExpired  = errors.New("время удержания истекло")
+	errTooManyHolds = errors.New("слишком много действующих удержаний")
+)
+
+// Временное удержание объекта на выбранные даты. Пока оно действует,
+// объект не показывается в поиске и не может быть забронирован другими
+type Hold struct {
+	ID              int       `json:"id"`
+	ItemType        string    `json:"item_type"`
+	ItemID          int       `json:"item_id"`
+	StartDate       string    `json:"start_date"`
+	EndDate         string    `json:"end_date"`
+	PickupBranchID  int       `json:"pickup_branch_id,omitempty"`
+	DropoffBranchID int       `json:"dropoff_branch_id,omitempty"`
+	TotalPrice      Money     `json:"total_price"`
+	OneWayFee       Money     `json:"one_way_fee"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// Запрос на удержание объекта
+type HoldRequest struct {
+	ItemType        string `json:"item_type" validate:"required,oneof=car|room"`
+	ItemID          int    `json:"item_id" validate:"required"`
+	StartDate       string `json:"start_date" validate:"required,date"`
+	EndDate         string `json:"end_date" validate:"required,date"`
+	PickupBranchID  int    `json:"pickup_branch_id"`
+	DropoffBranchID int    `json:"dropoff_branch_id"`
+}
+
+// Функция для необязательных ссылок на филиал: 0 сохраняется как NULL
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// Функция удержания объекта на время оформления заказа
+func createHold(userID int, req HoldRequest) (Hold, Quote, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	defer tx.Rollback()
+
+	if err := lockItem(tx, req.ItemType, req.ItemID); err != nil {
+		return Hold{}, Quote{}, err
+	}
+	hold, quote, err := placeHold(tx, userID, req, holdTTL)
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	return hold, quote, tx.Commit()
+}
+
+// Проверенный запрос на объект с рассчитанной ценой
+type pricedItem struct {
+	Start     time.Time
+	End       time.Time
+	Quote     Quote
+	OneWayFee Money
+}
+
+// Функция проверки запроса на объект и расчёта его цены. Для автомобиля
+// подставляются филиалы по умолчанию; пустая валюта означает валюту объекта.
+// Начало периода должно быть не раньше завтрашнего дня
+func priceItemRequest(req *HoldRequest, currency string) (pricedItem, error) {
+	start, end, err := parseDateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		return pricedItem{}, err
+	}
+	if !time.Now().Before(start) {
+		return pricedItem{}, errInvalidDateRange
+	}
+
+	switch req.ItemType {
+	case "car":
+		car, ok := findCar(req.ItemID)
+		if !ok {
+			return pricedItem{}, errItemNotFound
+		}
+		if req.PickupBranchID == 0 {
+			req.PickupBranchID = car.BranchID
+		}
+		if car.BranchID != req.PickupBranchID {
+			return pricedItem{}, errWrongPickupPoint
+		}
+		if req.DropoffBranchID == 0 {
+			req.DropoffBranchID = req.PickupBranchID
+		}
+		if _, ok := findBranch(req.DropoffBranchID); !ok {
+			return pricedItem{}, errItemNotFound
+		}
+		if currency == "" {
+			currency = car.Price.Currency
+		}
+	case "room":
+		room, ok := findRoom(req.ItemID)
+		if !ok {
+			return pricedItem{}, errItemNotFound
+		}
+		req.PickupBranchID, req.DropoffBranchID = 0, 0
+		if currency == "" {
+			currency = room.Price.Currency
+		}
+	default:
+		return pricedItem{}, errItemNotFound
+	}
+
+	quote, err := priceBooking(req.ItemType, req.ItemID, start, end, req.PickupBranchID, req.DropoffBranchID, currency, 0)
+	if err != nil {
+		return pricedItem{}, err
+	}
+	fee, err := oneWayFeeFor(req.PickupBranchID, req.DropoffBranchID, currency)
+	if err != nil {
+		return pricedItem{}, err
+	}
+	return pricedItem{start, end, quote, fee}, nil
+}
+
+// Функция создания удержания внутри транзакции, где объект уже заблокирован.
+// Цена фиксируется в момент удержания. Удержания пользователя на этот же объект,
+// пересекающиеся по датам, заменяются новым; удержания на другие даты остаются
+func placeHold(tx *sql.Tx, userID int, req HoldRequest, ttl time.Duration) (Hold, Quote, error) {
+	item, err := priceItemRequest(&req, "")
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	start, end, quote, fee := item.Start, item.End, item.Quote, item.OneWayFee
+	currency := quote.Total.Currency
+
+	available, err := itemAvailableFor(tx, req.ItemType, req.ItemID, start, end, userID, 0)
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	if !available {
+		return Hold{}, Quote{}, errItemUnavailable
+	}
+
+	// Предложение из листа ожидания, чьё удержание заменяется, больше не действует
+	_, err = tx.Exec(`UPDATE waitlist_entries SET status = $1 WHERE status = $2 AND hold_id IN (
+			SELECT id FROM holds WHERE user_id = $3 AND item_type = $4 AND item_id = $5 AND start_date < $7 AND end_date > $6
+		)`, waitlistExpired, waitlistOffered, userID, req.ItemType, req.ItemID, start, end)
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	_, err = tx.Exec(`DELETE FROM holds WHERE user_id = $1 AND item_type = $2 AND item_id = $3 AND start_date < $5 AND end_date > $4`,
+		userID, req.ItemType, req.ItemID, start, end)
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+
+	var active int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM holds WHERE user_id = $1 AND expires_at > NOW()`, userID).Scan(&active)
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	if active >= maxActiveHolds {
+		return Hold{}, Quote{}, errTooManyHolds
+	}
+
+	hold := Hold{
+		ItemType:        req.ItemType,
+		ItemID:          req.ItemID,
+		StartDate:       start.Format(dateLayout),
+		EndDate:         end.Format(dateLayout),
+		PickupBranchID:  req.PickupBranchID,
+		DropoffBranchID: req.DropoffBranchID,
+		TotalPrice:      quote.Total,
+		OneWayFee:       fee,
+	}
+	err = tx.QueryRow(`INSERT INTO holds (item_type, item_id, user_id, start_date, end_date, pickup_branch_id, dropoff_branch_id, total_price, one_way_fee, currency, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW() + make_interval(secs => $11)) RETURNING id, expires_at`,
+		req.ItemType, req.ItemID, userID, start, end, nullableID(req.PickupBranchID), nullableID(req.DropoffBranchID),
+		quote.Total.Amount, fee.Amount, currency, ttl.Seconds()).Scan(&hold.ID, &hold.ExpiresAt)
+	if err != nil {
+		return Hold{}, Quote{}, err
+	}
+	return hold, quote, nil
+}
+
+// Функция оплаты удержания: в одной транзакции создаётся бронирование, списываются
+// деньги и удержание снимается. Если сохранить результат не удалось, платёж возвращается
+func checkoutHold(userID, holdID int, paymentToken, idempotencyKey string) (int, Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, Payment{}, err
+	}
+	defer tx.Rollback()
+
+	var itemType, currency string
+	var itemID int
+	var start, end time.Time
+	var pickupBranch, dropoffBranch sql.NullInt64
+	var total, fee int64
+	var expired bool
+	err = tx.QueryRow(`SELECT item_type, item_id, start_date, end_date, pickup_branch_id, dropoff_branch_id, total_price, one_way_fee, currency, expires_at <= NOW()
+		FROM holds WHERE id = $1 AND user_id = $2 FOR UPDATE`, holdID, userID).
+		Scan(&itemType, &itemID, &start, &end, &pickupBranch, &dropoffBranch, &total, &fee, &currency, &expired)
+	if err == sql.ErrNoRows {
+		return 0, Payment{}, errHoldNotFound
+	}
+	if err != nil {
+		return 0, Payment{}, err
+	}
+	if expired {
+		return 0, Payment{}, errHoldExpired
+	}
+
+	// Повторная проверка под блокировкой объекта на случай удержания, истёкшего и занятого другим
+	if err := lockItem(tx, itemType, itemID); err != nil {
+		return 0, Payment{}, err
+	}
+	available, err := itemAvailableFor(tx, itemType, itemID, start, end, userID, 0)
+	if err != nil {
+		return 0, Payment{}, err
+	}
+	if !available {
+		return 0, Payment{}, errItemUnavailable
+	}
+
+	var bookingID int
+	err = tx.QueryRow(`INSERT INTO bookings (item_type, item_id, user_id, start_date, end_date, total_price, currency, pickup_branch_id, dropoff_branch_id, one_way_fee)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		itemType, itemID, userID, start, end, total, currency, pickupBranch, dropoffBranch, fee).Scan(&bookingID)
+	if err != nil {
+		return 0, Payment{}, err
+	}
+	if err := transitionBooking(tx, bookingID, bookingConfirmed); err != nil {
+		return 0, Payment{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM holds WHERE id = $1`, holdID); err != nil {
+		return 0, Payment{}, err
+	}
+	_, err = tx.Exec(`UPDATE waitlist_entries SET status = $1 WHERE hold_id = $2 AND status = $3`, waitlistFulfilled, holdID, waitlistOffered)
+	if err != nil {
+		return 0, Payment{}, err
+	}
+
+	amount := Money{total, currency}
+	auth, err := paymentProvider.Authorize(AuthorizeRequest{
+		Amount:         amount,
+		PaymentToken:   paymentToken,
+		IdempotencyKey: providerIdempotencyKey(userID, "/holds/checkout", holdID, idempotencyKey),
+		Description:    fmt.Sprintf("Бронирование #%d", bookingID),
+	})
+	if err != nil {
+		return 0, Payment{}, err
+	}
+	captured, err := paymentProvider.Capture(auth.ProviderRef, amount)
+	if err != nil {
+		return 0, Payment{}, err
+	}
+
+	payment := Payment{
+		BookingID:      bookingID,
+		Provider:       paymentProvider.Name(),
+		ProviderRef:    captured.ProviderRef,
+		Status:         paymentCaptured,
+		Amount:         amount,
+		RefundedAmount: Money{Currency: currency},
+	}
+	err = tx.QueryRow(`INSERT INTO payments (booking_id, provider, provider_ref, status, amount, currency)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		payment.BookingID, payment.Provider, payment.ProviderRef, payment.Status, payment.Amount.Amount, payment.Amount.Currency).Scan(&payment.ID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if _, rerr := paymentProvider.Refund(captured.ProviderRef, amount); rerr != nil {
+			log.Printf("Не удалось вернуть платёж %s по удержанию #%d: %v", captured.ProviderRef, holdID, rerr)
+		}
+		return 0, Payment{}, err
+	}
+	return bookingID, payment, nil
+}
+
+// Освобождённый объект каталога
+type itemRef struct {
+	Type string
+	ID   int
+}
+
+// Функция удаления истёкших удержаний. Доступность и так не учитывает истёкшие
+// записи; удаление очищает таблицу и сообщает, какие объекты освободились
+func reapExpiredHolds() ([]itemRef, error) {
+	rows, err := db.Query(`DELETE FROM holds WHERE expires_at <= NOW() RETURNING item_type, item_id`)
+	if err != nil {
+		return nil, err
+	}
+	return collectItemRefs(rows)
+}
+
+// Функция отмены неоплаченных бронирований, срок которых истёк
+func reapExpiredBookings() ([]itemRef, error) {
+	rows, err := db.Query(`UPDATE bookings SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND expires_at <= NOW() RETURNING item_type, item_id`, bookingCancelled, bookingPending)
+	if err != nil {
+		return nil, err
+	}
+	return collectItemRefs(rows)
+}
+
+// Функция чтения пар (item_type, item_id) из результата запроса без повторов
+func collectItemRefs(rows *sql.Rows) ([]itemRef, error) {
+	defer rows.Close()
+
+	seen := map[itemRef]bool{}
+	var freed []itemRef
+	for rows.Next() {
+		var item itemRef
+		if err := rows.Scan(&item.Type, &item.ID); err != nil {
+			return nil, err
+		}
+		if !seen[item] {
+			seen[item] = true
+			freed = append(freed, item)
+		}
+	}
+	return freed, rows.Err()
+}
+
+// Фоновая задача, периодически удаляющая истёкшие удержания, отменяющая
+// неоплаченные бронирования с истёкшим сроком и предлагающая освободившиеся
+// объекты листу ожидания
+func runHoldReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		freed, err := reapExpiredHolds()
+		if err != nil {
+			log.Printf("Ошибка удаления истёкших удержаний: %v", err)
+		}
+		cancelled, err := reapExpiredBookings()
+		if err != nil {
+			log.Printf("Ошибка отмены истёкших бронирований: %v", err)
+		}
+		for _, item := range append(freed, cancelled...) {
+			notifyWaitlist(item.Type, item.ID)
+		}
+	}
+}
+
+// Обработчик удержания объекта на время оформления заказа
+func handleCreateHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req HoldRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		hold, quote, err := createHold(userID, req)
+		switch {
+		case err == nil:
+		case errors.Is(err, errInvalidDateRange):
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		case errors.Is(err, errItemNotFound):
+			writeError(w, r, http.StatusNotFound, "Объект или филиал не найден")
+			return
+		case errors.Is(err, errWrongPickupPoint):
+			writeErrorCode(w, r, http.StatusConflict, "wrong_branch", "Автомобиль недоступен в выбранном филиале")
+			return
+		case errors.Is(err, errItemUnavailable):
+			writeErrorCode(w, r, http.StatusConflict, "item_unavailable", "Объект занят на выбранные даты")
+			return
+		case errors.Is(err, errTooManyHolds):
+			writeErrorCode(w, r, http.StatusConflict, "too_many_holds", "Слишком много удержаний: оплатите или снимите одно из них")
+			return
+		default:
+			log.Printf("Ошибка создания удержания: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Объект удержан до оплаты", map[string]interface{}{
+			"hold":  hold,
+			"quote": quote.Localized(r),
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Ссылка на удержание
+type HoldRef struct {
+	HoldID int `json:"hold_id" validate:"required"`
+}
+
+// Обработчик досрочного снятия удержания
+func handleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req HoldRef
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		var item itemRef
+		err = db.QueryRow(`DELETE FROM holds WHERE id = $1 AND user_id = $2 RETURNING item_type, item_id`, req.HoldID, userID).
+			Scan(&item.Type, &item.ID)
+		if err == sql.ErrNoRows {
+			writeError(w, r, http.StatusNotFound, "Удержание не найдено")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		notifyWaitlist(item.Type, item.ID)
+
+		writeSuccess(w, r, http.StatusOK, "Удержание снято", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос оплаты удержания
+type HoldCheckoutRequest struct {
+	HoldID       int    `json:"hold_id" validate:"required"`
+	PaymentToken string `json:"payment_token" validate:"max=255"`
+}
+
+// Обработчик оплаты удержания и превращения его в бронирование
+func handleHoldCheckout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req HoldCheckoutRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		bookingID, payment, err := checkoutHold(userID, req.HoldID, req.PaymentToken, r.Header.Get(idempotencyHeader))
+		switch {
+		case err == nil:
+		case errors.Is(err, errHoldNotFound):
+			writeError(w, r, http.StatusNotFound, "Удержание не найдено")
+			return
+		case errors.Is(err, errHoldExpired):
+			writeErrorCode(w, r, http.StatusGone, "hold_expired", "Время удержания истекло, выберите объект заново")
+			return
+		case errors.Is(err, errItemUnavailable):
+			writeErrorCode(w, r, http.StatusConflict, "item_unavailable", "Объект занят на выбранные даты")
+			return
+		case errors.Is(err, errPaymentDeclined):
+			writeErrorCode(w, r, http.StatusPaymentRequired, "payment_declined", "Платёж отклонён")
+			return
+		default:
+			log.Printf("Ошибка оплаты удержания: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Бронирование оплачено", map[string]interface{}{
+			"booking_id": bookingID,
+			"payment":    payment,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: удержать объект с началом сегодня или в прошлом нельзя
+func TestPriceItemRequestRejectsPastStart(t *testing.T) {
+	today := time.Now().UTC().Format(dateLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(dateLayout)
+	nextWeek := time.Now().UTC().AddDate(0, 0, 7).Format(dateLayout)
+
+	for _, start := range []string{yesterday, today} {
+		req := HoldRequest{ItemType: "room", ItemID: 1, StartDate: start, EndDate: nextWeek}
+		_, err := priceItemRequest(&req, "")
+		assert.ErrorIs(t, err, errInvalidDateRange, "начало %s", start)
+	}
+}
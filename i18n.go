@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Поддерживаемые языки интерфейса. Тексты в коде пишутся по-русски и служат
+// ключами каталога переводов
+const defaultLocale = "ru"
+
+var supportedLocales = []string{"ru", "en", "kk"}
+
+// Cookie с языком, выбранным в профиле. Ставится при входе и при смене языка,
+// чтобы не обращаться к базе на каждый ответ
+const localeCookieName = "lang"
+
+// Функция проверки, что язык поддерживается
+func isSupportedLocale(locale string) bool {
+	for _, l := range supportedLocales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция выбора языка ответа: сначала язык из профиля, затем Accept-Language
+func requestLocale(r *http.Request) string {
+	if cookie, err := r.Cookie(localeCookieName); err == nil && isSupportedLocale(cookie.Value) {
+		return cookie.Value
+	}
+	return negotiateLocale(r.Header.Get("Accept-Language"))
+}
+
+// Функция разбора заголовка Accept-Language с учётом весов q.
+// Региональные варианты (en-US) сводятся к языку, kz считается казахским
+func negotiateLocale(header string) string {
+	best, bestQ := defaultLocale, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if lang == "kz" {
+			lang = "kk"
+		}
+		if isSupportedLocale(lang) && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// Функция сохранения выбранного языка в cookie
+func setLocaleCookie(w http.ResponseWriter, locale string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     localeCookieName,
+		Value:    locale,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Функция перевода сообщения. Если перевода нет, возвращается исходный русский текст
+func translate(locale, msg string, args ...interface{}) string {
+	if t, ok := catalog[msg][locale]; ok {
+		msg = t
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Функция перевода сообщения на язык текущего запроса
+func localize(r *http.Request, msg string, args ...interface{}) string {
+	return translate(requestLocale(r), msg, args...)
+}
+
+// Шаблон письма на одном языке
+type emailTemplate struct {
+	Subject string
+	Body    string
+}
+
+// Функция подготовки письма на нужном языке; при отсутствии перевода — по-русски
+func renderEmail(locale, name string, args ...interface{}) (subject, body string) {
+	tmpl, ok := emailTemplates[name][locale]
+	if !ok {
+		tmpl = emailTemplates[name][defaultLocale]
+	}
+	return tmpl.Subject, fmt.Sprintf(tmpl.Body, args...)
+}
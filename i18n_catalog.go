@@ -0,0 +1,405 @@
+package main
+
+// Переводы сообщений API. Ключ — русский текст из кода, для каждого ключа
+// должны быть переводы на все поддерживаемые языки, кроме русского
+var catalog = map[string]map[string]string{
+	// Общие ошибки
+	"Метод не поддерживается":                 {"en": "Method not allowed", "kk": "Әдіс қолдау көрсетілмейді"},
+	"Некорректные данные формы":               {"en": "Invalid form data", "kk": "Форма деректері қате"},
+	"Необходимо войти в аккаунт":              {"en": "Please sign in to your account", "kk": "Аккаунтқа кіру қажет"},
+	"Доступ запрещён":                         {"en": "Access denied", "kk": "Кіруге тыйым салынған"},
+	"Ошибка базы данных":                      {"en": "Database error", "kk": "Дерекқор қатесі"},
+	"Ошибка сервера":                          {"en": "Server error", "kk": "Сервер қатесі"},
+	"Ошибка сохранения данных":                {"en": "Failed to save data", "kk": "Деректерді сақтау қатесі"},
+	"Ошибка сохранения данных в базе":         {"en": "Failed to save data", "kk": "Деректерді сақтау қатесі"},
+	"Ошибка получения данных":                 {"en": "Failed to load data", "kk": "Деректерді алу қатесі"},
+	"Ошибка обработки данных":                 {"en": "Failed to process data", "kk": "Деректерді өңдеу қатесі"},
+	"Ошибка очистки данных":                   {"en": "Failed to clear data", "kk": "Деректерді тазалау қатесі"},
+	"Ошибка отправки email":                   {"en": "Failed to send email", "kk": "Email жіберу қатесі"},
+	"Некорректный идентификатор":              {"en": "Invalid identifier", "kk": "Идентификатор қате"},
+	"Требуется заголовок Idempotency-Key":     {"en": "The Idempotency-Key header is required", "kk": "Idempotency-Key тақырыбы қажет"},
+	"Запрос с этим ключом уже обрабатывается": {"en": "A request with this key is already being processed", "kk": "Осы кілтпен сұрау әлі өңделуде"},
+
+	// Регистрация, вход и восстановление пароля
+	"Пользователь успешно зарегистрирован. Проверьте email для подтверждения.": {
+		"en": "Registration successful. Check your email to confirm your account.",
+		"kk": "Тіркелу сәтті өтті. Растау үшін email-ді тексеріңіз.",
+	},
+	"Регистрация подтверждена. Теперь вы можете войти.": {
+		"en": "Registration confirmed. You can now sign in.",
+		"kk": "Тіркелу расталды. Енді жүйеге кіре аласыз.",
+	},
+	"Если аккаунт ожидает подтверждения, мы отправили новое письмо. Повторить можно через минуту": {
+		"en": "If the account is awaiting confirmation, we have sent a new email. You can try again in a minute",
+		"kk": "Егер аккаунт растауды күтіп тұрса, жаңа хат жібердік. Бір минуттан кейін қайталауға болады",
+	},
+	"Если этот email зарегистрирован, мы отправили на него ссылку для восстановления пароля": {
+		"en": "If this email is registered, we have sent a password reset link to it",
+		"kk": "Егер бұл email тіркелген болса, оған құпиясөзді қалпына келтіру сілтемесін жібердік",
+	},
+	"Токен не указан":    {"en": "Token is missing", "kk": "Токен көрсетілмеген"},
+	"Некорректный токен": {"en": "Invalid token", "kk": "Токен жарамсыз"},
+	"Срок действия ссылки истёк. Запросите новое письмо": {
+		"en": "The link has expired. Request a new email",
+		"kk": "Сілтеменің мерзімі өтті. Жаңа хат сұраңыз",
+	},
+	"Срок действия ссылки истёк, запросите новую": {
+		"en": "The link has expired, request a new one",
+		"kk": "Сілтеменің мерзімі өтті, жаңасын сұраңыз",
+	},
+	"Ссылка недействительна или уже использована": {
+		"en": "The link is invalid or has already been used",
+		"kk": "Сілтеме жарамсыз немесе бұрын пайдаланылған",
+	},
+	"Пароль изменён. Войдите с новым паролем": {
+		"en": "Password changed. Sign in with your new password",
+		"kk": "Құпиясөз өзгертілді. Жаңа құпиясөзбен кіріңіз",
+	},
+	"Неверный email или пароль":      {"en": "Invalid email or password", "kk": "Email немесе құпиясөз қате"},
+	"Подтвердите email перед входом": {"en": "Please confirm your email before signing in", "kk": "Кірмес бұрын email-ді растаңыз"},
+	"Вы успешно вошли":               {"en": "Signed in successfully", "kk": "Сіз жүйеге сәтті кірдіңіз"},
+	"Вы вышли из аккаунта":           {"en": "Signed out", "kk": "Сіз аккаунттан шықтыңыз"},
+
+	// Ограничение попыток входа
+	"Слишком много попыток входа, попробуйте позже": {
+		"en": "Too many sign-in attempts, please try again later",
+		"kk": "Кіру әрекеттері тым көп, кейінірек қайталаңыз",
+	},
+	"Блокировка снята":       {"en": "Account unlocked", "kk": "Бұғаттау алынды"},
+	"Пользователь не найден": {"en": "User not found", "kk": "Пайдаланушы табылмады"},
+
+	// Вход через внешних провайдеров
+	"Неизвестный провайдер входа": {"en": "Unknown sign-in provider", "kk": "Белгісіз кіру провайдері"},
+	"Вход через внешний сервис не удался, попробуйте ещё раз": {
+		"en": "Sign-in with the external service failed, please try again",
+		"kk": "Сыртқы қызмет арқылы кіру сәтсіз аяқталды, қайталап көріңіз",
+	},
+	"Провайдер не подтвердил email, войдите другим способом": {
+		"en": "The provider has not verified your email, please sign in another way",
+		"kk": "Провайдер email-ді растамады, басқа жолмен кіріңіз",
+	},
+
+	// Двухфакторная аутентификация
+	"Введите код из приложения-аутентификатора или код восстановления": {
+		"en": "Enter the code from your authenticator app or a recovery code",
+		"kk": "Аутентификатор қолданбасындағы кодты немесе қалпына келтіру кодын енгізіңіз",
+	},
+	"Вход не завершён вовремя, войдите заново": {
+		"en": "Sign-in was not completed in time, please sign in again",
+		"kk": "Кіру уақытында аяқталмады, қайта кіріңіз",
+	},
+	"Неверный код": {"en": "Invalid code", "kk": "Код қате"},
+	"Двухфакторная аутентификация уже включена": {
+		"en": "Two-factor authentication is already enabled",
+		"kk": "Екі факторлы аутентификация қосылып тұр",
+	},
+	"Отсканируйте QR-код в приложении-аутентификаторе и введите код из него": {
+		"en": "Scan the QR code with your authenticator app and enter the code it shows",
+		"kk": "QR-кодты аутентификатор қолданбасымен сканерлеп, ондағы кодты енгізіңіз",
+	},
+	"Сначала начните настройку двухфакторной аутентификации": {
+		"en": "Start the two-factor authentication setup first",
+		"kk": "Алдымен екі факторлы аутентификацияны баптауды бастаңыз",
+	},
+	"Двухфакторная аутентификация включена. Сохраните коды восстановления, они показываются один раз": {
+		"en": "Two-factor authentication is enabled. Save your recovery codes, they are shown only once",
+		"kk": "Екі факторлы аутентификация қосылды. Қалпына келтіру кодтарын сақтаңыз, олар бір рет қана көрсетіледі",
+	},
+	"Двухфакторная аутентификация отключена": {
+		"en": "Two-factor authentication is disabled",
+		"kk": "Екі факторлы аутентификация өшірілді",
+	},
+	"Администраторы не могут отключить двухфакторную аутентификацию": {
+		"en": "Administrators cannot disable two-factor authentication",
+		"kk": "Әкімшілер екі факторлы аутентификацияны өшіре алмайды",
+	},
+	"Для доступа к панели администратора включите двухфакторную аутентификацию": {
+		"en": "Enable two-factor authentication to access the admin panel",
+		"kk": "Әкімші панеліне кіру үшін екі факторлы аутентификацияны қосыңыз",
+	},
+	"Войдите заново с кодом двухфакторной аутентификации": {
+		"en": "Sign in again with your two-factor authentication code",
+		"kk": "Екі факторлы аутентификация кодымен қайта кіріңіз",
+	},
+
+	// Профиль
+	"Профиль обновлён":               {"en": "Profile updated", "kk": "Профиль жаңартылды"},
+	"Аватар обновлён":                {"en": "Avatar updated", "kk": "Аватар жаңартылды"},
+	"Неверный пароль":                {"en": "Incorrect password", "kk": "Құпиясөз қате"},
+	"Email изменён":                  {"en": "Email changed", "kk": "Email өзгертілді"},
+	"Аккаунт удалён":                 {"en": "Account deleted", "kk": "Аккаунт жойылды"},
+	"Не удалось сохранить файл":      {"en": "Could not save the file", "kk": "Файлды сақтау мүмкін болмады"},
+	"Файл больше 5 МБ или повреждён": {"en": "The file is larger than 5 MB or corrupted", "kk": "Файл 5 МБ-тан үлкен немесе зақымдалған"},
+	"Аватар должен быть изображением JPEG, PNG или WebP": {
+		"en": "The avatar must be a JPEG, PNG or WebP image",
+		"kk": "Аватар JPEG, PNG немесе WebP суреті болуы керек",
+	},
+	"Это ваш текущий email": {"en": "This is your current email", "kk": "Бұл сіздің қазіргі email-іңіз"},
+	"Мы отправили ссылку для подтверждения на новый адрес. До подтверждения действует прежний email": {
+		"en": "We have sent a confirmation link to the new address. Your current email stays active until then",
+		"kk": "Жаңа мекенжайға растау сілтемесін жібердік. Расталғанға дейін бұрынғы email жарамды",
+	},
+	"Ссылка недействительна или устарела": {"en": "The link is invalid or has expired", "kk": "Сілтеме жарамсыз немесе ескірген"},
+	"Этот email уже используется другим аккаунтом": {
+		"en": "This email is already used by another account",
+		"kk": "Бұл email басқа аккаунтта қолданылады",
+	},
+	"Сначала отмените оплаченные бронирования": {
+		"en": "Cancel your paid bookings first",
+		"kk": "Алдымен төленген брондауларды болдырмаңыз",
+	},
+	"Мы готовим архив с вашими данными и пришлём ссылку на email": {
+		"en": "We are preparing an archive with your data and will email you a link",
+		"kk": "Деректеріңіз бар мұрағатты дайындап жатырмыз, сілтемені email-ге жібереміз",
+	},
+	"Архив уже готовится или был запрошен недавно, проверьте почту": {
+		"en": "An archive is already being prepared or was requested recently, check your email",
+		"kk": "Мұрағат дайындалып жатыр немесе жақында сұралды, поштаңызды тексеріңіз",
+	},
+
+	// Сообщения поддержки
+	"Сообщение успешно отправлено":     {"en": "Message sent", "kk": "Хабарлама жіберілді"},
+	"Сообщение отправлено в поддержку": {"en": "Message sent to support", "kk": "Хабарлама қолдау қызметіне жіберілді"},
+	"Сообщения успешно очищены":        {"en": "Messages cleared", "kk": "Хабарламалар тазаланды"},
+	"Вложение должно быть изображением JPEG, PNG или файлом PDF": {
+		"en": "The attachment must be a JPEG or PNG image or a PDF file",
+		"kk": "Тіркеме JPEG, PNG суреті немесе PDF файлы болуы керек",
+	},
+	"Не удалось сохранить вложение": {"en": "Could not save the attachment", "kk": "Тіркемені сақтау мүмкін болмады"},
+
+	// Каталог, филиалы и курсы валют
+	"Автомобиль не найден":                {"en": "Car not found", "kk": "Көлік табылмады"},
+	"Автомобиль добавлен":                 {"en": "Car added", "kk": "Көлік қосылды"},
+	"Объект не найден":                    {"en": "Item not found", "kk": "Нысан табылмады"},
+	"Объект или филиал не найден":         {"en": "Item or branch not found", "kk": "Нысан немесе филиал табылмады"},
+	"Филиал добавлен":                     {"en": "Branch added", "kk": "Филиал қосылды"},
+	"Филиал возврата не найден":           {"en": "Return branch not found", "kk": "Қайтару филиалы табылмады"},
+	"Период обслуживания добавлен":        {"en": "Maintenance period added", "kk": "Қызмет көрсету кезеңі қосылды"},
+	"Неизвестная валюта":                  {"en": "Unknown currency", "kk": "Белгісіз валюта"},
+	"Конвертация в эту валюту недоступна": {"en": "Conversion to this currency is not available", "kk": "Бұл валютаға айырбастау қолжетімсіз"},
+	"Курсы валют обновлены":               {"en": "Exchange rates updated", "kk": "Валюта бағамдары жаңартылды"},
+	"Некорректная таблица курсов":         {"en": "Invalid exchange rate table", "kk": "Бағамдар кестесі қате"},
+	"Не удалось прочитать файл курсов":    {"en": "Could not read the exchange rates file", "kk": "Бағамдар файлын оқу мүмкін болмады"},
+	"Ошибка сохранения файла курсов":      {"en": "Failed to save the exchange rates file", "kk": "Бағамдар файлын сақтау қатесі"},
+	"Ошибка расчёта стоимости":            {"en": "Failed to calculate the price", "kk": "Құнын есептеу қатесі"},
+	"Ошибка проверки доступности":         {"en": "Failed to check availability", "kk": "Қолжетімділікті тексеру қатесі"},
+
+	// Строки расчёта стоимости
+	"Базовый тариф: %d × %s":                  {"en": "Base rate: %d × %s", "kk": "Негізгі тариф: %d × %s"},
+	"%s (%+d%%): %d сут.":                     {"en": "%s (%+d%%): %d days", "kk": "%s (%+d%%): %d тәулік"},
+	"Выходные дни (%+d%%): %d сут.":           {"en": "Weekend days (%+d%%): %d days", "kk": "Демалыс күндері (%+d%%): %d тәулік"},
+	"Будние дни (%+d%%): %d сут.":             {"en": "Weekdays (%+d%%): %d days", "kk": "Жұмыс күндері (%+d%%): %d тәулік"},
+	"Скидка за длительность (-%d%%)":          {"en": "Length discount (-%d%%)", "kk": "Ұзақтыққа жеңілдік (-%d%%)"},
+	"Бронирование в последний момент (%+d%%)": {"en": "Last-minute booking (%+d%%)", "kk": "Соңғы сәттегі брондау (%+d%%)"},
+	"Раннее бронирование (%+d%%)":             {"en": "Early booking (%+d%%)", "kk": "Ерте брондау (%+d%%)"},
+	"Высокий спрос (+%d%%)":                   {"en": "High demand (+%d%%)", "kk": "Жоғары сұраныс (+%d%%)"},
+	"Возврат в другой филиал":                 {"en": "Return to another branch", "kk": "Басқа филиалға қайтару"},
+	"Летний сезон":                            {"en": "Summer season", "kk": "Жазғы маусым"},
+	"Новогодние праздники":                    {"en": "New Year holidays", "kk": "Жаңа жыл мерекелері"},
+
+	// Бронирования
+	"Некорректный период дат":    {"en": "Invalid date range", "kk": "Күндер аралығы қате"},
+	"Некорректный период аренды": {"en": "Invalid rental period", "kk": "Жалға алу кезеңі қате"},
+	"Объект занят на выбранные даты": {
+		"en": "The item is not available for the selected dates",
+		"kk": "Таңдалған күндерге нысан бос емес",
+	},
+	"Бронирование отменено, но вернуть деньги не удалось. Мы вернём их вручную": {
+		"en": "The booking has been cancelled, but the refund failed. We will refund you manually",
+		"kk": "Брондау жойылды, бірақ ақшаны қайтару сәтсіз аяқталды. Біз оны қолмен қайтарамыз",
+	},
+	"Поездка отменена, но вернуть деньги не удалось. Мы вернём их вручную": {
+		"en": "The trip has been cancelled, but the refund failed. We will refund you manually",
+		"kk": "Сапар жойылды, бірақ ақшаны қайтару сәтсіз аяқталды. Біз оны қолмен қайтарамыз",
+	},
+	"Бронирование изменено, но вернуть разницу не удалось. Мы вернём её вручную": {
+		"en": "The booking has been changed, but refunding the difference failed. We will refund it manually",
+		"kk": "Брондау өзгертілді, бірақ айырманы қайтару сәтсіз аяқталды. Біз оны қолмен қайтарамыз",
+	},
+	"Стоимость бронирования изменилась, оплатите его заново": {
+		"en": "The booking price has changed, please pay for it again",
+		"kk": "Брондау құны өзгерді, оны қайта төлеңіз",
+	},
+	"Оплата уже заблокирована: измените бронирование после списания": {
+		"en": "The payment is already authorized: change the booking after it is captured",
+		"kk": "Төлем бұғатталған: брондауды ақша шешілгеннен кейін өзгертіңіз",
+	},
+	"Слишком много удержаний: оплатите или снимите одно из них": {
+		"en": "Too many holds: pay for or release one of them",
+		"kk": "Ұстап тұрулар тым көп: біреуін төлеңіз немесе босатыңыз",
+	},
+	"Автомобиль уже забронирован на эти даты":      {"en": "The car is already booked for these dates", "kk": "Көлік бұл күндерге брондалған"},
+	"Автомобиль недоступен в выбранном филиале":    {"en": "The car is not available at the selected branch", "kk": "Көлік таңдалған филиалда қолжетімсіз"},
+	"Автомобиль недоступен в филиале получения":    {"en": "The car is not available at the pickup branch", "kk": "Көлік алу филиалында қолжетімсіз"},
+	"Автомобиль забронирован":                      {"en": "Car booked", "kk": "Көлік брондалды"},
+	"Автомобиль принят":                            {"en": "Car returned", "kk": "Көлік қабылданды"},
+	"Выданный автомобиль по этой аренде не найден": {"en": "No issued car found for this rental", "kk": "Бұл жалға алу бойынша берілген көлік табылмады"},
+	"Используйте приём автомобиля в филиале":       {"en": "Use the car return at the branch", "kk": "Көлікті филиалда қабылдау функциясын пайдаланыңыз"},
+	"Бронирование не найдено":                      {"en": "Booking not found", "kk": "Брондау табылмады"},
+	"Бронирование или объект не найдены":           {"en": "Booking or item not found", "kk": "Брондау немесе нысан табылмады"},
+	"Недопустимый переход статуса":                 {"en": "Invalid status transition", "kk": "Мәртебені бұлай өзгертуге болмайды"},
+	"Статус бронирования обновлён":                 {"en": "Booking status updated", "kk": "Брондау мәртебесі жаңартылды"},
+	"Это бронирование нельзя отменить":             {"en": "This booking cannot be cancelled", "kk": "Бұл брондауды болдырмау мүмкін емес"},
+	"Это бронирование нельзя изменить":             {"en": "This booking cannot be changed", "kk": "Бұл брондауды өзгерту мүмкін емес"},
+	"Бронирование отменено":                        {"en": "Booking cancelled", "kk": "Брондау болдырылмады"},
+	"Бронирование изменено":                        {"en": "Booking changed", "kk": "Брондау өзгертілді"},
+
+	// Удержания и лист ожидания
+	"Объект удержан до оплаты": {"en": "The item is held for you until payment", "kk": "Нысан төлемге дейін сізге сақталды"},
+	"Удержание не найдено":     {"en": "Hold not found", "kk": "Уақытша сақтау табылмады"},
+	"Удержание снято":          {"en": "Hold released", "kk": "Уақытша сақтау алынды"},
+	"Время удержания истекло, выберите объект заново": {
+		"en": "The hold has expired, please select the item again",
+		"kk": "Сақтау уақыты өтті, нысанды қайта таңдаңыз",
+	},
+	"Вы в листе ожидания. Мы сообщим, когда объект освободится": {
+		"en": "You are on the waitlist. We will let you know when the item becomes available",
+		"kk": "Сіз күту тізіміндесіз. Нысан босағанда хабарлаймыз",
+	},
+	"Объект свободен на эти даты, его можно забронировать": {
+		"en": "The item is available for these dates and can be booked",
+		"kk": "Нысан бұл күндерге бос, оны брондауға болады",
+	},
+	"Вы уже в листе ожидания на эти даты": {"en": "You are already on the waitlist for these dates", "kk": "Сіз бұл күндерге күту тізіміндесіз"},
+	"Вы вышли из листа ожидания":          {"en": "You have left the waitlist", "kk": "Сіз күту тізімінен шықтыңыз"},
+	"Запись в листе ожидания не найдена":  {"en": "Waitlist entry not found", "kk": "Күту тізіміндегі жазба табылмады"},
+
+	// Поездки
+	"Поездка не найдена":                      {"en": "Trip not found", "kk": "Сапар табылмады"},
+	"Поездка создана":                         {"en": "Trip created", "kk": "Сапар құрылды"},
+	"Поездка оплачена":                        {"en": "Trip paid", "kk": "Сапар төленді"},
+	"Поездка отменена":                        {"en": "Trip cancelled", "kk": "Сапар болдырылмады"},
+	"Бронирование добавлено в поездку":        {"en": "Booking added to the trip", "kk": "Брондау сапарға қосылды"},
+	"В поездке нет неоплаченных бронирований": {"en": "The trip has no unpaid bookings", "kk": "Сапарда төленбеген брондаулар жоқ"},
+	"Одно из бронирований уже началось":       {"en": "One of the bookings has already started", "kk": "Брондаулардың бірі басталып кетті"},
+
+	// Платежи
+	"Сумма заблокирована":                        {"en": "Amount authorized", "kk": "Сома бұғатталды"},
+	"Оплата получена, бронирование подтверждено": {"en": "Payment received, booking confirmed", "kk": "Төлем алынды, брондау расталды"},
+	"Бронирование оплачено":                      {"en": "Booking paid", "kk": "Брондау төленді"},
+	"Бронирование не ожидает оплаты":             {"en": "The booking is not awaiting payment", "kk": "Брондау төлемді күтіп тұрған жоқ"},
+	"Платёж не найден":                           {"en": "Payment not found", "kk": "Төлем табылмады"},
+	"Платёж отклонён":                            {"en": "Payment declined", "kk": "Төлем қабылданбады"},
+	"Не удалось списать оплату":                  {"en": "Could not charge the payment", "kk": "Төлемді есептен шығару мүмкін болмады"},
+	"Не удалось списать доплату":                 {"en": "Could not charge the additional payment", "kk": "Қосымша төлемді есептен шығару мүмкін болмады"},
+	"Не удалось выполнить возврат":               {"en": "Refund failed", "kk": "Қаражатты қайтару мүмкін болмады"},
+	"Возврат выполнен":                           {"en": "Refund completed", "kk": "Қаражат қайтарылды"},
+	"Некорректная подпись":                       {"en": "Invalid signature", "kk": "Қолтаңба жарамсыз"},
+
+	// API партнёров
+	"Требуется ключ API":                                           {"en": "API key required", "kk": "API кілті қажет"},
+	"Ключ API недействителен или отозван":                          {"en": "API key is invalid or revoked", "kk": "API кілті жарамсыз немесе кері қайтарылған"},
+	"Ключ API не даёт доступа к этой операции":                     {"en": "API key does not grant access to this operation", "kk": "API кілті бұл әрекетке рұқсат бермейді"},
+	"Превышен лимит запросов для ключа API":                        {"en": "API key rate limit exceeded", "kk": "API кілті үшін сұраныстар шегінен асты"},
+	"Партнёр добавлен":                                             {"en": "Partner added", "kk": "Серіктес қосылды"},
+	"Партнёр не найден":                                            {"en": "Partner not found", "kk": "Серіктес табылмады"},
+	"Ключ API выпущен, сохраните его: повторно он не показывается": {"en": "API key issued; save it now, it will not be shown again", "kk": "API кілті берілді, оны сақтаңыз: ол қайта көрсетілмейді"},
+	"Ключ API не найден":                                           {"en": "API key not found", "kk": "API кілті табылмады"},
+	"Ключ API отозван":                                             {"en": "API key revoked", "kk": "API кілті кері қайтарылды"},
+	"Недействительный CSRF-токен, обновите страницу":               {"en": "Invalid CSRF token, please reload the page", "kk": "CSRF токені жарамсыз, бетті жаңартыңыз"},
+
+	// Проверка полей
+	"Обязательное поле":                     {"en": "Required field", "kk": "Міндетті өріс"},
+	"Некорректный номер телефона":           {"en": "Invalid phone number", "kk": "Телефон нөмірі қате"},
+	"Некорректный email":                    {"en": "Invalid email", "kk": "Email қате"},
+	"Дата должна быть в формате ГГГГ-ММ-ДД": {"en": "Date must be in YYYY-MM-DD format", "kk": "Күн ЖЖЖЖ-АА-КК пішімінде болуы керек"},
+	"Не короче %s символов":                 {"en": "At least %s characters", "kk": "Кемінде %s таңба"},
+	"Не длиннее %s символов":                {"en": "At most %s characters", "kk": "Ең көбі %s таңба"},
+	"Не меньше %s":                          {"en": "Must be at least %s", "kk": "Кемінде %s болуы керек"},
+	"Не больше %s":                          {"en": "Must be at most %s", "kk": "Ең көбі %s болуы керек"},
+	"Допустимые значения: %s":               {"en": "Allowed values: %s", "kk": "Рұқсат етілген мәндер: %s"},
+	"Цена должна быть больше нуля":          {"en": "Price must be greater than zero", "kk": "Баға нөлден үлкен болуы керек"},
+	"Сумма должна быть больше нуля":         {"en": "Amount must be greater than zero", "kk": "Сома нөлден үлкен болуы керек"},
+	"Филиал не найден":                      {"en": "Branch not found", "kk": "Филиал табылмады"},
+}
+
+// Шаблоны писем пользователям по языкам
+var emailTemplates = map[string]map[string]emailTemplate{
+	// Аргументы: адрес сайта, токен, срок действия в часах
+	"confirmation": {
+		"ru": {"Подтверждение регистрации",
+			"Здравствуйте!\n\nПерейдите по ссылке для подтверждения регистрации:\n%s/confirm?token=%s\n\nСсылка действует %d ч."},
+		"en": {"Confirm your registration",
+			"Hello!\n\nFollow the link to confirm your registration:\n%s/confirm?token=%s\n\nThe link is valid for %d h."},
+		"kk": {"Тіркелуді растау",
+			"Сәлеметсіз бе!\n\nТіркелуді растау үшін сілтемеге өтіңіз:\n%s/confirm?token=%s\n\nСілтеме %d сағат жарамды."},
+	},
+	// Аргументы: адрес сайта дважды
+	"account_exists": {
+		"ru": {"Аккаунт уже существует",
+			"Здравствуйте!\n\nКто-то попытался зарегистрироваться в BookEasy с этим email, но аккаунт уже существует.\n" +
+				"Войти: %s/login.html\nЕсли вы забыли пароль, восстановите его: %s/forgot-password.html\n\n" +
+				"Если это были не вы, просто проигнорируйте это письмо."},
+		"en": {"Account already exists",
+			"Hello!\n\nSomeone tried to sign up for BookEasy with this email, but an account already exists.\n" +
+				"Sign in: %s/login.html\nIf you forgot your password, reset it: %s/forgot-password.html\n\n" +
+				"If this wasn't you, just ignore this email."},
+		"kk": {"Аккаунт бұрыннан бар",
+			"Сәлеметсіз бе!\n\nБіреу осы email арқылы BookEasy-ге тіркелмек болды, бірақ аккаунт бұрыннан бар.\n" +
+				"Кіру: %s/login.html\nҚұпиясөзді ұмытсаңыз, оны қалпына келтіріңіз: %s/forgot-password.html\n\n" +
+				"Егер бұл сіз болмасаңыз, бұл хатты елемеңіз."},
+	},
+	// Аргументы: адрес сайта, токен, срок действия в минутах
+	"password_reset": {
+		"ru": {"Восстановление пароля",
+			"Здравствуйте!\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s/reset-password.html?token=%s\n\n" +
+				"Ссылка действует %d мин. Если вы не запрашивали восстановление, просто проигнорируйте это письмо."},
+		"en": {"Password reset",
+			"Hello!\n\nTo set a new password, follow the link:\n%s/reset-password.html?token=%s\n\n" +
+				"The link is valid for %d min. If you did not request a password reset, just ignore this email."},
+		"kk": {"Құпиясөзді қалпына келтіру",
+			"Сәлеметсіз бе!\n\nЖаңа құпиясөз орнату үшін сілтемеге өтіңіз:\n%s/reset-password.html?token=%s\n\n" +
+				"Сілтеме %d минут жарамды. Егер қалпына келтіруді сұрамасаңыз, бұл хатты елемеңіз."},
+	},
+	// Аргументы: адрес сайта, токен, срок действия в часах
+	"email_change": {
+		"ru": {"Подтверждение нового email",
+			"Здравствуйте!\n\nЧтобы сменить email аккаунта BookEasy на этот адрес, перейдите по ссылке:\n%s/profile/email/confirm?token=%s\n\n" +
+				"Ссылка действует %d ч. Если вы не меняли email, просто проигнорируйте это письмо."},
+		"en": {"Confirm your new email",
+			"Hello!\n\nTo change your BookEasy account email to this address, follow the link:\n%s/profile/email/confirm?token=%s\n\n" +
+				"The link is valid for %d h. If you did not change your email, just ignore this email."},
+		"kk": {"Жаңа email-ді растау",
+			"Сәлеметсіз бе!\n\nBookEasy аккаунтының email-ін осы мекенжайға ауыстыру үшін сілтемеге өтіңіз:\n%s/profile/email/confirm?token=%s\n\n" +
+				"Сілтеме %d сағат жарамды. Егер email-ді ауыстырмасаңыз, бұл хатты елемеңіз."},
+	},
+	// Аргументы: число неудачных попыток, срок блокировки в минутах, адрес сайта
+	"account_locked": {
+		"ru": {"Вход в аккаунт временно заблокирован",
+			"Здравствуйте!\n\nПосле %d неудачных попыток входа вход в ваш аккаунт BookEasy заблокирован на %d мин.\n" +
+				"Если это были не вы, смените пароль: %s/forgot-password.html"},
+		"en": {"Sign-in temporarily locked",
+			"Hello!\n\nAfter %d failed sign-in attempts, sign-in to your BookEasy account is locked for %d min.\n" +
+				"If this wasn't you, change your password: %s/forgot-password.html"},
+		"kk": {"Аккаунтқа кіру уақытша бұғатталды",
+			"Сәлеметсіз бе!\n\n%d сәтсіз кіру әрекетінен кейін BookEasy аккаунтыңызға кіру %d минутқа бұғатталды.\n" +
+				"Егер бұл сіз болмасаңыз, құпиясөзді өзгертіңіз: %s/forgot-password.html"},
+	},
+	// Аргументы: адрес сайта, токен, срок действия в часах
+	"data_export": {
+		"ru": {"Ваши данные готовы",
+			"Здравствуйте!\n\nАрхив с данными вашего аккаунта BookEasy готов. Скачать его можно, войдя в аккаунт:\n%s/profile/export/download?token=%s\n\n" +
+				"Ссылка действует %d ч. Если вы не запрашивали выгрузку, смените пароль."},
+		"en": {"Your data is ready",
+			"Hello!\n\nThe archive with your BookEasy account data is ready. Sign in to download it:\n%s/profile/export/download?token=%s\n\n" +
+				"The link is valid for %d h. If you did not request this export, change your password."},
+		"kk": {"Деректеріңіз дайын",
+			"Сәлеметсіз бе!\n\nBookEasy аккаунтыңыздың деректері бар мұрағат дайын. Оны аккаунтқа кіріп жүктеуге болады:\n%s/profile/export/download?token=%s\n\n" +
+				"Сілтеме %d сағат жарамды. Егер деректерді сұрамасаңыз, құпиясөзді өзгертіңіз."},
+	},
+	// Аргументы: название объекта, даты начала и окончания, срок удержания, стоимость, номер удержания
+	"waitlist_offer": {
+		"ru": {"Объект из листа ожидания освободился",
+			"Здравствуйте!\n\n%s освободился на даты %s — %s.\n" +
+				"Мы удерживаем его для вас до %s. Стоимость: %s.\n" +
+				"Чтобы оформить бронирование, оплатите удержание #%d в личном кабинете."},
+		"en": {"An item from your waitlist is now available",
+			"Hello!\n\n%s is now available for %s — %s.\n" +
+				"We are holding it for you until %s. Price: %s.\n" +
+				"To complete the booking, pay for hold #%d in your account."},
+		"kk": {"Күту тізіміндегі нысан босады",
+			"Сәлеметсіз бе!\n\n%s %s — %s күндеріне босады.\n" +
+				"Біз оны сіз үшін %s дейін сақтаймыз. Құны: %s.\n" +
+				"Брондауды рәсімдеу үшін жеке кабинетте #%d сақтауды төлеңіз."},
+	},
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест выбора языка по заголовку Accept-Language
+func TestNegotiateLocale(t *testing.T) {
+	cases := map[string]string{
+		"":                            "ru",
+		"en-US,en;q=0.9":              "en",
+		"de-DE, kk;q=0.8, en;q=0.5":   "kk",
+		"kz":                          "kk",
+		"fr, de":                      "ru",
+		"ru;q=0.3, en;q=0.7, *;q=0.1": "en",
+		"en;q=abc, kk":                "kk",
+	}
+	for header, want := range cases {
+		assert.Equal(t, want, negotiateLocale(header), header)
+	}
+}
+
+// Тест полноты каталога: у каждого сообщения и письма есть все переводы
+func TestCatalogComplete(t *testing.T) {
+	for msg, translations := range catalog {
+		for _, locale := range supportedLocales {
+			if locale == defaultLocale {
+				continue
+			}
+			assert.NotEmpty(t, translations[locale], "нет перевода %q на %s", msg, locale)
+		}
+	}
+	for name, templates := range emailTemplates {
+		for _, locale := range supportedLocales {
+			assert.NotEmpty(t, templates[locale].Subject, "нет письма %s на %s", name, locale)
+		}
+	}
+}
+
+// Тест ответа на языке клиента; язык из профиля важнее заголовка
+func TestLocalizedValidationErrors(t *testing.T) {
+	body := `{"item_type":"boat","item_id":1,"start_date":"2025-07-01","end_date":"2025-07-04"}`
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/holds", strings.NewReader(body))
+	req.Header.Set("Accept-Language", "en-GB,en;q=0.9")
+	var hold HoldRequest
+	assert.False(t, decodeAndValidate(rr, req, &hold))
+	assert.Equal(t, "en", rr.Header().Get("Content-Language"))
+	assert.JSONEq(t, `{"status":"fail","code":"validation_failed","message":"Invalid form data","errors":[
+		{"field":"item_type","message":"Allowed values: car, room"}
+	]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/holds", strings.NewReader(body))
+	req.Header.Set("Accept-Language", "en")
+	req.AddCookie(&http.Cookie{Name: localeCookieName, Value: "kk"})
+	assert.False(t, decodeAndValidate(rr, req, &hold))
+	assert.JSONEq(t, `{"status":"fail","code":"validation_failed","message":"Форма деректері қате","errors":[
+		{"field":"item_type","message":"Рұқсат етілген мәндер: car, room"}
+	]}`, rr.Body.String())
+}
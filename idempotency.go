@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+)
+
+// Заголовок, в котором клиент передаёт ключ идемпотентности
+const idempotencyHeader = "Idempotency-Key"
+
+// Обёртка ResponseWriter, которая запоминает код ответа и тело
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Middleware для платёжных запросов: повторный POST с тем же ключом не выполняет
+// операцию ещё раз, а возвращает сохранённый ответ первого запроса.
+// Ключ действует в пределах пути и пользователя
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || len(key) > 255 {
+			writeErrorCode(w, r, http.StatusBadRequest, "idempotency_key_required", "Требуется заголовок Idempotency-Key")
+			return
+		}
+		userID, _ := currentUserID(r)
+
+		// Занимаем ключ; если он уже есть, отдаём сохранённый ответ
+		result, err := db.Exec(`INSERT INTO idempotency_keys (key, path, user_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			key, r.URL.Path, userID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if claimed, _ := result.RowsAffected(); claimed == 0 {
+			var status *int
+			var body []byte
+			db.QueryRow(`SELECT status_code, response_body FROM idempotency_keys WHERE key = $1 AND path = $2 AND user_id = $3`,
+				key, r.URL.Path, userID).Scan(&status, &body)
+
+			w.Header().Set("Content-Type", "application/json")
+			if status == nil {
+				writeErrorCode(w, r, http.StatusConflict, "request_in_progress", "Запрос с этим ключом уже обрабатывается")
+				return
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(*status)
+			w.Write(body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		next(rec, r)
+
+		// После ошибки сервера ключ освобождается, чтобы клиент мог повторить запрос
+		if rec.status >= http.StatusInternalServerError {
+			db.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND path = $2 AND user_id = $3`, key, r.URL.Path, userID)
+			return
+		}
+		_, err = db.Exec(`UPDATE idempotency_keys SET status_code = $1, response_body = $2 WHERE key = $3 AND path = $4 AND user_id = $5`,
+			rec.status, rec.body.Bytes(), key, r.URL.Path, userID)
+		if err != nil {
+			log.Printf("Ошибка сохранения ответа для ключа идемпотентности: %v", err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ограничения попыток входа. Частоту запросов с одного IP и на один email
+// ограничивают корзины токенов в памяти; после нескольких неудач подряд каждая
+// следующая попытка возможна только после растущей паузы. После loginLockThreshold
+// неудач существующий аккаунт блокируется в базе на loginLockDuration
+var (
+	loginIPLimiter      = newRateLimiter(envInt("LOGIN_IP_BURST", 20), time.Duration(envInt("LOGIN_IP_REFILL_SECONDS", 3))*time.Second)
+	loginAccountLimiter = newRateLimiter(envInt("LOGIN_ACCOUNT_BURST", 5), time.Duration(envInt("LOGIN_ACCOUNT_REFILL_SECONDS", 30))*time.Second)
+	loginFailures       = newFailureTracker()
+
+	loginLockThreshold = envInt("LOGIN_LOCK_THRESHOLD", 10)
+	loginLockDuration  = time.Duration(envInt("LOGIN_LOCK_MINUTES", 15)) * time.Minute
+)
+
+// Неудачи, после которых начинаются паузы, и наибольшая пауза
+const (
+	loginFreeFailures = 3
+	loginMaxDelay     = time.Minute
+)
+
+// Корзина токенов: вмещает capacity попыток и пополняется на одну за interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	capacity float64
+	interval time.Duration
+	buckets  map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(capacity int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		capacity: float64(capacity),
+		interval: interval,
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+// Функция списания попытки. Если токенов нет, возвращает время до появления следующего
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= 10000 {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.capacity, b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Функция удаления полностью восстановившихся корзин, чтобы карта не росла бесконечно
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.last))/float64(l.interval) >= l.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Счётчик неудачных попыток подряд для email. Ведётся и для несуществующих
+// адресов, чтобы паузы не выдавали, зарегистрирован ли email
+type failureTracker struct {
+	mu       sync.Mutex
+	failures map[string]failureRecord
+}
+
+type failureRecord struct {
+	count int
+	last  time.Time
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{failures: map[string]failureRecord{}}
+}
+
+// Функция расчёта паузы после count неудач подряд: 1, 2, 4 ... секунд, не больше loginMaxDelay
+func loginDelay(count int) time.Duration {
+	if count < loginFreeFailures {
+		return 0
+	}
+	delay := time.Second << min(count-loginFreeFailures, 6)
+	return min(delay, loginMaxDelay)
+}
+
+// Функция возвращает, сколько ещё нужно ждать до следующей попытки
+func (t *failureTracker) wait(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec, ok := t.failures[key]
+	if !ok {
+		return 0
+	}
+	if now.Sub(rec.last) > loginLockDuration {
+		delete(t.failures, key)
+		return 0
+	}
+	return max(0, rec.last.Add(loginDelay(rec.count)).Sub(now))
+}
+
+// Функция учёта неудачной попытки
+func (t *failureTracker) fail(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.failures) >= 10000 {
+		for k, rec := range t.failures {
+			if now.Sub(rec.last) > loginLockDuration {
+				delete(t.failures, k)
+			}
+		}
+	}
+	rec := t.failures[key]
+	rec.count++
+	rec.last = now
+	t.failures[key] = rec
+}
+
+// Функция сброса счётчика после успешного входа или разблокировки
+func (t *failureTracker) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+// IP клиента из адреса соединения. X-Forwarded-For не учитывается:
+// без доверенного прокси его может подставить сам клиент
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Функция ответа на превышение лимита попыток входа. Ответ одинаков для лимитов,
+// пауз и заблокированного аккаунта, чтобы по нему нельзя было проверить email
+func writeTooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeErrorCode(w, r, http.StatusTooManyRequests, "too_many_attempts", "Слишком много попыток входа, попробуйте позже")
+}
+
+// Функция учёта неудачного входа в существующий аккаунт. При достижении порога
+// аккаунт блокируется, владельцу отправляется письмо
+func recordFailedLogin(userID int) error {
+	var locked bool
+	var email, locale string
+	err := db.QueryRow(`UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $2 THEN NOW() + make_interval(secs => $3) ELSE locked_until END
+		WHERE id = $1 RETURNING failed_logins = 0, email, COALESCE(locale, $4)`,
+		userID, loginLockThreshold, loginLockDuration.Seconds(), defaultLocale).Scan(&locked, &email, &locale)
+	if err != nil || !locked {
+		return err
+	}
+
+	log.Printf("Аккаунт %d заблокирован после %d неудачных попыток входа", userID, loginLockThreshold)
+	subject, body := renderEmail(locale, "account_locked", loginLockThreshold, int(loginLockDuration.Minutes()), appBaseURL)
+	return emailSender.SendEmail([]string{email}, subject, body)
+}
+
+// Функция снятия блокировки аккаунта администратором
+func unlockAccount(email string) (bool, error) {
+	res, err := db.Exec(`UPDATE users SET locked_until = NULL, failed_logins = 0 WHERE lower(email) = $1`, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if n > 0 {
+		loginFailures.reset(email)
+	}
+	return n > 0, err
+}
+
+// Функция проверки блокировки, считанной вместе с данными для входа
+func lockRemaining(lockedUntil sql.NullTime, now time.Time) time.Duration {
+	if !lockedUntil.Valid {
+		return 0
+	}
+	return max(0, lockedUntil.Time.Sub(now))
+}
+
+// Обработчик снятия блокировки входа администратором
+func adminUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var req EmailRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		found, err := unlockAccount(normalizeEmail(req.Email))
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "Пользователь не найден")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Блокировка снята", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
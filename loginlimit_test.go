@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: корзина пропускает capacity попыток подряд и пополняется со временем
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter(3, 10*time.Second)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.allow("1.2.3.4", now)
+		assert.True(t, ok)
+	}
+	ok, wait := limiter.allow("1.2.3.4", now)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	ok, _ = limiter.allow("5.6.7.8", now)
+	assert.True(t, ok, "другой ключ")
+
+	ok, _ = limiter.allow("1.2.3.4", now.Add(10*time.Second))
+	assert.True(t, ok)
+}
+
+// Тест: паузы растут после нескольких неудач подряд и сбрасываются после входа
+func TestLoginFailureDelays(t *testing.T) {
+	assert.Equal(t, time.Duration(0), loginDelay(loginFreeFailures-1))
+	assert.Equal(t, time.Second, loginDelay(loginFreeFailures))
+	assert.Equal(t, 4*time.Second, loginDelay(loginFreeFailures+2))
+	assert.Equal(t, loginMaxDelay, loginDelay(100))
+
+	tracker := newFailureTracker()
+	now := time.Now()
+	for i := 0; i < loginFreeFailures+1; i++ {
+		tracker.fail("user@example.com", now)
+	}
+	assert.Equal(t, 2*time.Second, tracker.wait("user@example.com", now))
+	assert.Equal(t, time.Duration(0), tracker.wait("user@example.com", now.Add(2*time.Second)))
+
+	tracker.reset("user@example.com")
+	assert.Equal(t, time.Duration(0), tracker.wait("user@example.com", now))
+}
+
+// Тест: заблокированный аккаунт не пускает даже с верным паролем
+func TestHandleLoginLockedAccount(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT id, password, is_confirmed").
+		WithArgs("locked@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "is_confirmed", "locale", "totp_enabled", "locked_until"}).
+			AddRow(2, "password123", true, "", false, time.Now().Add(10*time.Minute)))
+
+	rr := httptest.NewRecorder()
+	handleLogin(rr, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"locked@example.com","password":"password123"}`)))
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"too_many_attempts"`)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,18 +1,18 @@
 package main
 
 import (
+	"crypto/subtle"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
-	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"net/smtp"
+	"net/url"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -21,21 +21,15 @@ import (
 
 // Структура для данных запроса
 type RequestData struct {
-	Message string `json:"message"`
-}
-
-// Структура для ответа сервера
-type Response struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Message string `json:"message" validate:"required,max=2000"`
 }
 
 // Структура для регистрации пользователя
 type User struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	LastName  string `json:"last_name" validate:"required,max=100"`
+	Email     string `json:"email" validate:"required,email,max=255"`
+	Password  string `json:"password" validate:"required,min=8,max=128"`
 }
 
 // Структура для получения данных из базы
@@ -63,23 +57,109 @@ func main() {
 		return
 	}
 
+	// Создание недостающих таблиц
+	err = migrate(db)
+	if err != nil {
+		fmt.Println("Ошибка миграции базы данных:", err)
+		return
+	}
+
+	// Загрузка курсов валют
+	err = loadExchangeRates(exchangeRatesFile)
+	if err != nil {
+		fmt.Println("Ошибка загрузки курсов валют:", err)
+		return
+	}
+
+	// Восстановление местоположения автомобилей по филиалам
+	err = restoreCarLocations()
+	if err != nil {
+		fmt.Println("Ошибка загрузки местоположения автомобилей:", err)
+		return
+	}
+
+	// Фоновое удаление истёкших удержаний и раздача освободившихся объектов листу ожидания
+	go runHoldReaper(holdReapInterval)
+
+	// Фоновое удаление просроченных архивов с данными пользователей
+	go runExportPurger(exportPurgeInterval)
+
 	// Статические файлы из папки "static"
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
 
 	// Обработчики для операций CRUD
+	http.HandleFunc("/openapi.json", handleOpenAPI)
+	http.HandleFunc("/csrf-token", handleCSRFToken)
 	http.HandleFunc("/register", handleRegister)
 	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/login/2fa", handleLoginMFA)
+	http.HandleFunc("/oidc/providers", handleOIDCProviders)
+	http.HandleFunc("/oidc/login", handleOIDCLogin)
+	http.HandleFunc("/oidc/callback", handleOIDCCallback)
+	http.HandleFunc("/logout", handleLogout)
 	http.HandleFunc("/profile", handleProfile)
+	http.HandleFunc("/profile/avatar", handleProfileAvatar)
+	http.HandleFunc("/profile/email", handleProfileEmail)
+	http.HandleFunc("/profile/email/confirm", handleConfirmEmailChange)
+	http.HandleFunc("/profile/delete", handleDeleteAccount)
+	http.HandleFunc("/profile/export", handleRequestExport)
+	http.HandleFunc("/profile/2fa/setup", handleTOTPSetup)
+	http.HandleFunc("/profile/2fa/enable", handleTOTPEnable)
+	http.HandleFunc("/profile/2fa/disable", handleTOTPDisable)
+	http.HandleFunc("/profile/export/download", handleDownloadExport)
+	http.HandleFunc("/avatars/", handleAvatar)
 	http.HandleFunc("/send-support-message", handleSendSupportMessage)
 	http.HandleFunc("/send-chat-message", handleSendMessage)
 	http.HandleFunc("/messages", handleSelectMessages)
-	http.HandleFunc("/clear-messages", handleClearMessages)
+	http.Handle("/clear-messages", adminMiddleware(http.HandlerFunc(handleClearMessages)))
 	http.HandleFunc("/confirm", handleConfirm)
+	http.HandleFunc("/confirm/resend", handleResendConfirmation)
+	http.HandleFunc("/password/forgot", handleForgotPassword)
+	http.HandleFunc("/password/reset", handleResetPassword)
+	http.HandleFunc("/cars", carsHandler)
+	http.HandleFunc("/cars/book", handleCarBooking)
+	http.HandleFunc("/branches", handleBranches)
+	http.HandleFunc("/quote", handleQuote)
+	http.HandleFunc("/payments/authorize", withIdempotency(handlePaymentAuthorize))
+	http.HandleFunc("/payments/capture", withIdempotency(handlePaymentCapture))
+	http.HandleFunc("/payments/webhook", handlePaymentWebhook)
+	http.HandleFunc("/holds", handleCreateHold)
+	http.HandleFunc("/holds/release", handleReleaseHold)
+	http.HandleFunc("/holds/checkout", withIdempotency(handleHoldCheckout))
+	http.HandleFunc("/waitlist", handleJoinWaitlist)
+	http.HandleFunc("/waitlist/my", handleMyWaitlist)
+	http.HandleFunc("/waitlist/leave", handleLeaveWaitlist)
+	http.HandleFunc("/trips", handleTrips)
+	http.HandleFunc("/trips/items", handleAddTripItem)
+	http.HandleFunc("/trips/checkout", withIdempotency(handleTripCheckout))
+	http.HandleFunc("/trips/cancel", handleCancelTrip)
+	http.HandleFunc("/bookings/my", handleMyBookings)
+	http.HandleFunc("/bookings/cancel", handleCancelBooking)
+	http.HandleFunc("/bookings/modify", withIdempotency(handleModifyBooking))
+	http.HandleFunc("/api/v1/cars", withAPIKey(apiScopeSearch, apiCarsHandler))
+	http.HandleFunc("/api/v1/rooms", withAPIKey(apiScopeSearch, apiRoomsHandler))
+	http.HandleFunc("/api/v1/branches", withAPIKey(apiScopeSearch, handleBranches))
+	http.HandleFunc("/api/v1/quote", withAPIKey(apiScopeSearch, handleQuote))
+	http.HandleFunc("/api/v1/holds", withAPIKey(apiScopeBook, handleCreateHold))
+	http.HandleFunc("/api/v1/holds/release", withAPIKey(apiScopeBook, handleReleaseHold))
+	http.HandleFunc("/api/v1/holds/checkout", withAPIKey(apiScopeBook, withIdempotency(handleHoldCheckout)))
+	http.HandleFunc("/api/v1/bookings", withAPIKey(apiScopeBook, handleMyBookings))
+	http.HandleFunc("/api/v1/bookings/cancel", withAPIKey(apiScopeBook, handleCancelBooking))
+	http.Handle("/admin/maintenance", adminMiddleware(http.HandlerFunc(adminMaintenanceHandler)))
+	http.Handle("/admin/users/unlock", adminMiddleware(http.HandlerFunc(adminUnlockHandler)))
+	http.Handle("/admin/branches", adminMiddleware(http.HandlerFunc(adminBranchesHandler)))
+	http.Handle("/admin/bookings/return", adminMiddleware(http.HandlerFunc(adminReturnHandler)))
+	http.Handle("/admin/bookings/status", adminMiddleware(http.HandlerFunc(adminBookingStatusHandler)))
+	http.Handle("/admin/rates", adminMiddleware(http.HandlerFunc(adminRatesHandler)))
+	http.Handle("/admin/payments/refund", adminMiddleware(withIdempotency(adminRefundHandler)))
+	http.Handle("/admin/partners", adminMiddleware(http.HandlerFunc(adminPartnersHandler)))
+	http.Handle("/admin/api-keys", adminMiddleware(http.HandlerFunc(adminAPIKeysHandler)))
+	http.Handle("/admin/api-keys/revoke", adminMiddleware(http.HandlerFunc(adminRevokeAPIKeyHandler)))
 
 	// Запуск сервера
 	fmt.Println("Сервер запущен на http://localhost:8080")
-	err = http.ListenAndServe(":8080", nil)
+	err = http.ListenAndServe(":8080", withRequestID(withCORS(cors, withCSRF(http.DefaultServeMux))))
 	if err != nil {
 		fmt.Println("Ошибка запуска сервера:", err)
 	}
@@ -96,64 +176,70 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodPost {
 		var user User
-		err := json.NewDecoder(r.Body).Decode(&user)
-
-		if err != nil || user.FirstName == "" || user.LastName == "" || user.Email == "" || user.Password == "" {
-			http.Error(w, `{"status":"fail","message":"Некорректные данные формы"}`, http.StatusBadRequest)
+		if !decodeAndValidate(w, r, &user) {
 			return
 		}
+		user.Email = normalizeEmail(user.Email)
 
 		// Генерация токена
-		token := generateToken()
+		token, err := randomToken()
+		if err != nil {
+			log.Printf("Ошибка генерации токена: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
 
-		// Сохранение пользователя с токеном
-		_, err = db.Exec(`INSERT INTO users (first_name, last_name, email, password, confirmation_token) VALUES ($1, $2, $3, $4, $5)`,
-			user.FirstName, user.LastName, user.Email, user.Password, token)
+		// Сохранение пользователя с хешем токена; время отправки ставится по умолчанию.
+		// Email уникален без учёта регистра
+		result, err := db.Exec(`INSERT INTO users (first_name, last_name, email, password, confirmation_token) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ((lower(email))) DO NOTHING`,
+			user.FirstName, user.LastName, user.Email, user.Password, hashToken(token))
 		if err != nil {
 			log.Printf("Ошибка SQL: %v", err)
-			http.Error(w, `{"status":"error","message":"Ошибка сохранения данных в базе"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
 			return
 		}
 
-		// Отправка email
-		err = sendConfirmationEmail(user.Email, token)
+		// Отправка email. Если аккаунт уже есть, владелец получает письмо об этом,
+		// а ответ остаётся тем же, чтобы по нему нельзя было проверить email
+		if created, _ := result.RowsAffected(); created == 0 {
+			err = sendAccountExistsEmail(user.Email, requestLocale(r))
+		} else {
+			err = sendConfirmationEmail(user.Email, token, requestLocale(r))
+		}
 		if err != nil {
 			log.Printf("Ошибка отправки email: %v", err)
-			http.Error(w, `{"status":"error","message":"Ошибка отправки email"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка отправки email")
 			return
 		}
 
-		response := map[string]string{
-			"status":  "success",
-			"message": "Пользователь успешно зарегистрирован. Проверьте email для подтверждения.",
-		}
-		json.NewEncoder(w).Encode(response)
+		writeSuccess(w, r, http.StatusOK, "Пользователь успешно зарегистрирован. Проверьте email для подтверждения.", nil)
 		return
 	}
 
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
-func generateToken() string {
-	return fmt.Sprintf("%x", time.Now().UnixNano()) // Уникальный токен
+// Email сравнивается без учёта регистра и пробелов по краям
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
-func sendConfirmationEmail(email, token string) error {
-	smtpHost := "smtp.mail.ru"
-	smtpPort := "587"
-	from := "bookeasy_help@mail.ru"
-	password := "L1sFSSHs1qax2Cy3ssxN"
-	to := []string{email}
+// Письмо на повторную регистрацию уже существующего email
+func sendAccountExistsEmail(email, locale string) error {
+	subject, body := renderEmail(locale, "account_exists", appBaseURL, appBaseURL)
+	return emailSender.SendEmail([]string{email}, subject, body)
+}
 
-	subject := "Подтверждение регистрации"
-	body := fmt.Sprintf("Здравствуйте!\n\nПерейдите по ссылке для подтверждения регистрации:\nhttp://localhost:8080/confirm?token=%s", token)
+// Срок действия ссылки подтверждения, отсчитывается от времени отправки письма
+var confirmationLifetime = time.Duration(envInt("CONFIRMATION_HOURS", 24)) * time.Hour
 
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-	msg := []byte("To: " + email + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"\r\n" + body + "\r\n")
+// Минимальный интервал между повторными письмами подтверждения
+var confirmationResendInterval = time.Duration(envInt("CONFIRMATION_RESEND_SECONDS", 60)) * time.Second
 
-	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
+func sendConfirmationEmail(email, token, locale string) error {
+	subject, body := renderEmail(locale, "confirmation", appBaseURL, token, int(confirmationLifetime.Hours()))
+	return emailSender.SendEmail([]string{email}, subject, body)
 }
 
 func handleConfirm(w http.ResponseWriter, r *http.Request) {
@@ -162,275 +248,299 @@ func handleConfirm(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		token := r.URL.Query().Get("token")
 		if token == "" {
-			http.Error(w, `{"status":"fail","message":"Токен не указан"}`, http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, "Токен не указан")
 			return
 		}
 
-		// Проверка токена и активация пользователя
-		result, err := db.Exec(`UPDATE users SET is_confirmed = TRUE, confirmation_token = NULL WHERE confirmation_token = $1`, token)
+		// Проверка токена и активация пользователя. В базе хранится только хеш токена
+		var userID int
+		var expired bool
+		err := db.QueryRow(`SELECT id, COALESCE(confirmation_sent_at <= NOW() - make_interval(secs => $2), TRUE) FROM users WHERE confirmation_token = $1`,
+			hashToken(token), confirmationLifetime.Seconds()).Scan(&userID, &expired)
+		if err == sql.ErrNoRows {
+			writeErrorCode(w, r, http.StatusBadRequest, "token_invalid", "Некорректный токен")
+			return
+		}
 		if err != nil {
 			log.Printf("Ошибка SQL: %v", err)
-			http.Error(w, `{"status":"error","message":"Ошибка базы данных"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
 			return
 		}
-
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			http.Error(w, `{"status":"fail","message":"Некорректный токен"}`, http.StatusBadRequest)
+		if expired {
+			writeErrorCode(w, r, http.StatusGone, "token_expired", "Срок действия ссылки истёк. Запросите новое письмо")
 			return
 		}
 
-		response := map[string]string{
-			"status":  "success",
-			"message": "Регистрация подтверждена. Теперь вы можете войти.",
+		_, err = db.Exec(`UPDATE users SET is_confirmed = TRUE, confirmation_token = NULL WHERE id = $1`, userID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
 		}
-		json.NewEncoder(w).Encode(response)
+
+		writeSuccess(w, r, http.StatusOK, "Регистрация подтверждена. Теперь вы можете войти.", nil)
 		return
 	}
 
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
-// Структура для данных запроса
-type LoginData struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+// Запрос с одним email: повторное письмо, восстановление пароля, разблокировка
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
 }
 
-func handleLogin(w http.ResponseWriter, r *http.Request) {
+// Обработчик повторной отправки письма подтверждения. Новый токен заменяет прежний;
+// письмо уходит не чаще раза в confirmationResendInterval. Ответ всегда одинаковый,
+// чтобы по нему нельзя было узнать, зарегистрирован ли email
+func handleResendConfirmation(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	if r.Method == http.MethodOptions {
-		return
-	}
 
 	if r.Method == http.MethodPost {
-		var user User
-		err := json.NewDecoder(r.Body).Decode(&user)
-		if err != nil || user.Email == "" || user.Password == "" {
-			http.Error(w, `{"status":"fail","message":"Некорректные данные формы"}`, http.StatusBadRequest)
+		var req EmailRequest
+		if !decodeAndValidate(w, r, &req) {
 			return
 		}
 
-		var storedPassword string
-		err = db.QueryRow(`SELECT password FROM users WHERE email = $1`, user.Email).Scan(&storedPassword)
+		token, err := randomToken()
 		if err != nil {
-			http.Error(w, `{"status":"fail","message":"Пользователь не найден"}`, http.StatusUnauthorized)
+			log.Printf("Ошибка генерации токена: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
 			return
 		}
 
-		if storedPassword != user.Password {
-			http.Error(w, `{"status":"fail","message":"Неверный пароль"}`, http.StatusUnauthorized)
+		// Условие на время отправки проверяется в том же запросе, поэтому
+		// параллельные запросы не отправят несколько писем
+		result, err := db.Exec(`UPDATE users SET confirmation_token = $1, confirmation_sent_at = NOW()
+			WHERE lower(email) = $2 AND NOT is_confirmed
+				AND (confirmation_sent_at IS NULL OR confirmation_sent_at <= NOW() - make_interval(secs => $3))`,
+			hashToken(token), normalizeEmail(req.Email), confirmationResendInterval.Seconds())
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
 			return
 		}
-
-		var isConfirmed bool
-		err = db.QueryRow(`SELECT is_confirmed FROM users WHERE email = $1`, user.Email).Scan(&isConfirmed)
-		if err != nil || !isConfirmed {
-			http.Error(w, `{"status":"fail","message":"Подтвердите email перед входом"}`, http.StatusForbidden)
-			return
+		if n, _ := result.RowsAffected(); n > 0 {
+			if err := sendConfirmationEmail(req.Email, token, requestLocale(r)); err != nil {
+				log.Printf("Ошибка отправки email: %v", err)
+			}
 		}
 
-		response := map[string]string{
-			"status":  "success",
-			"message": "Вы успешно вошли",
-		}
-		json.NewEncoder(w).Encode(response)
+		writeSuccess(w, r, http.StatusOK, "Если аккаунт ожидает подтверждения, мы отправили новое письмо. Повторить можно через минуту", nil)
 		return
 	}
 
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
+}
+
+// Структура для данных запроса
+type LoginData struct {
+	Email    string `json:"email" validate:"required,max=255"`
+	Password string `json:"password" validate:"required,max=128"`
 }
 
-// Функция для получения данных пользователя
-func handleProfile(w http.ResponseWriter, r *http.Request) {
+func handleLogin(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	if r.Method == http.MethodOptions {
-		return
-	}
+	if r.Method == http.MethodPost {
+		now := time.Now()
+		if ok, wait := loginIPLimiter.allow(clientIP(r), now); !ok {
+			writeTooManyAttempts(w, r, wait)
+			return
+		}
 
-	if r.Method == http.MethodGet {
-		// Получаем email из параметров URL
-		email := r.URL.Query().Get("email")
-		if email == "" {
-			http.Error(w, `{"status":"fail","message":"Email не указан"}`, http.StatusBadRequest)
+		var user LoginData
+		if !decodeAndValidate(w, r, &user) {
 			return
 		}
+		email := normalizeEmail(user.Email)
 
-		// Получаем данные пользователя из базы данных
-		var user User
-		err := db.QueryRow(`SELECT first_name, last_name, email FROM users WHERE email = $1`, email).Scan(&user.FirstName, &user.LastName, &user.Email)
-		if err != nil {
-			log.Println("Ошибка при запросе к БД:", err)
-			http.Error(w, `{"status":"fail","message":"Пользователь не найден"}`, http.StatusNotFound)
+		if wait := loginFailures.wait(email, now); wait > 0 {
+			writeTooManyAttempts(w, r, wait)
+			return
+		}
+		if ok, wait := loginAccountLimiter.allow(email, now); !ok {
+			writeTooManyAttempts(w, r, wait)
 			return
 		}
 
-		// Отправляем данные пользователя в ответ
-		json.NewEncoder(w).Encode(user)
+		// Неизвестный email и неверный пароль дают одинаковый ответ
+		var userID int
+		var storedPassword, locale string
+		var isConfirmed, totpEnabled bool
+		var lockedUntil sql.NullTime
+		err := db.QueryRow(`SELECT id, password, is_confirmed, COALESCE(locale, ''), totp_enabled, locked_until FROM users WHERE lower(email) = $1`, email).
+			Scan(&userID, &storedPassword, &isConfirmed, &locale, &totpEnabled, &lockedUntil)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Ошибка SQL: %v", err)
+		}
+		// Заблокированный аккаунт отвечает так же, как превышение лимита, ещё до проверки пароля
+		if wait := lockRemaining(lockedUntil, now); err == nil && wait > 0 {
+			writeTooManyAttempts(w, r, wait)
+			return
+		}
+		if err != nil || subtle.ConstantTimeCompare([]byte(storedPassword), []byte(user.Password)) != 1 {
+			loginFailures.fail(email, now)
+			if err == nil {
+				if err := recordFailedLogin(userID); err != nil {
+					log.Printf("Ошибка учёта неудачного входа: %v", err)
+				}
+			}
+			writeErrorCode(w, r, http.StatusUnauthorized, "invalid_credentials", "Неверный email или пароль")
+			return
+		}
+		loginFailures.reset(email)
+		if _, err := db.Exec(`UPDATE users SET failed_logins = 0 WHERE id = $1 AND failed_logins > 0`, userID); err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+		}
+
+		if !isConfirmed {
+			writeErrorCode(w, r, http.StatusForbidden, "email_not_confirmed", "Подтвердите email перед входом")
+			return
+		}
+
+		// При включённой двухфакторной аутентификации сессия создаётся
+		// только после ввода кода на /login/2fa
+		if totpEnabled {
+			token, err := createMFAChallenge(userID)
+			if err != nil {
+				log.Printf("Ошибка создания входа с кодом: %v", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+				return
+			}
+			writeSuccess(w, r, http.StatusOK, "Введите код из приложения-аутентификатора или код восстановления",
+				MFAChallenge{Required: true, Token: token})
+			return
+		}
+
+		// Создание сессии
+		if err := startSession(w, userID, false); err != nil {
+			log.Printf("Ошибка создания сессии: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		// Язык из профиля запоминается в cookie и используется в следующих ответах
+		if isSupportedLocale(locale) {
+			setLocaleCookie(w, locale)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Вы успешно вошли", nil)
 		return
 	}
 
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
 // Обработчик для отправки сообщений
 func handleSendSupportMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*") // Разрешаем CORS
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 
 	if r.Method == http.MethodPost {
 		// Ограничиваем размер запроса
 		r.ParseMultipartForm(10 << 20)
 
-		// Получаем данные формы
+		// Получаем и проверяем данные формы
 		email := r.FormValue("email")
 		message := r.FormValue("message")
+		form := struct {
+			Email   string `json:"email" validate:"required,email,max=255"`
+			Message string `json:"message" validate:"required,max=5000"`
+		}{email, message}
+		if errs := validateLocale(form, requestLocale(r)); len(errs) > 0 {
+			writeValidationErrors(w, r, errs)
+			return
+		}
 
-		var attachment multipart.File
-		var err error
-
-		if r.MultipartForm != nil && r.MultipartForm.File["attachment"] != nil {
-			attachment, _, err = r.FormFile("attachment")
-			if err != nil {
-				log.Println("Ошибка чтения вложения:", err)
-				http.Error(w, `{"status":"fail","message":"Failed to read attachment"}`, http.StatusBadRequest)
-				return
-			}
-			defer attachment.Close()
-
-			// Сохраняем файл на диск
-			file, err := ioutil.TempFile("./uploads", "attachment-*.jpg")
-			if err != nil {
-				log.Println("Ошибка сохранения файла:", err)
-				http.Error(w, `{"status":"fail","message":"Failed to save file"}`, http.StatusInternalServerError)
-				return
-			}
-			defer file.Close()
+		// Вложение необязательно; сохраняются только изображения и PDF
+		var attachment *string
+		path, err := saveUpload(r, "attachment", uploadsDir, "attachment", attachmentUploadTypes)
+		switch {
+		case err == nil:
+			log.Println("Файл сохранён:", path)
+			name := filepath.Base(path)
+			attachment = &name
+		case errors.Is(err, errUnsupportedUpload):
+			writeError(w, r, http.StatusBadRequest, "Вложение должно быть изображением JPEG, PNG или файлом PDF")
+			return
+		case !errors.Is(err, errNoUpload):
+			log.Println("Ошибка сохранения вложения:", err)
+			writeError(w, r, http.StatusInternalServerError, "Не удалось сохранить вложение")
+			return
+		}
 
-			_, err = io.Copy(file, attachment)
-			if err != nil {
-				log.Println("Ошибка копирования файла:", err)
-				http.Error(w, `{"status":"fail","message":"Failed to copy attachment"}`, http.StatusInternalServerError)
-				return
-			}
-			log.Println("Файл сохранён:", file.Name())
+		// Сохраняем обращение, чтобы пользователь мог получить его в выгрузке своих данных
+		var author *int
+		if userID, err := currentUserID(r); err == nil {
+			author = &userID
+		}
+		_, err = db.Exec("INSERT INTO support_messages (user_id, email, message, attachment) VALUES ($1, $2, $3, $4)",
+			author, email, message, attachment)
+		if err != nil {
+			log.Println("Ошибка сохранения обращения:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных")
+			return
 		}
 
 		// Создаем письмо
 		subject := "Support Request"
 		body := fmt.Sprintf("Email: %s\nMessage: %s", email, message)
 
-		// Настройка SMTP
-		smtpHost := "smtp.mail.ru"
-		smtpPort := "587"
-		from := "bookeasy_help@mail.ru"
-		password := "L1sFSSHs1qax2Cy3ssxN"
-		to := []string{"erme.shoinov@bk.ru"}
-
-		auth := smtp.PlainAuth("", from, password, smtpHost)
-		msg := []byte("To: erme.shoinov@bk.ru\r\n" +
-			"Subject: " + subject + "\r\n" +
-			"\r\n" + body + "\r\n")
-
 		// Отправка письма
-		err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
+		err = emailSender.SendEmail([]string{"erme.shoinov@bk.ru"}, subject, body)
 		if err != nil {
 			log.Println("Ошибка отправки письма:", err)
-			http.Error(w, `{"status":"fail","message":"Failed to send email"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка отправки email")
 			return
 		}
 
-		// Успешный ответ
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status": "success", "message": "Message sent successfully"}`)
+		writeSuccess(w, r, http.StatusOK, "Сообщение отправлено в поддержку", nil)
 		return
 	}
 
-	http.Error(w, `{"status":"fail","message":"Invalid request method"}`, http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
 func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 
 	if r.Method == http.MethodPost {
-		var requestData struct {
-			Message string `json:"message"`
-		}
-
-		// Декодируем данные из запроса
-		err := json.NewDecoder(r.Body).Decode(&requestData)
-		if err != nil || requestData.Message == "" {
-			log.Println("Некорректные данные формы:", err)
-			http.Error(w, `{"status":"fail","message":"Некорректные данные формы"}`, http.StatusBadRequest)
+		var requestData RequestData
+		if !decodeAndValidate(w, r, &requestData) {
 			return
 		}
 
-		// Сохраняем сообщение в базу данных
-		_, err = db.Exec("INSERT INTO messages (content) VALUES ($1)", requestData.Message)
+		// Сохраняем сообщение в базу данных; автор известен, если пользователь вошёл
+		var author *int
+		if userID, err := currentUserID(r); err == nil {
+			author = &userID
+		}
+		_, err := db.Exec("INSERT INTO messages (content, user_id) VALUES ($1, $2)", requestData.Message, author)
 		if err != nil {
 			log.Println("Ошибка сохранения данных в базу данных:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка сохранения данных"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных")
 			return
 		}
 
 		// Успешный ответ
-		response := map[string]string{
-			"status":  "success",
-			"message": "Сообщение успешно отправлено",
-		}
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Println("Ошибка кодирования ответа:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка формирования ответа"}`, http.StatusInternalServerError)
-		}
+		writeSuccess(w, r, http.StatusOK, "Сообщение успешно отправлено", nil)
 		return
 	}
 
 	// Если метод не поддерживается
 	log.Println("Метод не поддерживается:", r.Method)
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
 // Обработчик для SELECT (все сообщения)
 func handleSelectMessages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 
 	if r.Method == http.MethodGet {
 		rows, err := db.Query("SELECT id, content FROM messages")
 		if err != nil {
 			log.Println("Ошибка запроса к базе данных:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка получения данных"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
 			return
 		}
 		defer rows.Close()
@@ -447,7 +557,7 @@ func handleSelectMessages(w http.ResponseWriter, r *http.Request) {
 			}
 			if err := rows.Scan(&msg.ID, &msg.Content); err != nil {
 				log.Println("Ошибка обработки строки:", err)
-				http.Error(w, `{"status":"error","message":"Ошибка обработки данных"}`, http.StatusInternalServerError)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка обработки данных")
 				return
 			}
 			messages = append(messages, msg)
@@ -455,90 +565,101 @@ func handleSelectMessages(w http.ResponseWriter, r *http.Request) {
 
 		if err := rows.Err(); err != nil {
 			log.Println("Ошибка итерации строк:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка обработки данных"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка обработки данных")
 			return
 		}
 
 		// Успешный ответ
-		if err := json.NewEncoder(w).Encode(messages); err != nil {
-			log.Println("Ошибка кодирования JSON:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка формирования ответа"}`, http.StatusInternalServerError)
-		}
+		writeSuccess(w, r, http.StatusOK, "", messages)
 		return
 	}
 
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
-// Обработчик для очистки всех сообщений
+// Обработчик для очистки чата администратором. Обращения в поддержку
+// не удаляются: они входят в выгрузку данных пользователя
 func handleClearMessages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 
 	if r.Method == http.MethodPost {
-		_, err := db.Exec("DELETE FROM support_messages")
+		_, err := db.Exec("DELETE FROM messages")
 		if err != nil {
 			log.Println("Ошибка при очистке сообщений:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка очистки данных"}`, http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка очистки данных")
 			return
 		}
 
-		response := map[string]string{
-			"status":  "success",
-			"message": "Сообщения успешно очищены",
-		}
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Println("Ошибка формирования JSON-ответа:", err)
-			http.Error(w, `{"status":"error","message":"Ошибка формирования ответа"}`, http.StatusInternalServerError)
-		}
+		writeSuccess(w, r, http.StatusOK, "Сообщения успешно очищены", nil)
 		return
 	}
 
 	log.Println("Метод не поддерживается:", r.Method)
-	http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	writeMethodNotAllowed(w, r)
 }
 
 type Car struct {
-	Model    string
-	Price    int
-	Rating   float64
-	Category string
-	Brand    string
+	ID       int
+	Model    string  `validate:"required,max=100"`
+	Price    Money   // Цена за сутки в базовой валюте автомобиля
+	Rating   float64 `validate:"min=0,max=5"`
+	Category string  `validate:"required,oneof=Sedan|SUV|Sports|Electric|Van"`
+	Brand    string  `validate:"max=50"`
+	BranchID int     // Филиал, где автомобиль находится сейчас
+	Policy   string  `validate:"oneof=flexible|moderate|strict"` // Правила отмены: flexible, moderate или strict
+}
+
+// Проверка цены и филиала автомобиля, добавляемого администратором
+func (c Car) Validate() []FieldError {
+	var errs []FieldError
+	if c.Price.Amount <= 0 {
+		errs = append(errs, FieldError{"Price.amount", "Цена должна быть больше нуля"})
+	}
+	if _, ok := currencyExponents[c.Price.Currency]; !ok {
+		errs = append(errs, FieldError{"Price.currency", "Неизвестная валюта"})
+	}
+	if c.BranchID != 0 {
+		if _, ok := findBranch(c.BranchID); !ok {
+			errs = append(errs, FieldError{"BranchID", "Филиал не найден"})
+		}
+	}
+	return errs
 }
 
 var cars = []Car{
-	{"Toyota Corolla", 50, 4.5, "Sedan", "Toyota"},
-	{"Ford Explorer", 80, 4.0, "SUV", "Ford"},
-	{"Tesla Model 3", 120, 5.0, "Electric", "Tesla"},
-	{"Honda Civic", 40, 4.2, "Sedan", "Honda"},
-	{"BMW XM", 200, 5.0, "SUV", "BMW"},
-	{"Cadillac Escalade", 150, 4.8, "SUV", "Cadillac"},
-	{"Rolls Royce Cullinan", 5000, 5.0, "SUV", "Rolls Royce"},
-	{"Mercedes G63", 300, 4.9, "SUV", "Mercedes"},
-	{"Mercedes GLE53", 150, 4.5, "SUV", "Mercedes"},
-	{"GMC SLT", 100, 4.0, "SUV", "GMC"},
-	{"Porsche Macan", 300, 4.7, "SUV", "Porsche"},
-	{"Nissan Patrol", 100, 4.2, "SUV", "Nissan"},
-	{"BMW M4 Competition", 200, 4.8, "Sedan", "BMW"},
-	{"Audi RS3", 220, 4.6, "Sedan", "Audi"},
-	{"Audi RS5", 270, 4.7, "Sedan", "Audi"},
-	{"Audi S8", 300, 4.9, "Sedan", "Audi"},
-	{"BMW 730LI", 290, 4.6, "Sedan", "BMW"},
-	{"Mercedes EQE 350", 120, 4.5, "Electric", "Mercedes"},
-	{"Tesla Model 3", 120, 5.0, "Electric", "Tesla"},
-	{"Porsche 718", 4718, 4.9, "Sports", "Porsche"},
-	{"Porsche 911 Turbo S", 9000, 5.0, "Sports", "Porsche"},
-	{"Ferrari F8 Tributo", 9999, 5.0, "Sports", "Ferrari"},
-	{"Audi R8", 2000, 4.8, "Sports", "Audi"},
-	{"Audi RS6", 300, 4.7, "Sports", "Audi"},
-	{"Mercedes V250", 2500, 4.6, "Van", "Mercedes"},
+	{1, "Toyota Corolla", major(50, "USD"), 4.5, "Sedan", "Toyota", 1, "flexible"},
+	{2, "Ford Explorer", major(80, "USD"), 4.0, "SUV", "Ford", 2, "flexible"},
+	{3, "Tesla Model 3", major(120, "USD"), 5.0, "Electric", "Tesla", 3, "flexible"},
+	{4, "Honda Civic", major(40, "USD"), 4.2, "Sedan", "Honda", 1, "flexible"},
+	{5, "BMW XM", major(200, "USD"), 5.0, "SUV", "BMW", 2, "moderate"},
+	{6, "Cadillac Escalade", major(150, "USD"), 4.8, "SUV", "Cadillac", 3, "flexible"},
+	{7, "Rolls Royce Cullinan", major(5000, "USD"), 5.0, "SUV", "Rolls Royce", 1, "strict"},
+	{8, "Mercedes G63", major(300, "USD"), 4.9, "SUV", "Mercedes", 2, "moderate"},
+	{9, "Mercedes GLE53", major(150, "USD"), 4.5, "SUV", "Mercedes", 3, "flexible"},
+	{10, "GMC SLT", major(100, "USD"), 4.0, "SUV", "GMC", 1, "flexible"},
+	{11, "Porsche Macan", major(300, "USD"), 4.7, "SUV", "Porsche", 2, "moderate"},
+	{12, "Nissan Patrol", major(100, "USD"), 4.2, "SUV", "Nissan", 3, "flexible"},
+	{13, "BMW M4 Competition", major(200, "USD"), 4.8, "Sedan", "BMW", 1, "moderate"},
+	{14, "Audi RS3", major(220, "USD"), 4.6, "Sedan", "Audi", 2, "moderate"},
+	{15, "Audi RS5", major(270, "USD"), 4.7, "Sedan", "Audi", 3, "moderate"},
+	{16, "Audi S8", major(300, "USD"), 4.9, "Sedan", "Audi", 1, "moderate"},
+	{17, "BMW 730LI", major(290, "USD"), 4.6, "Sedan", "BMW", 2, "moderate"},
+	{18, "Mercedes EQE 350", major(120, "USD"), 4.5, "Electric", "Mercedes", 3, "flexible"},
+	{19, "Tesla Model 3", major(120, "USD"), 5.0, "Electric", "Tesla", 1, "flexible"},
+	{20, "Porsche 718", major(4718, "USD"), 4.9, "Sports", "Porsche", 2, "strict"},
+	{21, "Porsche 911 Turbo S", major(9000, "USD"), 5.0, "Sports", "Porsche", 3, "strict"},
+	{22, "Ferrari F8 Tributo", major(9999, "USD"), 5.0, "Sports", "Ferrari", 1, "strict"},
+	{23, "Audi R8", major(2000, "USD"), 4.8, "Sports", "Audi", 2, "strict"},
+	{24, "Audi RS6", major(300, "USD"), 4.7, "Sports", "Audi", 3, "moderate"},
+	{25, "Mercedes V250", major(2500, "USD"), 4.6, "Van", "Mercedes", 1, "strict"},
+}
+
+// Автомобиль в результатах поиска с ценой за весь период аренды
+type CarOffer struct {
+	Car
+	Quote      Quote
+	OneWayFee  Money
+	TotalPrice Money
 }
 
 const carsPerPage = 3
@@ -556,37 +677,42 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 // Функция для фильтрации, сортировки и пагинации автомобилей
 func carsHandler(w http.ResponseWriter, r *http.Request) {
-	category := r.URL.Query().Get("category")
-	brand := r.URL.Query().Get("brand")
-	sortBy := r.URL.Query().Get("sort")
+	search := carSearchFromQuery(r.URL.Query())
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	if page == 0 {
 		page = 1
 	}
 
-	// Фильтрация автомобилей
-	filteredCars := []Car{}
-	for _, car := range cars {
-		if (category == "" || car.Category == category) && (brand == "" || car.Brand == brand) {
-			filteredCars = append(filteredCars, car)
-		}
-	}
-
-	// Сортировка автомобилей
-	switch sortBy {
-	case "price":
-		sortCarsByPrice(filteredCars)
-	case "rating":
-		sortCarsByRating(filteredCars)
+	filteredCars, err := searchCarOffers(search, time.Now())
+	switch {
+	case err == nil:
+	case errors.Is(err, errInvalidDateRange):
+		http.Error(w, localize(r, "Некорректный период аренды"), http.StatusBadRequest)
+		return
+	case errors.Is(err, errUnknownCurrency), errors.Is(err, errNoExchangeRate):
+		http.Error(w, localize(r, "Конвертация в эту валюту недоступна"), http.StatusBadRequest)
+		log.Println("Ошибка конвертации валюты:", err)
+		return
+	default:
+		http.Error(w, localize(r, "Ошибка проверки доступности"), http.StatusInternalServerError)
+		log.Println("Ошибка проверки доступности:", err)
+		return
 	}
 
 	// Пагинация
 	startIndex := (page - 1) * carsPerPage
+	if startIndex > len(filteredCars) {
+		startIndex = len(filteredCars)
+	}
 	endIndex := startIndex + carsPerPage
 	if endIndex > len(filteredCars) {
 		endIndex = len(filteredCars)
 	}
 
+	for i := startIndex; i < endIndex; i++ {
+		filteredCars[i].Quote = filteredCars[i].Quote.Localized(r)
+	}
+
 	// Передаем отфильтрованные и отсортированные данные
 	tmpl, err := template.ParseFiles("index.html")
 	if err != nil {
@@ -595,25 +721,130 @@ func carsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	tmpl.Execute(w, struct {
-		Cars        []Car
+		Cars        []CarOffer
 		TotalPages  int
 		CurrentPage int
+		PickupDate  string
+		DropoffDate string
 	}{
 		Cars:        filteredCars[startIndex:endIndex],
 		TotalPages:  (len(filteredCars) + carsPerPage - 1) / carsPerPage, // Общее количество страниц
 		CurrentPage: page,
+		PickupDate:  search.PickupDate,
+		DropoffDate: search.DropoffDate,
 	})
 }
 
-// Функция сортировки автомобилей по цене
-func sortCarsByPrice(cars []Car) {
+// Параметры поиска автомобилей: общие для страницы каталога и API партнёров
+type carSearch struct {
+	Category      string
+	Brand         string
+	SortBy        string
+	PickupDate    string
+	DropoffDate   string
+	PickupBranch  int
+	DropoffBranch int
+	Currency      string
+}
+
+// Функция чтения параметров поиска из строки запроса
+func carSearchFromQuery(q url.Values) carSearch {
+	pickupBranch, _ := strconv.Atoi(q.Get("pickup_branch"))
+	dropoffBranch, _ := strconv.Atoi(q.Get("dropoff_branch"))
+	return carSearch{
+		Category:      q.Get("category"),
+		Brand:         q.Get("brand"),
+		SortBy:        q.Get("sort"),
+		PickupDate:    q.Get("pickup_date"),
+		DropoffDate:   q.Get("dropoff_date"),
+		PickupBranch:  pickupBranch,
+		DropoffBranch: dropoffBranch,
+		Currency:      q.Get("currency"),
+	}
+}
+
+// Функция подбора автомобилей с ценами. Если указан период аренды, занятые автомобили
+// исключаются и считается итоговая стоимость; без дат — цена одних суток с сегодняшнего дня
+func searchCarOffers(s carSearch, now time.Time) ([]CarOffer, error) {
+	start := now.Truncate(24 * time.Hour)
+	end := start.AddDate(0, 0, 1)
+	busy := map[int]bool{}
+	if s.PickupDate != "" || s.DropoffDate != "" {
+		var err error
+		start, end, err = parseDateRange(s.PickupDate, s.DropoffDate)
+		if err != nil {
+			return nil, err
+		}
+		busy, err = busyItems("car", start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Цены показываются в валюте из параметра currency, по умолчанию — в базовой
+	currency := s.Currency
+	if currency == "" {
+		currency = baseCurrency
+	}
+
+	// Фильтрация автомобилей
+	offers := []CarOffer{}
+	catalogMu.RLock()
+	candidates := append([]Car(nil), cars...)
+	catalogMu.RUnlock()
+	for _, car := range candidates {
+		if (s.Category == "" || car.Category == s.Category) && (s.Brand == "" || car.Brand == s.Brand) &&
+			(s.PickupBranch == 0 || car.BranchID == s.PickupBranch) && !busy[car.ID] {
+			offer, err := carOffer(car, start, end, now, busy, s.PickupBranch, s.DropoffBranch, currency)
+			if err != nil {
+				return nil, err
+			}
+			offers = append(offers, offer)
+		}
+	}
+
+	// Сортировка автомобилей
+	switch s.SortBy {
+	case "price":
+		sortCarsByPrice(offers)
+	case "rating":
+		sortCarsByRating(offers)
+	}
+	return offers, nil
+}
+
+// Функция расчёта предложения: цена за период с доплатой за аренду в одну сторону,
+// пересчитанная в валюту отображения
+func carOffer(car Car, start, end, now time.Time, busy map[int]bool, pickupBranch, dropoffBranch int, currency string) (CarOffer, error) {
+	quote := quoteCar(car, start, end, now, busy)
+	fee, err := oneWayFeeFor(pickupBranch, dropoffBranch, car.Price.Currency)
+	if err != nil {
+		return CarOffer{}, err
+	}
+	if fee.Amount != 0 {
+		quote.addLine(fee, "Возврат в другой филиал")
+	}
+
+	quote, err = quote.Convert(currency)
+	if err != nil {
+		return CarOffer{}, err
+	}
+	fee, err = convertMoney(fee, currency)
+	if err != nil {
+		return CarOffer{}, err
+	}
+	return CarOffer{Car: car, Quote: quote, OneWayFee: fee, TotalPrice: quote.Total}, nil
+}
+
+// Функция сортировки автомобилей по цене. Все предложения уже в одной валюте
+func sortCarsByPrice(cars []CarOffer) {
 	sort.SliceStable(cars, func(i, j int) bool {
-		return cars[i].Price < cars[j].Price
+		return cars[i].TotalPrice.Amount < cars[j].TotalPrice.Amount
 	})
 }
 
 // Функция сортировки автомобилей по рейтингу
-func sortCarsByRating(cars []Car) {
+func sortCarsByRating(cars []CarOffer) {
 	sort.SliceStable(cars, func(i, j int) bool {
 		return cars[i].Rating > cars[j].Rating
 	})
@@ -631,20 +862,70 @@ func startServer() {
 	// Регистрация обработчиков маршрутов
 	r.HandleFunc("/", homeHandler).Methods("GET")
 	r.HandleFunc("/cars", carsHandler).Methods("GET")
+	r.HandleFunc("/openapi.json", handleOpenAPI).Methods("GET")
+	r.HandleFunc("/csrf-token", handleCSRFToken).Methods("GET")
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
 	r.Handle("/admin/cars", adminMiddleware(http.HandlerFunc(adminCarsHandler))).Methods("GET", "POST", "PUT", "DELETE")
+	r.Handle("/admin/maintenance", adminMiddleware(http.HandlerFunc(adminMaintenanceHandler))).Methods("POST")
+	r.Handle("/admin/users/unlock", adminMiddleware(http.HandlerFunc(adminUnlockHandler))).Methods("POST")
+	r.Handle("/admin/branches", adminMiddleware(http.HandlerFunc(adminBranchesHandler))).Methods("POST")
+	r.Handle("/admin/bookings/return", adminMiddleware(http.HandlerFunc(adminReturnHandler))).Methods("POST")
+	r.Handle("/admin/bookings/status", adminMiddleware(http.HandlerFunc(adminBookingStatusHandler))).Methods("POST")
+	r.Handle("/admin/rates", adminMiddleware(http.HandlerFunc(adminRatesHandler))).Methods("GET", "POST")
+	r.Handle("/admin/payments/refund", adminMiddleware(withIdempotency(adminRefundHandler))).Methods("POST")
+	r.Handle("/admin/partners", adminMiddleware(http.HandlerFunc(adminPartnersHandler))).Methods("GET", "POST")
+	r.Handle("/admin/api-keys", adminMiddleware(http.HandlerFunc(adminAPIKeysHandler))).Methods("GET", "POST")
+	r.Handle("/admin/api-keys/revoke", adminMiddleware(http.HandlerFunc(adminRevokeAPIKeyHandler))).Methods("POST")
+
+	// API партнёров
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/cars", withAPIKey(apiScopeSearch, apiCarsHandler)).Methods("GET")
+	api.HandleFunc("/rooms", withAPIKey(apiScopeSearch, apiRoomsHandler)).Methods("GET")
+	api.HandleFunc("/branches", withAPIKey(apiScopeSearch, handleBranches)).Methods("GET")
+	api.HandleFunc("/quote", withAPIKey(apiScopeSearch, handleQuote)).Methods("GET")
+	api.HandleFunc("/holds", withAPIKey(apiScopeBook, handleCreateHold)).Methods("POST")
+	api.HandleFunc("/holds/release", withAPIKey(apiScopeBook, handleReleaseHold)).Methods("POST")
+	api.HandleFunc("/holds/checkout", withAPIKey(apiScopeBook, withIdempotency(handleHoldCheckout))).Methods("POST")
+	api.HandleFunc("/bookings", withAPIKey(apiScopeBook, handleMyBookings)).Methods("GET")
+	api.HandleFunc("/bookings/cancel", withAPIKey(apiScopeBook, handleCancelBooking)).Methods("POST")
 
 	// Логирование запуска сервера
 	fmt.Println("Starting server on :8080...")
-	log.Fatal(http.ListenAndServe(":8080", r)) // запуск сервера с маршрутизатором
+	log.Fatal(http.ListenAndServe(":8080", withRequestID(withCORS(cors, withCSRF(r))))) // запуск сервера с маршрутизатором
 }
 
+// Роль администратора в users.role; назначается вручную в базе
+const roleAdmin = "admin"
+
+// Промежуточный обработчик, пропускающий только администраторов, вошедших со вторым фактором
 func adminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRole := r.Header.Get("Role") // Или извлеките роль из сессии/токена
-		if userRole != "admin" {
-			http.Error(w, "Access Denied", http.StatusForbidden)
+		userID, mfa, err := currentSession(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		// Роль берётся из базы, а не из запроса; администратору обязателен второй фактор
+		var role string
+		var totpEnabled bool
+		err = db.QueryRow(`SELECT role, totp_enabled FROM users WHERE id = $1`, userID).Scan(&role, &totpEnabled)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if role != roleAdmin {
+			writeError(w, r, http.StatusForbidden, "Доступ запрещён")
+			return
+		}
+		if !totpEnabled {
+			writeErrorCode(w, r, http.StatusForbidden, "mfa_enrollment_required", "Для доступа к панели администратора включите двухфакторную аутентификацию")
+			return
+		}
+		if !mfa {
+			writeErrorCode(w, r, http.StatusForbidden, "mfa_required", "Войдите заново с кодом двухфакторной аутентификации")
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -655,18 +936,25 @@ func adminCarsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		var newCar Car
-		err := json.NewDecoder(r.Body).Decode(&newCar)
-		if err != nil {
-			http.Error(w, "Invalid input", http.StatusBadRequest)
+		if !decodeAndValidate(w, r, &newCar) {
 			return
 		}
+		if newCar.Policy == "" {
+			newCar.Policy = "flexible"
+		}
+		catalogMu.Lock()
+		if newCar.BranchID == 0 {
+			newCar.BranchID = branches[0].ID
+		}
+		newCar.ID = len(cars) + 1
 		cars = append(cars, newCar)
-		w.WriteHeader(http.StatusCreated)
+		catalogMu.Unlock()
+		writeSuccess(w, r, http.StatusCreated, "Автомобиль добавлен", newCar)
 	case "PUT":
 		// Логика для обновления автомобиля
 	case "DELETE":
 		// Логика для удаления автомобиля
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r)
 	}
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	errItemUnavailable   = errors.New("объект занят на выбранные даты")
+	errPaymentRequired   = errors.New("для доплаты нужен токен оплаты")
+	errItemNotFound      = errors.New("объект не найден")
+	errWrongPickupPoint  = errors.New("автомобиль находится в другом филиале")
+	errPaymentInProgress = errors.New("по бронированию уже авторизован платёж")
+)
+
+// Запрос на изменение бронирования. Пустые поля оставляют прежние значения
+type ModifyBookingRequest struct {
+	BookingID    int    `json:"booking_id" validate:"required"`
+	ItemID       int    `json:"item_id"`
+	StartDate    string `json:"start_date" validate:"date"`
+	EndDate      string `json:"end_date" validate:"date"`
+	PaymentToken string `json:"payment_token" validate:"max=255"` // Нужен, если новая цена выше оплаченной
+}
+
+// Результат изменения бронирования
+type ModifyBookingResult struct {
+	Quote           Quote  `json:"quote"`
+	PackageDiscount Money  `json:"package_discount"` // Скидка пакета поездки, уже вычтенная из total
+	Total           Money  `json:"total"`
+	Difference      Money  `json:"difference"`              // Положительная — доплата, отрицательная — возврат
+	RefundStatus    string `json:"refund_status,omitempty"` // Выполнен ли возврат отрицательной разницы
+}
+
+// Функция расчёта цены бронирования в валюте бронирования. Бронирование
+// exceptBookingID (то, которое меняется) не учитывается в загрузке
+func priceBooking(itemType string, itemID int, start, end time.Time, pickupBranch, dropoffBranch int, currency string, exceptBookingID int) (Quote, error) {
+	busy, err := busyItemsExcept(itemType, start, end, exceptBookingID)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	switch itemType {
+	case "car":
+		car, ok := findCar(itemID)
+		if !ok {
+			return Quote{}, errItemNotFound
+		}
+		offer, err := carOffer(car, start, end, time.Now(), busy, pickupBranch, dropoffBranch, currency)
+		return offer.Quote, err
+	case "room":
+		room, ok := findRoom(itemID)
+		if !ok {
+			return Quote{}, errItemNotFound
+		}
+		return quoteRoom(room, start, end, time.Now(), busy).Convert(currency)
+	}
+	return Quote{}, errItemNotFound
+}
+
+// Функция изменения дат или объекта бронирования. Все изменения выполняются в одной
+// транзакции под блокировкой старого и нового объекта: прежний слот освобождается
+// только при фиксации, то есть после того, как новый слот проверен и доплата списана
+func modifyBooking(userID int, req ModifyBookingRequest, idempotencyKey string, now time.Time) (ModifyBookingResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return ModifyBookingResult{}, err
+	}
+	defer tx.Rollback()
+
+	var itemType, status, currency string
+	var itemID int
+	var oldStart, oldEnd time.Time
+	var oldTotal, oldDiscount int64
+	var pickupBranch, dropoffBranch, tripID sql.NullInt64
+	var expired bool
+	err = tx.QueryRow(`SELECT item_type, item_id, start_date, end_date, status, total_price, currency, pickup_branch_id, dropoff_branch_id, package_discount, trip_id,
+			COALESCE(expires_at <= NOW(), false)
+		FROM bookings WHERE id = $1 AND user_id = $2 FOR UPDATE`, req.BookingID, userID).
+		Scan(&itemType, &itemID, &oldStart, &oldEnd, &status, &oldTotal, &currency, &pickupBranch, &dropoffBranch, &oldDiscount, &tripID, &expired)
+	if err == sql.ErrNoRows {
+		return ModifyBookingResult{}, errBookingNotFound
+	}
+	if err != nil {
+		return ModifyBookingResult{}, err
+	}
+	if (status != bookingPending && status != bookingConfirmed) || !now.Before(oldStart) || (status == bookingPending && expired) {
+		return ModifyBookingResult{}, fmt.Errorf("%w: бронирование нельзя изменить", errInvalidTransition)
+	}
+	// Авторизованная сумма рассчитана по прежней цене: менять бронирование
+	// можно после списания, тогда разница доплачивается или возвращается
+	if status == bookingPending {
+		var authorized bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM payments WHERE booking_id = $1 AND status = $2)`,
+			req.BookingID, paymentAuthorized).Scan(&authorized)
+		if err != nil {
+			return ModifyBookingResult{}, err
+		}
+		if authorized {
+			return ModifyBookingResult{}, errPaymentInProgress
+		}
+	}
+
+	// Новые значения; незаполненные поля остаются прежними
+	newItemID := itemID
+	if req.ItemID != 0 {
+		newItemID = req.ItemID
+	}
+	newStart, newEnd := oldStart, oldEnd
+	if req.StartDate != "" || req.EndDate != "" {
+		newStart, newEnd, err = parseDateRange(req.StartDate, req.EndDate)
+		if err != nil {
+			return ModifyBookingResult{}, err
+		}
+	}
+	if !now.Before(newStart) {
+		return ModifyBookingResult{}, errInvalidDateRange
+	}
+	if itemType == "car" && newItemID != itemID {
+		car, ok := findCar(newItemID)
+		if !ok {
+			return ModifyBookingResult{}, errItemNotFound
+		}
+		if int64(car.BranchID) != pickupBranch.Int64 {
+			return ModifyBookingResult{}, errWrongPickupPoint
+		}
+	}
+
+	// Блокировки берутся по возрастанию идентификатора, чтобы встречные замены не зависли
+	first, second := itemID, newItemID
+	if first > second {
+		first, second = second, first
+	}
+	if err := lockItem(tx, itemType, first); err != nil {
+		return ModifyBookingResult{}, err
+	}
+	if second != first {
+		if err := lockItem(tx, itemType, second); err != nil {
+			return ModifyBookingResult{}, err
+		}
+	}
+
+	available, err := itemAvailableFor(tx, itemType, newItemID, newStart, newEnd, userID, req.BookingID)
+	if err != nil {
+		return ModifyBookingResult{}, err
+	}
+	if !available {
+		return ModifyBookingResult{}, errItemUnavailable
+	}
+
+	quote, err := priceBooking(itemType, newItemID, newStart, newEnd, int(pickupBranch.Int64), int(dropoffBranch.Int64), currency, req.BookingID)
+	if err != nil {
+		return ModifyBookingResult{}, err
+	}
+
+	// Оплаченное со скидкой пакета бронирование сохраняет скидку, пока с новыми
+	// датами поездка по-прежнему отвечает условию пакета. У неоплаченных
+	// бронирований скидка считается только при оплате поездки
+	discount := Money{Currency: currency}
+	if oldDiscount > 0 && tripID.Valid {
+		items, err := loadTripItems(tx, int(tripID.Int64))
+		if err != nil {
+			return ModifyBookingResult{}, err
+		}
+		for i := range items {
+			if items[i].ID == req.BookingID {
+				items[i].ItemID = newItemID
+				items[i].StartDate = newStart.Format(dateLayout)
+				items[i].EndDate = newEnd.Format(dateLayout)
+			}
+		}
+		if qualifiesForPackage(items) {
+			discount = quote.Total.Percent(packageDiscountPercent)
+		}
+	}
+	total := Money{quote.Total.Amount - discount.Amount, currency}
+	result := ModifyBookingResult{
+		Quote:           quote,
+		PackageDiscount: discount,
+		Total:           total,
+		Difference:      Money{total.Amount - oldTotal, currency},
+	}
+
+	_, err = tx.Exec(`UPDATE bookings SET item_id = $1, start_date = $2, end_date = $3, total_price = $4, package_discount = $5, updated_at = NOW() WHERE id = $6`,
+		newItemID, newStart, newEnd, total.Amount, discount.Amount, req.BookingID)
+	if err != nil {
+		return ModifyBookingResult{}, err
+	}
+
+	// Неоплаченное бронирование просто получает новую цену
+	if status == bookingPending {
+		if err := tx.Commit(); err != nil {
+			return ModifyBookingResult{}, err
+		}
+		notifyWaitlist(itemType, itemID)
+		return result, nil
+	}
+
+	var charged *PaymentResult
+	if result.Difference.Amount > 0 {
+		if req.PaymentToken == "" {
+			return ModifyBookingResult{}, errPaymentRequired
+		}
+		auth, err := paymentProvider.Authorize(AuthorizeRequest{
+			Amount:         result.Difference,
+			PaymentToken:   req.PaymentToken,
+			IdempotencyKey: providerIdempotencyKey(userID, "/bookings/modify", req.BookingID, idempotencyKey),
+			Description:    fmt.Sprintf("Доплата по бронированию #%d", req.BookingID),
+		})
+		if err != nil {
+			return ModifyBookingResult{}, err
+		}
+		captured, err := paymentProvider.Capture(auth.ProviderRef, result.Difference)
+		if err != nil {
+			return ModifyBookingResult{}, err
+		}
+		charged = &captured
+
+		_, err = tx.Exec(`INSERT INTO payments (booking_id, provider, provider_ref, status, amount, currency) VALUES ($1, $2, $3, $4, $5, $6)`,
+			req.BookingID, paymentProvider.Name(), captured.ProviderRef, paymentCaptured, result.Difference.Amount, currency)
+		if err != nil {
+			if _, rerr := paymentProvider.Refund(captured.ProviderRef, result.Difference); rerr != nil {
+				log.Printf("Не удалось вернуть доплату %s по бронированию #%d: %v", captured.ProviderRef, req.BookingID, rerr)
+			}
+			return ModifyBookingResult{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		// Доплата списана, но изменения не сохранены — возвращаем её
+		if charged != nil {
+			if _, rerr := paymentProvider.Refund(charged.ProviderRef, result.Difference); rerr != nil {
+				log.Printf("Не удалось вернуть доплату %s по бронированию #%d: %v", charged.ProviderRef, req.BookingID, rerr)
+			}
+		}
+		return ModifyBookingResult{}, err
+	}
+
+	// Прежний слот освободился
+	notifyWaitlist(itemType, itemID)
+
+	// Новая цена ниже оплаченной — разница возвращается
+	if result.Difference.Amount < 0 {
+		refund := Money{-result.Difference.Amount, currency}
+		result.RefundStatus = refundCompleted
+		if err := refundBooking(req.BookingID, refund); err != nil {
+			log.Printf("Возврат %s по бронированию #%d не выполнен: %v", refund, req.BookingID, err)
+			result.RefundStatus = refundFailed
+		}
+	}
+	return result, nil
+}
+
+// Обработчик изменения бронирования
+func handleModifyBooking(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req ModifyBookingRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		result, err := modifyBooking(userID, req, r.Header.Get(idempotencyHeader), time.Now())
+		switch {
+		case err == nil:
+		case errors.Is(err, errBookingNotFound), errors.Is(err, errItemNotFound):
+			writeError(w, r, http.StatusNotFound, "Бронирование или объект не найдены")
+			return
+		case errors.Is(err, errInvalidDateRange):
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		case errors.Is(err, errInvalidTransition):
+			writeErrorCode(w, r, http.StatusConflict, "booking_not_modifiable", "Это бронирование нельзя изменить")
+			return
+		case errors.Is(err, errItemUnavailable):
+			writeErrorCode(w, r, http.StatusConflict, "item_unavailable", "Объект занят на выбранные даты")
+			return
+		case errors.Is(err, errWrongPickupPoint):
+			writeErrorCode(w, r, http.StatusConflict, "wrong_branch", "Автомобиль недоступен в филиале получения")
+			return
+		case errors.Is(err, errPaymentInProgress):
+			writeErrorCode(w, r, http.StatusConflict, "payment_in_progress", "Оплата уже заблокирована: измените бронирование после списания")
+			return
+		case errors.Is(err, errPaymentRequired), errors.Is(err, errPaymentDeclined):
+			writeErrorCode(w, r, http.StatusPaymentRequired, "payment_declined", "Не удалось списать доплату")
+			return
+		default:
+			log.Printf("Ошибка изменения бронирования: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		result.Quote = result.Quote.Localized(r)
+		message := "Бронирование изменено"
+		if result.RefundStatus == refundFailed {
+			message = "Бронирование изменено, но вернуть разницу не удалось. Мы вернём её вручную"
+		}
+		writeSuccess(w, r, http.StatusOK, message, map[string]interface{}{
+			"result": result,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Денежная сумма в минимальных единицах валюты (центы, тиыны, копейки)
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"` // Код ISO 4217
+}
+
+// Количество знаков после запятой для поддерживаемых валют
+var currencyExponents = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"KZT": 2,
+	"RUB": 2,
+}
+
+// Валюта, в которой задаются общие тарифы (например, доплата за аренду в одну сторону)
+const baseCurrency = "USD"
+
+var (
+	errUnknownCurrency  = errors.New("неизвестная валюта")
+	errCurrencyMismatch = errors.New("суммы в разных валютах")
+	errNoExchangeRate   = errors.New("нет курса для валюты")
+)
+
+// Функция создания суммы из целого числа основных единиц: major(50, "USD") — 50.00 USD
+func major(units int64, currency string) Money {
+	return Money{Amount: units * pow10(currencyExponents[currency]), Currency: currency}
+}
+
+func pow10(n int) int64 {
+	v := int64(1)
+	for i := 0; i < n; i++ {
+		v *= 10
+	}
+	return v
+}
+
+// Сложение сумм в одной валюте
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, errCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Умножение на целое число
+func (m Money) Mul(n int) Money {
+	return Money{Amount: m.Amount * int64(n), Currency: m.Currency}
+}
+
+// Процент от суммы с округлением до минимальной единицы
+func (m Money) Percent(percent int) Money {
+	return Money{Amount: roundRat(new(big.Rat).SetFrac64(m.Amount*int64(percent), 100)), Currency: m.Currency}
+}
+
+// Форматирование суммы: 1234.50 USD
+func (m Money) String() string {
+	exp := currencyExponents[m.Currency]
+	if exp == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	div := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/div, exp, amount%div, m.Currency)
+}
+
+// Правило округления во всех расчётах: до ближайшей минимальной единицы,
+// половина округляется от нуля (2.5 → 3, -2.5 → -3)
+func roundRat(r *big.Rat) int64 {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	neg := num.Sign() < 0
+	num.Abs(num)
+
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+// Таблица курсов: сколько единиц валюты стоит одна единица базовой валюты
+type ExchangeRates struct {
+	Base  string              `json:"base"`
+	Rates map[string]*big.Rat `json:"-"`
+}
+
+var (
+	exchangeRatesMu sync.RWMutex
+	exchangeRates   = &ExchangeRates{Base: baseCurrency, Rates: map[string]*big.Rat{baseCurrency: big.NewRat(1, 1)}}
+)
+
+// Файл с курсами валют, который читается при запуске и перезаписывается при загрузке из админки
+var exchangeRatesFile = envString("EXCHANGE_RATES_FILE", "rates.json")
+
+// Функция разбора курсов из JSON вида {"base":"USD","rates":{"KZT":"480.50","RUB":92.3}}.
+// Курсы разбираются как десятичные дроби, без потери точности на float64
+func parseExchangeRates(data []byte) (*ExchangeRates, error) {
+	var raw struct {
+		Base  string                 `json:"base"`
+		Rates map[string]json.Number `json:"rates"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if _, ok := currencyExponents[raw.Base]; !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownCurrency, raw.Base)
+	}
+
+	rates := &ExchangeRates{Base: raw.Base, Rates: map[string]*big.Rat{raw.Base: big.NewRat(1, 1)}}
+	for code, value := range raw.Rates {
+		if _, ok := currencyExponents[code]; !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknownCurrency, code)
+		}
+		rate, ok := new(big.Rat).SetString(value.String())
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("некорректный курс %s: %s", code, value)
+		}
+		rates.Rates[code] = rate
+	}
+	return rates, nil
+}
+
+// Функция загрузки курсов из файла. Отсутствие файла не ошибка: доступна только базовая валюта
+func loadExchangeRates(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Файл курсов %s не найден, конвертация валют недоступна", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rates, err := parseExchangeRates(data)
+	if err != nil {
+		return err
+	}
+
+	exchangeRatesMu.Lock()
+	exchangeRates = rates
+	exchangeRatesMu.Unlock()
+	return nil
+}
+
+// Пересчёт суммы в другую валюту с округлением до минимальной единицы
+func (rates *ExchangeRates) Convert(m Money, currency string) (Money, error) {
+	if m.Currency == currency {
+		return m, nil
+	}
+	toExp, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", errUnknownCurrency, currency)
+	}
+	from, ok := rates.Rates[m.Currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", errNoExchangeRate, m.Currency)
+	}
+	to, ok := rates.Rates[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", errNoExchangeRate, currency)
+	}
+
+	// amount * (to / from) с поправкой на разное число знаков после запятой
+	v := new(big.Rat).SetInt64(m.Amount)
+	v.Mul(v, to)
+	v.Quo(v, from)
+	v.Mul(v, new(big.Rat).SetFrac64(pow10(toExp), pow10(currencyExponents[m.Currency])))
+	return Money{Amount: roundRat(v), Currency: currency}, nil
+}
+
+// Пересчёт по текущей таблице курсов
+func convertMoney(m Money, currency string) (Money, error) {
+	exchangeRatesMu.RLock()
+	defer exchangeRatesMu.RUnlock()
+	return exchangeRates.Convert(m, currency)
+}
+
+// Обработчик загрузки таблицы курсов администратором
+func adminRatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		r.ParseMultipartForm(1 << 20)
+
+		// Курсы принимаются файлом из формы (поле "rates") или JSON в теле запроса
+		var data []byte
+		var err error
+		if file, _, ferr := r.FormFile("rates"); ferr == nil {
+			defer file.Close()
+			data, err = io.ReadAll(io.LimitReader(file, 1<<20))
+		} else {
+			data, err = io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Не удалось прочитать файл курсов")
+			return
+		}
+
+		rates, err := parseExchangeRates(data)
+		if err != nil {
+			log.Println("Некорректная таблица курсов:", err)
+			writeError(w, r, http.StatusBadRequest, "Некорректная таблица курсов")
+			return
+		}
+
+		if err := os.WriteFile(exchangeRatesFile, data, 0644); err != nil {
+			log.Println("Ошибка сохранения файла курсов:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения файла курсов")
+			return
+		}
+
+		exchangeRatesMu.Lock()
+		exchangeRates = rates
+		exchangeRatesMu.Unlock()
+
+		writeSuccess(w, r, http.StatusOK, "Курсы валют обновлены", nil)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		exchangeRatesMu.RLock()
+		defer exchangeRatesMu.RUnlock()
+		out := map[string]string{}
+		for code, rate := range exchangeRates.Rates {
+			out[code] = rate.FloatString(6)
+		}
+		writeSuccess(w, r, http.StatusOK, "", map[string]interface{}{
+			"base":  exchangeRates.Base,
+			"rates": out,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест округления процентов: половина округляется от нуля
+func TestMoneyPercentRounding(t *testing.T) {
+	assert.Equal(t, Money{3, "USD"}, Money{25, "USD"}.Percent(10))
+	assert.Equal(t, Money{-3, "USD"}, Money{25, "USD"}.Percent(-10))
+	assert.Equal(t, Money{2, "USD"}, Money{24, "USD"}.Percent(10))
+	assert.Equal(t, "1234.05 KZT", Money{123405, "KZT"}.String())
+	assert.Equal(t, "-0.50 USD", Money{-50, "USD"}.String())
+}
+
+// Тест разбора таблицы курсов и конвертации
+func TestExchangeRatesConvert(t *testing.T) {
+	rates, err := parseExchangeRates([]byte(`{"base":"USD","rates":{"KZT":"480.55","RUB":92.3}}`))
+	assert.NoError(t, err)
+
+	kzt, err := rates.Convert(major(10, "USD"), "KZT")
+	assert.NoError(t, err)
+	assert.Equal(t, Money{480550, "KZT"}, kzt)
+
+	// Кросс-курс через базовую валюту: 1000 KZT * 92.3 / 480.55 = 192.07 RUB
+	rub, err := rates.Convert(major(1000, "KZT"), "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, Money{19207, "RUB"}, rub)
+
+	_, err = rates.Convert(major(1, "USD"), "EUR")
+	assert.ErrorIs(t, err, errNoExchangeRate)
+
+	_, err = parseExchangeRates([]byte(`{"base":"USD","rates":{"XYZ":"1"}}`))
+	assert.ErrorIs(t, err, errUnknownCurrency)
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Вход через внешние OpenID Connect провайдеры (authorization code + PKCE).
+// Провайдеры задаются переменными окружения: OIDC_PROVIDERS=google,local и для
+// каждого OIDC_<ИМЯ>_ISSUER, OIDC_<ИМЯ>_CLIENT_ID, OIDC_<ИМЯ>_CLIENT_SECRET,
+// необязательные OIDC_<ИМЯ>_TITLE и OIDC_<ИМЯ>_SCOPES. Адрес возврата у всех
+// провайдеров общий: APP_BASE_URL/oidc/callback
+const (
+	oidcLoginLifetime = 10 * time.Minute
+	oidcStateCookie   = "oidc_state"
+	oidcCacheLifetime = time.Hour
+	oidcClockSkew     = 2 * time.Minute
+)
+
+var (
+	errOIDCInvalidToken    = errors.New("некорректный ID token")
+	errOIDCEmailUnverified = errors.New("провайдер не подтвердил email")
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var oidcProviders = loadOIDCProviders()
+
+// Настройки провайдера и кэш его метаданных и ключей подписи
+type oidcProvider struct {
+	Name         string
+	Title        string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	metaFetched time.Time
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// Документ .well-known/openid-configuration
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Данные пользователя из ID token (и userinfo, если email в токене нет)
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expiry        int64           `json:"exp"`
+	IssuedAt      int64           `json:"iat"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified interface{}     `json:"email_verified"`
+	Name          string          `json:"name"`
+	GivenName     string          `json:"given_name"`
+	FamilyName    string          `json:"family_name"`
+	Locale        string          `json:"locale"`
+}
+
+// Провайдер для списка на странице входа
+type OIDCProviderInfo struct {
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	LoginURL string `json:"login_url"`
+}
+
+// Функция чтения списка провайдеров из окружения
+func loadOIDCProviders() map[string]*oidcProvider {
+	providers := map[string]*oidcProvider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &oidcProvider{
+			Name:         name,
+			Title:        envString(prefix+"TITLE", name),
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(envString(prefix+"SCOPES", "openid email profile")),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			log.Printf("Провайдер OIDC %s пропущен: не заданы %sISSUER или %sCLIENT_ID", name, prefix, prefix)
+			continue
+		}
+		providers[name] = p
+	}
+	return providers
+}
+
+// Адрес, на который провайдер возвращает пользователя
+func oidcRedirectURI() string {
+	return appBaseURL + "/oidc/callback"
+}
+
+// Функция получения метаданных провайдера с кэшированием
+func (p *oidcProvider) metadata() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil && time.Since(p.metaFetched) < oidcCacheLifetime {
+		return p.meta, nil
+	}
+	var meta oidcMetadata
+	if err := oidcGetJSON(p.Issuer+"/.well-known/openid-configuration", "", &meta); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("issuer в метаданных %q не совпадает с %q", meta.Issuer, p.Issuer)
+	}
+	p.meta, p.metaFetched = &meta, time.Now()
+	return p.meta, nil
+}
+
+// Функция получения ключа подписи по kid. Набор ключей перечитывается,
+// если ключ не найден (провайдер сменил ключи), но не чаще раза в минуту
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok && time.Since(p.keysFetched) < oidcCacheLifetime {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) > time.Minute {
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		if err := oidcGetJSON(meta.JWKSURI, "", &set); err != nil {
+			return nil, err
+		}
+		p.keys = map[string]crypto.PublicKey{}
+		for _, k := range set.Keys {
+			if key, err := k.publicKey(); err == nil {
+				p.keys[k.Kid] = key
+			}
+		}
+		p.keysFetched = time.Now()
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: неизвестный ключ %q", errOIDCInvalidToken, kid)
+}
+
+// Открытый ключ в формате JWK (RFC 7517): RSA или EC P-256
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, errors.New("ключ не для подписи")
+	}
+	decode := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	switch {
+	case k.Kty == "RSA" && k.N != "" && k.E != "":
+		return &rsa.PublicKey{N: decode(k.N), E: int(decode(k.E).Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(k.X), Y: decode(k.Y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("точка не на кривой")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый ключ %s", k.Kty)
+}
+
+// Функция GET-запроса к провайдеру с разбором JSON
+func oidcGetJSON(endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s ответил %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Функция вычисления code_challenge для PKCE (метод S256)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Функция формирования адреса авторизации у провайдера
+func (p *oidcProvider) authURL(state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", oidcRedirectURI())
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Функция обмена кода авторизации на токены и проверки ID token
+func (p *oidcProvider) exchange(code, verifier, nonce string) (oidcClaims, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return oidcClaims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcRedirectURI())
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return oidcClaims{}, fmt.Errorf("обмен кода: провайдер ответил %d", resp.StatusCode)
+	}
+	var tokens struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return oidcClaims{}, err
+	}
+
+	claims, err := p.verifyIDToken(tokens.IDToken, nonce, time.Now())
+	if err != nil {
+		return oidcClaims{}, err
+	}
+
+	// Некоторые провайдеры кладут email только в userinfo
+	if claims.Email == "" && meta.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		var info oidcClaims
+		if err := oidcGetJSON(meta.UserinfoEndpoint, tokens.AccessToken, &info); err != nil {
+			return oidcClaims{}, err
+		}
+		if info.Subject != claims.Subject {
+			return oidcClaims{}, fmt.Errorf("%w: sub в userinfo не совпадает", errOIDCInvalidToken)
+		}
+		claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+		if claims.GivenName == "" && claims.FamilyName == "" {
+			claims.Name, claims.GivenName, claims.FamilyName = info.Name, info.GivenName, info.FamilyName
+		}
+	}
+	return claims, nil
+}
+
+// Функция проверки подписи и содержимого ID token (RS256 или ES256)
+func (p *oidcProvider) verifyIDToken(raw, nonce string, now time.Time) (oidcClaims, error) {
+	var claims oidcClaims
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return claims, errOIDCInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, errOIDCInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errOIDCInvalidToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return claims, fmt.Errorf("%w: подпись", errOIDCInvalidToken)
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return claims, fmt.Errorf("%w: подпись", errOIDCInvalidToken)
+		}
+	default:
+		return claims, errOIDCInvalidToken
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, errOIDCInvalidToken
+	}
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.Issuer:
+		return claims, fmt.Errorf("%w: iss", errOIDCInvalidToken)
+	case !audienceContains(claims.Audience, p.ClientID):
+		return claims, fmt.Errorf("%w: aud", errOIDCInvalidToken)
+	case now.After(time.Unix(claims.Expiry, 0).Add(oidcClockSkew)):
+		return claims, fmt.Errorf("%w: истёк", errOIDCInvalidToken)
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)):
+		return claims, fmt.Errorf("%w: iat в будущем", errOIDCInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return claims, fmt.Errorf("%w: nonce", errOIDCInvalidToken)
+	case claims.Subject == "":
+		return claims, fmt.Errorf("%w: sub", errOIDCInvalidToken)
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// aud может быть строкой или массивом строк
+func audienceContains(aud json.RawMessage, clientID string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == clientID
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) == nil {
+		for _, a := range many {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// email_verified бывает булевым значением или строкой "true"
+func (c oidcClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Функция поиска или создания пользователя по данным провайдера. Учётная запись
+// провайдера привязывается к аккаунту с тем же подтверждённым email; если такого
+// нет, создаётся подтверждённый аккаунт со случайным паролем (его можно задать
+// через восстановление пароля). Неподтверждённый аккаунт с этим email
+// подтверждается со сменой пароля на случайный
+func linkOIDCUser(provider string, claims oidcClaims, locale string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, claims.Subject).Scan(&userID)
+	if err == nil {
+		return userID, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	email := normalizeEmail(claims.Email)
+	if email == "" || !claims.emailVerified() {
+		return 0, errOIDCEmailUnverified
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+	password, err := randomToken()
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow(`INSERT INTO users (first_name, last_name, email, password, is_confirmed, locale)
+		VALUES ($1, $2, $3, $4, TRUE, NULLIF($5, ''))
+		ON CONFLICT ((lower(email))) DO NOTHING RETURNING id`,
+		firstName, lastName, email, password, locale).Scan(&userID)
+	if err == sql.ErrNoRows {
+		// Аккаунт уже есть: провайдер подтвердил владение адресом
+		var confirmed bool
+		err = tx.QueryRow(`SELECT id, is_confirmed FROM users WHERE lower(email) = $1 FOR UPDATE`, email).Scan(&userID, &confirmed)
+		if err == nil && !confirmed {
+			err = claimUnconfirmedUser(tx, userID, password)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`,
+		provider, claims.Subject, userID, email); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// Функция подтверждения аккаунта, который зарегистрировали на этот email, но
+// так и не подтвердили. Пароль мог задать кто угодно, поэтому он заменяется
+// случайным, а открытые сессии закрываются
+func claimUnconfirmedUser(tx *sql.Tx, userID int, password string) error {
+	if _, err := tx.Exec(`UPDATE users SET is_confirmed = TRUE, password = $1, confirmation_token = NULL WHERE id = $2`,
+		password, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
+
+// Обработчик списка настроенных провайдеров для страницы входа
+func handleOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		list := []OIDCProviderInfo{}
+		for _, p := range oidcProviders {
+			list = append(list, OIDCProviderInfo{Name: p.Name, Title: p.Title, LoginURL: "/oidc/login?provider=" + url.QueryEscape(p.Name)})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Title < list[j].Title })
+		writeSuccess(w, r, http.StatusOK, "", list)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик начала входа через провайдера: сохраняет state, nonce и
+// code_verifier и перенаправляет пользователя к провайдеру. state дублируется
+// в cookie, чтобы чужая ссылка с кодом не привела к входу в чужой аккаунт
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		p, ok := oidcProviders[r.URL.Query().Get("provider")]
+		if !ok {
+			writeErrorCode(w, r, http.StatusNotFound, "unknown_provider", "Неизвестный провайдер входа")
+			return
+		}
+
+		var values [3]string
+		for i := range values {
+			token, err := randomToken()
+			if err != nil {
+				log.Printf("Ошибка генерации токена: %v", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
+				return
+			}
+			values[i] = token
+		}
+		state, nonce, verifier := values[0], values[1], values[2]
+
+		target, err := p.authURL(state, nonce, verifier)
+		if err != nil {
+			log.Printf("Ошибка получения метаданных OIDC %s: %v", p.Name, err)
+			writeError(w, r, http.StatusBadGateway, "Вход через внешний сервис не удался, попробуйте ещё раз")
+			return
+		}
+		// Незавершённые входы старше срока действия больше не нужны
+		if _, err := db.Exec(`DELETE FROM oidc_logins WHERE expires_at < NOW()`); err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+		}
+		_, err = db.Exec(`INSERT INTO oidc_logins (state_hash, provider, code_verifier, nonce, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+			hashToken(state), p.Name, verifier, nonce, time.Now().Add(oidcLoginLifetime))
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     "/oidc/",
+			MaxAge:   int(oidcLoginLifetime.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик возврата от провайдера: обмен кода, проверка ID token, вход
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		state := q.Get("state")
+		cookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			writeErrorCode(w, r, http.StatusBadRequest, "token_invalid", "Ссылка недействительна или устарела")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/oidc/", MaxAge: -1, HttpOnly: true})
+
+		var providerName, verifier, nonce string
+		err = db.QueryRow(`DELETE FROM oidc_logins WHERE state_hash = $1 AND expires_at > NOW()
+			RETURNING provider, code_verifier, nonce`, hashToken(state)).Scan(&providerName, &verifier, &nonce)
+		if err == sql.ErrNoRows {
+			writeErrorCode(w, r, http.StatusBadRequest, "token_invalid", "Ссылка недействительна или устарела")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		p, ok := oidcProviders[providerName]
+		if !ok {
+			writeErrorCode(w, r, http.StatusNotFound, "unknown_provider", "Неизвестный провайдер входа")
+			return
+		}
+		if errCode := q.Get("error"); errCode != "" || q.Get("code") == "" {
+			log.Printf("Провайдер OIDC %s вернул ошибку %q", p.Name, errCode)
+			writeErrorCode(w, r, http.StatusBadRequest, "oidc_failed", "Вход через внешний сервис не удался, попробуйте ещё раз")
+			return
+		}
+
+		claims, err := p.exchange(q.Get("code"), verifier, nonce)
+		if err != nil {
+			log.Printf("Ошибка входа через OIDC %s: %v", p.Name, err)
+			writeErrorCode(w, r, http.StatusBadGateway, "oidc_failed", "Вход через внешний сервис не удался, попробуйте ещё раз")
+			return
+		}
+
+		userID, err := linkOIDCUser(p.Name, claims, requestLocale(r))
+		if errors.Is(err, errOIDCEmailUnverified) {
+			writeErrorCode(w, r, http.StatusForbidden, "email_not_verified", "Провайдер не подтвердил email, войдите другим способом")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка привязки аккаунта OIDC %s: %v", p.Name, err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		// Включённый второй фактор проверяется и при входе через провайдера
+		var totpEnabled bool
+		var locale string
+		err = db.QueryRow(`SELECT totp_enabled, COALESCE(locale, '') FROM users WHERE id = $1`, userID).Scan(&totpEnabled, &locale)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if totpEnabled {
+			token, err := createMFAChallenge(userID)
+			if err != nil {
+				log.Printf("Ошибка создания входа с кодом: %v", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+				return
+			}
+			http.Redirect(w, r, "/login.html#mfa_token="+token, http.StatusFound)
+			return
+		}
+
+		if err := startSession(w, userID, false); err != nil {
+			log.Printf("Ошибка создания сессии: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if isSupportedLocale(locale) {
+			setLocaleCookie(w, locale)
+		}
+		http.Redirect(w, r, "/profile.html", http.StatusFound)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
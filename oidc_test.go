@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Локальный OIDC-провайдер для тестов: discovery, JWKS и token endpoint,
+// проверяющий code_verifier по сохранённому code_challenge
+type mockOIDCServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа: %v", err)
+	}
+	m := &mockOIDCServer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || pkceChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(t, m.claims), "access_token": "at", "token_type": "Bearer"})
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+// Функция подписи JWT ключом тестового провайдера (RS256)
+func (m *mockOIDCServer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Ошибка подписи: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (m *mockOIDCServer) baseClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss": m.URL, "aud": "bookeasy", "sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+		"nonce": "n-1", "email": "Ivan@Example.com", "email_verified": true,
+		"given_name": "Ivan", "family_name": "Petrov",
+	}
+}
+
+// Тест: полный обмен кода с PKCE против локального провайдера
+func TestOIDCExchangeWithPKCE(t *testing.T) {
+	server := newMockOIDCServer(t)
+	defer server.Close()
+	p := &oidcProvider{Name: "local", Issuer: server.URL, ClientID: "bookeasy", Scopes: []string{"openid", "email"}}
+
+	target, err := p.authURL("st-1", "n-1", "verifier-verifier-verifier-verifier-verifier")
+	assert.NoError(t, err)
+	u, _ := url.Parse(target)
+	assert.Equal(t, server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "st-1", u.Query().Get("state"))
+	server.challenge = u.Query().Get("code_challenge")
+	server.claims = server.baseClaims()
+
+	claims, err := p.exchange("good-code", "verifier-verifier-verifier-verifier-verifier", "n-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, "Ivan@Example.com", claims.Email)
+	assert.True(t, claims.emailVerified())
+
+	_, err = p.exchange("good-code", "another-verifier", "n-1")
+	assert.Error(t, err, "чужой code_verifier")
+}
+
+// Тест: ID token с неверными nonce, aud, сроком или подписью отклоняется
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	server := newMockOIDCServer(t)
+	defer server.Close()
+	p := &oidcProvider{Name: "local", Issuer: server.URL, ClientID: "bookeasy"}
+	now := time.Now()
+
+	valid := server.sign(t, server.baseClaims())
+	_, err := p.verifyIDToken(valid, "n-1", now)
+	assert.NoError(t, err)
+
+	_, err = p.verifyIDToken(valid, "other-nonce", now)
+	assert.ErrorIs(t, err, errOIDCInvalidToken)
+
+	_, err = p.verifyIDToken(valid, "n-1", now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, errOIDCInvalidToken, "истёкший токен")
+
+	claims := server.baseClaims()
+	claims["aud"] = []string{"someone-else"}
+	_, err = p.verifyIDToken(server.sign(t, claims), "n-1", now)
+	assert.ErrorIs(t, err, errOIDCInvalidToken)
+
+	parts := strings.Split(valid, ".")
+	claims = server.baseClaims()
+	claims["sub"] = "admin"
+	forged, _ := json.Marshal(claims)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+	_, err = p.verifyIDToken(tampered, "n-1", now)
+	assert.ErrorIs(t, err, errOIDCInvalidToken)
+}
+
+// Тест: возврат от провайдера без cookie со state не принимается
+func TestHandleOIDCCallbackStateMismatch(t *testing.T) {
+	req := httptest.NewRequest("GET", "/oidc/callback?state=abc&code=good-code", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "other"})
+	rr := httptest.NewRecorder()
+	handleOIDCCallback(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"token_invalid"`)
+}
+
+// Тест: привязка к неподтверждённому аккаунту с тем же email сбрасывает пароль,
+// заданный при регистрации, и закрывает сессии; подтверждённый аккаунт не меняется
+func TestLinkOIDCUserExistingEmail(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	claims := oidcClaims{Subject: "sub-1", Email: "Victim@Example.com", EmailVerified: true, Name: "Иван Петров"}
+	expectLink := func(confirmed bool) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id FROM user_identities").
+			WithArgs("google", "sub-1").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("INSERT INTO users").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery("SELECT id, is_confirmed FROM users WHERE lower\\(email\\) = \\$1 FOR UPDATE").
+			WithArgs("victim@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "is_confirmed"}).AddRow(5, confirmed))
+		if !confirmed {
+			mock.ExpectExec("UPDATE users SET is_confirmed = TRUE, password = \\$1, confirmation_token = NULL WHERE id = \\$2").
+				WithArgs(sqlmock.AnyArg(), 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("DELETE FROM sessions WHERE user_id = \\$1").
+				WithArgs(5).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+		}
+		mock.ExpectExec("INSERT INTO user_identities").
+			WithArgs("google", "sub-1", 5, "victim@example.com").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	expectLink(false)
+	userID, err := linkOIDCUser("google", claims, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, userID)
+
+	expectLink(true)
+	userID, err = linkOIDCUser("google", claims, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, userID)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Описание HTTP API в формате OpenAPI 3. Документ строится из таблицы маршрутов
+// ниже, а схемы тел запросов и ответов — из тех же Go-типов, которые разбирают
+// и возвращают обработчики, с учётом тегов json и validate. Новый маршрут нужно
+// добавить и в main, и в openAPIRoutes; TestOpenAPICoversAllRoutes это проверяет
+
+// Способы аутентификации маршрутов
+const (
+	authNone    = ""
+	authSession = "session"
+	authAdmin   = "admin"
+	authAPIKey  = "apiKey"
+)
+
+// Описание одной операции
+type openAPIRoute struct {
+	Method   string
+	Path     string
+	Tag      string
+	Summary  string
+	Auth     string
+	Query    []string    // параметры строки запроса
+	Request  interface{} // тело JSON: нулевое значение типа, который разбирает обработчик
+	Form     []string    // поля multipart-формы; поле-файл помечается суффиксом @file
+	Status   int         // код успешного ответа, по умолчанию 200
+	Response interface{} // содержимое data успешного ответа
+	Produces string      // тип ответа, если это не JSON: text/html, файл или перенаправление
+}
+
+// Ответ-перенаправление вместо тела
+const producesRedirect = "redirect"
+
+// Содержимое data, которое обработчик собирает в map
+type openAPIObject map[string]interface{}
+
+var openAPIRoutes = []openAPIRoute{
+	{Method: "GET", Path: "/openapi.json", Tag: "service", Summary: "Это описание API", Produces: "application/json"},
+	{Method: "GET", Path: "/csrf-token", Tag: "service", Summary: "CSRF-токен для заголовка X-CSRF-Token", Response: map[string]string{}},
+
+	// Регистрация и вход
+	{Method: "POST", Path: "/register", Tag: "auth", Summary: "Регистрация; на email приходит ссылка подтверждения", Request: User{}},
+	{Method: "GET", Path: "/confirm", Tag: "auth", Summary: "Подтверждение email по ссылке из письма", Query: []string{"token"}},
+	{Method: "POST", Path: "/confirm/resend", Tag: "auth", Summary: "Повторная отправка письма подтверждения", Request: EmailRequest{}},
+	{Method: "POST", Path: "/login", Tag: "auth", Summary: "Вход по email и паролю. Если включена 2FA, возвращается mfa_token", Request: LoginData{}, Response: MFAChallenge{}},
+	{Method: "POST", Path: "/login/2fa", Tag: "auth", Summary: "Второй шаг входа: код TOTP или код восстановления", Request: MFALoginRequest{}},
+	{Method: "POST", Path: "/logout", Tag: "auth", Summary: "Выход из аккаунта", Auth: authSession},
+	{Method: "POST", Path: "/password/forgot", Tag: "auth", Summary: "Письмо со ссылкой для сброса пароля", Request: EmailRequest{}},
+	{Method: "POST", Path: "/password/reset", Tag: "auth", Summary: "Новый пароль по токену из письма", Request: PasswordResetRequest{}},
+	{Method: "GET", Path: "/oidc/providers", Tag: "auth", Summary: "Внешние провайдеры входа", Response: []OIDCProviderInfo{}},
+	{Method: "GET", Path: "/oidc/login", Tag: "auth", Summary: "Перенаправление на страницу входа провайдера", Query: []string{"provider"}, Produces: producesRedirect},
+	{Method: "GET", Path: "/oidc/callback", Tag: "auth", Summary: "Возврат от провайдера после входа", Query: []string{"state", "code", "error"}, Produces: producesRedirect},
+
+	// Профиль
+	{Method: "GET", Path: "/profile", Tag: "profile", Summary: "Профиль текущего пользователя", Auth: authSession, Response: Profile{}},
+	{Method: "POST", Path: "/profile", Tag: "profile", Summary: "Изменение профиля", Auth: authSession, Request: ProfileUpdate{}, Response: Profile{}},
+	{Method: "POST", Path: "/profile/avatar", Tag: "profile", Summary: "Загрузка аватара (JPEG, PNG или WebP)", Auth: authSession, Form: []string{"avatar@file"}, Response: openAPIObject{}},
+	{Method: "GET", Path: "/avatars/{file}", Tag: "profile", Summary: "Файл аватара", Produces: "image/*"},
+	{Method: "POST", Path: "/profile/email", Tag: "profile", Summary: "Смена email с подтверждением по ссылке", Auth: authSession, Request: EmailChangeRequest{}},
+	{Method: "GET", Path: "/profile/email/confirm", Tag: "profile", Summary: "Подтверждение нового email", Query: []string{"token"}},
+	{Method: "POST", Path: "/profile/delete", Tag: "profile", Summary: "Удаление аккаунта", Auth: authSession, Request: PasswordConfirmation{}},
+	{Method: "POST", Path: "/profile/export", Tag: "profile", Summary: "Запрос архива с данными пользователя", Auth: authSession, Status: http.StatusAccepted},
+	{Method: "GET", Path: "/profile/export/download", Tag: "profile", Summary: "Скачивание готового архива", Auth: authSession, Query: []string{"token"}, Produces: "application/zip"},
+	{Method: "POST", Path: "/profile/2fa/setup", Tag: "profile", Summary: "Начало настройки TOTP", Auth: authSession, Request: PasswordConfirmation{}, Response: TOTPSetup{}},
+	{Method: "POST", Path: "/profile/2fa/enable", Tag: "profile", Summary: "Включение TOTP; возвращает коды восстановления", Auth: authSession, Request: TOTPCodeRequest{}, Response: openAPIObject{}},
+	{Method: "POST", Path: "/profile/2fa/disable", Tag: "profile", Summary: "Отключение TOTP", Auth: authSession, Request: TOTPDisableRequest{}},
+
+	// Чат и поддержка
+	{Method: "POST", Path: "/send-chat-message", Tag: "chat", Summary: "Сообщение в чат", Request: RequestData{}},
+	{Method: "GET", Path: "/messages", Tag: "chat", Summary: "Сообщения чата", Response: []Message{}},
+	{Method: "POST", Path: "/clear-messages", Tag: "chat", Summary: "Очистка чата", Auth: authAdmin},
+	{Method: "POST", Path: "/send-support-message", Tag: "support", Summary: "Обращение в поддержку с необязательным вложением", Form: []string{"email", "message", "attachment@file"}},
+
+	// Каталог и цены
+	{Method: "GET", Path: "/cars", Tag: "catalog", Summary: "Страница каталога автомобилей",
+		Query: []string{"category", "brand", "sort", "pickup_date", "dropoff_date", "pickup_branch", "dropoff_branch", "currency", "page"}, Produces: "text/html"},
+	{Method: "GET", Path: "/branches", Tag: "catalog", Summary: "Филиалы проката", Response: []Branch{}},
+	{Method: "GET", Path: "/quote", Tag: "catalog", Summary: "Расчёт стоимости автомобиля или номера", Query: []string{"type", "id", "from", "to", "currency"}, Response: Quote{}},
+
+	// Бронирования и оплата
+	{Method: "POST", Path: "/cars/book", Tag: "bookings", Summary: "Бронирование автомобиля", Auth: authSession, Request: CarBookingRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "POST", Path: "/holds", Tag: "bookings", Summary: "Удержание объекта на время оплаты", Auth: authSession, Request: HoldRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "POST", Path: "/holds/release", Tag: "bookings", Summary: "Снятие удержания", Auth: authSession, Request: HoldRef{}},
+	{Method: "POST", Path: "/holds/checkout", Tag: "bookings", Summary: "Оплата удержания; нужен заголовок Idempotency-Key", Auth: authSession, Request: HoldCheckoutRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "GET", Path: "/bookings/my", Tag: "bookings", Summary: "Бронирования пользователя", Auth: authSession, Response: []Booking{}},
+	{Method: "POST", Path: "/bookings/cancel", Tag: "bookings", Summary: "Отмена бронирования с возвратом по правилам отмены", Auth: authSession, Request: BookingRef{}, Response: openAPIObject{}},
+	{Method: "POST", Path: "/bookings/modify", Tag: "bookings", Summary: "Изменение дат или объекта бронирования; нужен Idempotency-Key", Auth: authSession, Request: ModifyBookingRequest{}, Response: openAPIObject{}},
+	{Method: "POST", Path: "/payments/authorize", Tag: "payments", Summary: "Блокировка суммы; нужен Idempotency-Key", Auth: authSession, Request: BookingPaymentRequest{}, Response: openAPIObject{}},
+	{Method: "POST", Path: "/payments/capture", Tag: "payments", Summary: "Списание заблокированной суммы; нужен Idempotency-Key", Auth: authSession, Request: PaymentRef{}, Response: openAPIObject{}},
+	{Method: "POST", Path: "/payments/webhook", Tag: "payments", Summary: "Уведомление платёжного провайдера, подписанное HMAC", Request: WebhookEvent{}},
+	{Method: "POST", Path: "/waitlist", Tag: "bookings", Summary: "Запись в лист ожидания на занятые даты", Auth: authSession, Request: WaitlistRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "GET", Path: "/waitlist/my", Tag: "bookings", Summary: "Записи пользователя в листе ожидания", Auth: authSession, Response: []WaitlistEntry{}},
+	{Method: "POST", Path: "/waitlist/leave", Tag: "bookings", Summary: "Выход из листа ожидания", Auth: authSession, Request: WaitlistRef{}},
+	{Method: "GET", Path: "/trips", Tag: "trips", Summary: "Поездки пользователя", Auth: authSession, Response: []Trip{}},
+	{Method: "POST", Path: "/trips", Tag: "trips", Summary: "Новая поездка", Auth: authSession, Request: TripCreateRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "POST", Path: "/trips/items", Tag: "trips", Summary: "Добавление номера или автомобиля в поездку", Auth: authSession, Request: TripItemRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "POST", Path: "/trips/checkout", Tag: "trips", Summary: "Оплата поездки; нужен Idempotency-Key", Auth: authSession, Request: TripCheckoutRequest{}, Response: openAPIObject{}},
+	{Method: "POST", Path: "/trips/cancel", Tag: "trips", Summary: "Отмена поездки", Auth: authSession, Request: TripRef{}, Response: openAPIObject{}},
+
+	// API партнёров
+	{Method: "GET", Path: "/api/v1/cars", Tag: "partner-api", Summary: "Поиск автомобилей с ценами", Auth: authAPIKey,
+		Query: []string{"category", "brand", "sort", "pickup_date", "dropoff_date", "pickup_branch", "dropoff_branch", "currency"}, Response: []CarOffer{}},
+	{Method: "GET", Path: "/api/v1/rooms", Tag: "partner-api", Summary: "Поиск свободных номеров", Auth: authAPIKey, Query: []string{"check_in", "check_out", "guests", "hotel", "currency"}, Response: []RoomOffer{}},
+	{Method: "GET", Path: "/api/v1/branches", Tag: "partner-api", Summary: "Филиалы проката", Auth: authAPIKey, Response: []Branch{}},
+	{Method: "GET", Path: "/api/v1/quote", Tag: "partner-api", Summary: "Расчёт стоимости", Auth: authAPIKey, Query: []string{"type", "id", "from", "to", "currency"}, Response: Quote{}},
+	{Method: "POST", Path: "/api/v1/holds", Tag: "partner-api", Summary: "Удержание объекта (ключ с правом book)", Auth: authAPIKey, Request: HoldRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "POST", Path: "/api/v1/holds/release", Tag: "partner-api", Summary: "Снятие удержания", Auth: authAPIKey, Request: HoldRef{}},
+	{Method: "POST", Path: "/api/v1/holds/checkout", Tag: "partner-api", Summary: "Оплата удержания; нужен Idempotency-Key", Auth: authAPIKey, Request: HoldCheckoutRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "GET", Path: "/api/v1/bookings", Tag: "partner-api", Summary: "Бронирования партнёра", Auth: authAPIKey, Response: []Booking{}},
+	{Method: "POST", Path: "/api/v1/bookings/cancel", Tag: "partner-api", Summary: "Отмена бронирования", Auth: authAPIKey, Request: BookingRef{}, Response: openAPIObject{}},
+
+	// Администрирование
+	{Method: "POST", Path: "/admin/cars", Tag: "admin", Summary: "Добавление автомобиля в каталог", Auth: authAdmin, Request: Car{}, Status: http.StatusCreated, Response: Car{}},
+	{Method: "POST", Path: "/admin/maintenance", Tag: "admin", Summary: "Период обслуживания автомобиля", Auth: authAdmin, Request: MaintenanceBlock{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/admin/users/unlock", Tag: "admin", Summary: "Снятие блокировки входа", Auth: authAdmin, Request: EmailRequest{}},
+	{Method: "POST", Path: "/admin/branches", Tag: "admin", Summary: "Новый филиал", Auth: authAdmin, Request: Branch{}, Status: http.StatusCreated, Response: Branch{}},
+	{Method: "POST", Path: "/admin/bookings/return", Tag: "admin", Summary: "Приёмка автомобиля после проката", Auth: authAdmin, Request: BookingRef{}},
+	{Method: "POST", Path: "/admin/bookings/status", Tag: "admin", Summary: "Смена статуса бронирования", Auth: authAdmin, Request: BookingStatusRequest{}},
+	{Method: "GET", Path: "/admin/rates", Tag: "admin", Summary: "Текущие курсы валют", Auth: authAdmin, Response: openAPIObject{}},
+	{Method: "POST", Path: "/admin/rates", Tag: "admin", Summary: "Загрузка таблицы курсов", Auth: authAdmin, Form: []string{"rates@file"}},
+	{Method: "POST", Path: "/admin/payments/refund", Tag: "admin", Summary: "Возврат оплаты; нужен Idempotency-Key", Auth: authAdmin, Request: RefundRequest{}, Response: openAPIObject{}},
+	{Method: "GET", Path: "/admin/partners", Tag: "admin", Summary: "Партнёры API", Auth: authAdmin, Response: []Partner{}},
+	{Method: "POST", Path: "/admin/partners", Tag: "admin", Summary: "Регистрация партнёра для существующего аккаунта", Auth: authAdmin, Request: PartnerRequest{}, Status: http.StatusCreated, Response: Partner{}},
+	{Method: "GET", Path: "/admin/api-keys", Tag: "admin", Summary: "Ключи партнёра со счётчиками запросов", Auth: authAdmin, Query: []string{"partner_id"}, Response: []APIKey{}},
+	{Method: "POST", Path: "/admin/api-keys", Tag: "admin", Summary: "Выпуск ключа API; значение показывается один раз", Auth: authAdmin, Request: APIKeyRequest{}, Status: http.StatusCreated, Response: openAPIObject{}},
+	{Method: "POST", Path: "/admin/api-keys/revoke", Tag: "admin", Summary: "Отзыв ключа API", Auth: authAdmin, Request: APIKeyRevokeRequest{}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]interface{}
+	openAPIJSON []byte
+)
+
+// Функция возвращает документ OpenAPI; он строится один раз при первом обращении
+func openAPIDocument() map[string]interface{} {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPI(openAPIRoutes)
+		openAPIJSON, _ = json.MarshalIndent(openAPIDoc, "", "  ")
+	})
+	return openAPIDoc
+}
+
+// Обработчик /openapi.json
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		openAPIDocument()
+		w.Write(openAPIJSON)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Функция сборки документа по таблице маршрутов
+func buildOpenAPI(routes []openAPIRoute) map[string]interface{} {
+	components := map[string]interface{}{}
+	schemas := openAPISchemas{components}
+	envelope := schemas.of(reflect.TypeOf(Response{}))
+
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		op := map[string]interface{}{
+			"tags":        []string{route.Tag},
+			"summary":     route.Summary,
+			"operationId": operationID(route),
+		}
+
+		var params []interface{}
+		for _, name := range route.Query {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, segment := range strings.Split(route.Path, "/") {
+			if strings.HasPrefix(segment, "{") {
+				params = append(params, map[string]interface{}{
+					"name": strings.Trim(segment, "{}"), "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		// Изменяющие запросы из браузера подтверждаются CSRF-токеном (см. withCSRF)
+		if route.Method != http.MethodGet && route.Auth != authAPIKey && route.Path != "/payments/webhook" {
+			params = append(params, map[string]interface{}{
+				"name": csrfHeader, "in": "header", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		switch {
+		case route.Request != nil:
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(route.Request))},
+				},
+			}
+		case route.Form != nil:
+			props := map[string]interface{}{}
+			for _, field := range route.Form {
+				if name, ok := strings.CutSuffix(field, "@file"); ok {
+					props[name] = map[string]interface{}{"type": "string", "format": "binary"}
+				} else {
+					props[field] = map[string]interface{}{"type": "string"}
+				}
+			}
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"multipart/form-data": map[string]interface{}{"schema": map[string]interface{}{"type": "object", "properties": props}},
+				},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		responses := map[string]interface{}{}
+		switch route.Produces {
+		case "":
+			success := envelope
+			if route.Response != nil {
+				success = map[string]interface{}{"allOf": []interface{}{envelope, map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"data": schemas.of(reflect.TypeOf(route.Response))},
+				}}}
+			}
+			responses[strconv.Itoa(status)] = jsonResponse(http.StatusText(status), success)
+		case producesRedirect:
+			responses["302"] = map[string]interface{}{"description": "Перенаправление"}
+		default:
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     map[string]interface{}{route.Produces: map[string]interface{}{}},
+			}
+		}
+		// Ошибки всех маршрутов возвращаются в едином формате Response
+		responses["default"] = jsonResponse("Ошибка", envelope)
+		op["responses"] = responses
+
+		switch route.Auth {
+		case authSession, authAdmin:
+			op["security"] = []interface{}{map[string]interface{}{"session": []string{}}}
+			if route.Auth == authAdmin {
+				op["description"] = "Только для администраторов, вошедших со вторым фактором"
+			}
+		case authAPIKey:
+			op["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}, map[string]interface{}{"bearer": []string{}}}
+		}
+
+		item, _ := paths[route.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "BookEasy API",
+			"version":     "1.0.0",
+			"description": "Аренда автомобилей и бронирование отелей",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+				"apiKey":  map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer":  map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func jsonResponse(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+	}
+}
+
+// Идентификатор операции из метода и пути: POST /profile/2fa/setup -> postProfile2faSetup
+func operationID(route openAPIRoute) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool { return r == '/' || r == '-' || r == '.' || r == '{' || r == '}' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// Построитель схем из Go-типов. Именованные структуры попадают в components/schemas
+// и подключаются через $ref
+type openAPISchemas struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s openAPISchemas) of(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(openAPIObject{}):
+		return map[string]interface{}{"type": "object"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := map[string]interface{}{}
+		for k, v := range s.of(t.Elem()) {
+			schema[k] = v
+		}
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = nil // защита от рекурсии
+			s.components[t.Name()] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// Функция описания полей структуры. Встроенные структуры без тега json
+// раскрываются, как это делает encoding/json
+func (s openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")[0]
+			if tag == "-" || !field.IsExported() {
+				continue
+			}
+			if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+				collect(field.Type)
+				continue
+			}
+			name := field.Name
+			if tag != "" {
+				name = tag
+			}
+
+			schema := s.of(field.Type)
+			if s.applyRules(schema, field.Tag.Get("validate")) {
+				required = append(required, name)
+			}
+			props[name] = schema
+		}
+	}
+	collect(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if required != nil {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// Функция переноса правил validate в схему; возвращает признак обязательного поля.
+// Нулевое значение необязательного поля валидатор не проверяет, поэтому для таких
+// полей min не переносится, а в enum добавляется пустая строка
+func (s openAPISchemas) applyRules(schema map[string]interface{}, rules string) bool {
+	if rules == "" {
+		return false
+	}
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "required" {
+			required = true
+		}
+	}
+
+	isString := schema["type"] == "string"
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		limit, _ := strconv.ParseFloat(arg, 64)
+		switch {
+		case name == "email", name == "date":
+			schema["format"] = name
+		case name == "phone":
+			schema["pattern"] = phonePattern.String()
+		case name == "max" && isString:
+			schema["maxLength"] = int(limit)
+		case name == "max":
+			schema["maximum"] = limit
+		case name == "min" && isString && required:
+			schema["minLength"] = int(limit)
+		case name == "min" && required:
+			schema["minimum"] = limit
+		case name == "oneof":
+			var values []interface{}
+			for _, v := range strings.Split(arg, "|") {
+				values = append(values, v)
+			}
+			if !required && isString {
+				values = append(values, "")
+			}
+			schema["enum"] = values
+		}
+	}
+	return required
+}
+
+// Проверка запросов и ответов по документу OpenAPI. Используется в тестах:
+// обработчик оборачивается этим middleware, а все расхождения со спецификацией
+// передаются в report
+func withOpenAPIValidation(doc map[string]interface{}, report func(error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, ok := findOperation(doc, r.Method, r.URL.Path)
+		if !ok {
+			report(fmt.Errorf("%s %s: маршрут не описан в спецификации", r.Method, r.URL.Path))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if schema := lookup(op, "requestBody", "content", "application/json", "schema"); schema != nil && r.Body != nil {
+			body, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var value interface{}
+			if err := json.Unmarshal(body, &value); err != nil {
+				report(fmt.Errorf("%s %s: тело запроса не JSON: %v", r.Method, r.URL.Path, err))
+			} else {
+				for _, problem := range validateSchema(doc, schema, value, "request") {
+					report(fmt.Errorf("%s %s: %s", r.Method, r.URL.Path, problem))
+				}
+			}
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		responses, _ := op["responses"].(map[string]interface{})
+		response, ok := responses[strconv.Itoa(status)].(map[string]interface{})
+		if !ok {
+			if status < 400 {
+				report(fmt.Errorf("%s %s: код %d не описан в спецификации", r.Method, r.URL.Path, status))
+				return
+			}
+			response, _ = responses["default"].(map[string]interface{})
+		}
+		schema := lookup(response, "content", "application/json", "schema")
+		if schema == nil || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			return
+		}
+		var value interface{}
+		if err := json.Unmarshal(rec.body.Bytes(), &value); err != nil {
+			report(fmt.Errorf("%s %s: ответ не JSON: %v", r.Method, r.URL.Path, err))
+			return
+		}
+		for _, problem := range validateSchema(doc, schema, value, "response") {
+			report(fmt.Errorf("%s %s: %s", r.Method, r.URL.Path, problem))
+		}
+	})
+}
+
+// Функция поиска операции по методу и пути с учётом шаблонов {param}
+func findOperation(doc map[string]interface{}, method, path string) (map[string]interface{}, bool) {
+	paths, _ := doc["paths"].(map[string]interface{})
+	for template, item := range paths {
+		if !matchPath(template, path) {
+			continue
+		}
+		op, ok := item.(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+		if ok {
+			return op, true
+		}
+	}
+	return nil, false
+}
+
+func matchPath(template, path string) bool {
+	want, got := strings.Split(template, "/"), strings.Split(path, "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if want[i] != got[i] && !(strings.HasPrefix(want[i], "{") && got[i] != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// Функция обхода вложенных map по ключам
+func lookup(node map[string]interface{}, keys ...string) map[string]interface{} {
+	for _, key := range keys {
+		next, ok := node[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// Функция проверки значения по схеме. Поддерживается подмножество JSON Schema,
+// которое порождает buildOpenAPI: $ref, allOf, type, nullable, properties,
+// required, items, enum и ограничения длины и диапазона
+func validateSchema(doc, schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		target := lookup(doc, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+		if target == nil {
+			return []string{path + ": неизвестная схема " + ref}
+		}
+		return validateSchema(doc, target, value, path)
+	}
+
+	var problems []string
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		if value == nil && schema["nullable"] == true {
+			return nil
+		}
+		for _, part := range all {
+			problems = append(problems, validateSchema(doc, part.(map[string]interface{}), value, path)...)
+		}
+	}
+
+	if value == nil {
+		if typ, ok := schema["type"]; ok && schema["nullable"] != true {
+			return append(problems, fmt.Sprintf("%s: null вместо %v", path, typ))
+		}
+		return problems
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, path+": ожидался объект")
+		}
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, present := obj[name]; !present {
+					problems = append(problems, fmt.Sprintf("%s.%s: обязательное поле отсутствует", path, name))
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, v := range obj {
+			if prop, ok := props[name].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(doc, prop, v, path+"."+name)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(problems, path+": ожидался массив")
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			problems = append(problems, validateSchema(doc, itemSchema, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(problems, path+": ожидалась строка")
+		}
+		if max, ok := schema["maxLength"].(int); ok && len([]rune(s)) > max {
+			problems = append(problems, fmt.Sprintf("%s: длиннее %d символов", path, max))
+		}
+		if min, ok := schema["minLength"].(int); ok && len([]rune(s)) < min {
+			problems = append(problems, fmt.Sprintf("%s: короче %d символов", path, min))
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && n != float64(int64(n))) {
+			return append(problems, fmt.Sprintf("%s: ожидалось %v", path, schema["type"]))
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			problems = append(problems, fmt.Sprintf("%s: больше %v", path, max))
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			problems = append(problems, fmt.Sprintf("%s: меньше %v", path, min))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(problems, path+": ожидалось true или false")
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if allowed == value {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: значение %v не из списка %v", path, value, enum))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: каждый маршрут, зарегистрированный в main.go, описан в спецификации
+func TestOpenAPICoversAllRoutes(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Ошибка разбора main.go: %v", err)
+	}
+
+	paths := openAPIDocument()["paths"].(map[string]interface{})
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc") {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		path, _ := strconv.Unquote(lit.Value)
+		if x, ok := sel.X.(*ast.Ident); ok && x.Name == "api" {
+			path = "/api/v1" + path
+		}
+		if path == "/" {
+			return true // статические страницы
+		}
+
+		found := false
+		for documented := range paths {
+			if documented == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(documented, path)) {
+				found = true
+			}
+		}
+		assert.True(t, found, "маршрут %s не описан в openAPIRoutes", path)
+		return true
+	})
+}
+
+// Тест: правила validate переносятся в схемы компонентов
+func TestOpenAPISchemaFromValidateTags(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleOpenAPI(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.True(t, json.Valid(rr.Body.Bytes()))
+	assert.Contains(t, rr.Body.String(), `"openapi": "3.0.3"`)
+
+	schemas := openAPIDocument()["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	hold := schemas["HoldRequest"].(map[string]interface{})
+	assert.ElementsMatch(t, []string{"item_type", "item_id", "start_date", "end_date"}, hold["required"])
+	props := hold["properties"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"car", "room"}, props["item_type"].(map[string]interface{})["enum"])
+	assert.Equal(t, "date", props["start_date"].(map[string]interface{})["format"])
+
+	// Необязательное поле с oneof может быть пустым
+	profile := schemas["ProfileUpdate"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(t, profile["locale"].(map[string]interface{})["enum"], "")
+
+	// Поля встроенной структуры раскрываются
+	item := schemas["TripItemRequest"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(t, item, "trip_id")
+	assert.Contains(t, item, "item_type")
+}
+
+// Тест: middleware сообщает о запросах и ответах, не совпадающих со спецификацией
+func TestOpenAPIValidationMiddleware(t *testing.T) {
+	var problems []string
+	report := func(err error) { problems = append(problems, err.Error()) }
+	doc := openAPIDocument()
+
+	// Обработчик отвечает по спецификации, но запрос не соответствует схеме User
+	handler := withOpenAPIValidation(doc, report, http.HandlerFunc(handleRegister))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/register", strings.NewReader(`{"email": 5, "password": "short"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Len(t, problems, 4, "%v", problems)
+	assert.Contains(t, strings.Join(problems, "\n"), "request.email: ожидалась строка")
+	assert.Contains(t, strings.Join(problems, "\n"), "request.first_name: обязательное поле отсутствует")
+
+	// Ответ с данными неверного типа
+	problems = nil
+	handler = withOpenAPIValidation(doc, report, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":[{"id":"1","name":"Центр","city":"Алматы"}]}`))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/branches", nil))
+	assert.Equal(t, []string{"GET /branches: response.data[0].id: ожидалось integer"}, problems)
+
+	// Маршрут вне спецификации
+	problems = nil
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+	assert.Len(t, problems, 1)
+
+	// Ответ, полностью совпадающий со спецификацией
+	problems = nil
+	handler = withOpenAPIValidation(doc, report, http.HandlerFunc(handleOIDCProviders))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/oidc/providers", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Срок действия ссылки для сброса пароля
+var passwordResetLifetime = time.Duration(envInt("PASSWORD_RESET_MINUTES", 60)) * time.Minute
+
+var (
+	errResetTokenInvalid = errors.New("некорректный или уже использованный токен")
+	errResetTokenExpired = errors.New("срок действия токена истёк")
+)
+
+// Функция создания токена сброса пароля и отправки ссылки на почту.
+// Прежние неиспользованные токены пользователя перестают действовать
+func requestPasswordReset(userID int, email, locale string) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hashToken(token), userID, time.Now().Add(passwordResetLifetime))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	subject, body := renderEmail(locale, "password_reset", appBaseURL, token, int(passwordResetLifetime.Minutes()))
+	return emailSender.SendEmail([]string{email}, subject, body)
+}
+
+// Функция установки нового пароля по токену. Токен погашается,
+// а все сессии пользователя закрываются
+func resetPassword(token, password string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var expired, used bool
+	err = tx.QueryRow(`SELECT user_id, expires_at <= NOW(), used_at IS NOT NULL FROM password_resets WHERE token_hash = $1 FOR UPDATE`,
+		hashToken(token)).Scan(&userID, &expired, &used)
+	if err == sql.ErrNoRows || used {
+		return errResetTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if expired {
+		return errResetTokenExpired
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET password = $1 WHERE id = $2`, password, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE password_resets SET used_at = NOW() WHERE token_hash = $1`, hashToken(token)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Обработчик запроса на восстановление пароля. Ответ не зависит от того,
+// зарегистрирован ли email, чтобы по нему нельзя было проверить наличие аккаунта
+func handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var req EmailRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		var userID int
+		var email string
+		err := db.QueryRow(`SELECT id, email FROM users WHERE lower(email) = $1`, normalizeEmail(req.Email)).Scan(&userID, &email)
+		if err == nil {
+			if err := requestPasswordReset(userID, email, requestLocale(r)); err != nil {
+				log.Printf("Ошибка отправки ссылки для сброса пароля: %v", err)
+			}
+		} else if err != sql.ErrNoRows {
+			log.Printf("Ошибка SQL: %v", err)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Если этот email зарегистрирован, мы отправили на него ссылку для восстановления пароля", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос установки нового пароля по токену
+type PasswordResetRequest struct {
+	Token    string `json:"token" validate:"required,max=128"`
+	Password string `json:"password" validate:"required,min=8,max=128"`
+}
+
+// Обработчик установки нового пароля по ссылке из письма
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var req PasswordResetRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		err := resetPassword(req.Token, req.Password)
+		if errors.Is(err, errResetTokenInvalid) {
+			writeErrorCode(w, r, http.StatusBadRequest, "token_invalid", "Ссылка недействительна или уже использована")
+			return
+		}
+		if errors.Is(err, errResetTokenExpired) {
+			writeErrorCode(w, r, http.StatusGone, "token_expired", "Срок действия ссылки истёк, запросите новую")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Пароль изменён. Войдите с новым паролем", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
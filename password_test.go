@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: ответ на запрос восстановления одинаков для известного и неизвестного email
+func TestHandleForgotPasswordUnknownEmail(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT id, email FROM users").
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+
+	req := httptest.NewRequest("POST", "/password/forgot", strings.NewReader(`{"email":"nobody@example.com"}`))
+	rr := httptest.NewRecorder()
+	handleForgotPassword(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"success","message":"Если этот email зарегистрирован, мы отправили на него ссылку для восстановления пароля"}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: просроченный токен не меняет пароль
+func TestResetPasswordExpiredToken(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, expires_at <= NOW\\(\\), used_at IS NOT NULL FROM password_resets").
+		WithArgs(hashToken("abc")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "expired", "used"}).AddRow(7, true, false))
+	mock.ExpectRollback()
+
+	err = resetPassword("abc", "new-password")
+	assert.ErrorIs(t, err, errResetTokenExpired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Статусы платежа
+const (
+	paymentAuthorized = "authorized"
+	paymentCaptured   = "captured"
+	paymentRefunded   = "refunded"
+	paymentFailed     = "failed"
+)
+
+var (
+	errPaymentDeclined = errors.New("платёж отклонён")
+	errPaymentNotFound = errors.New("платёж не найден")
+	errInvalidWebhook  = errors.New("некорректная подпись webhook")
+	errRefundTooLarge  = errors.New("сумма возврата больше оплаченной")
+	errPaymentOutdated = errors.New("сумма бронирования изменилась после авторизации")
+)
+
+// Запрос на авторизацию (блокировку) суммы
+type AuthorizeRequest struct {
+	Amount         Money
+	PaymentToken   string // Токен карты, выданный платёжной формой провайдера
+	IdempotencyKey string
+	Description    string
+}
+
+// Результат операции у провайдера
+type PaymentResult struct {
+	ProviderRef string
+	Status      string
+	Amount      Money
+}
+
+// Событие, присланное провайдером на /payments/webhook
+type WebhookEvent struct {
+	Type        string `json:"type"` // payment.captured, payment.refunded, payment.failed
+	ProviderRef string `json:"provider_ref"`
+	Amount      Money  `json:"amount"`
+	// Для payment.refunded — сколько всего возвращено по платежу на момент события
+	RefundedAmount Money `json:"refunded_amount"`
+}
+
+// Интерфейс платёжного провайдера. Авторизация блокирует сумму на карте,
+// списание (capture) выполняется отдельно, возврат может быть частичным
+type PaymentProvider interface {
+	Name() string
+	Authorize(req AuthorizeRequest) (PaymentResult, error)
+	Capture(providerRef string, amount Money) (PaymentResult, error)
+	Refund(providerRef string, amount Money) (PaymentResult, error)
+	ParseWebhook(r *http.Request) (WebhookEvent, error)
+}
+
+var paymentProvider PaymentProvider = newSandboxProvider(envString("SANDBOX_WEBHOOK_SECRET", "sandbox-secret"))
+
+// Встроенный провайдер для локальной разработки и тестов. Деньги не списываются;
+// токен "tok_declined" имитирует отказ банка, любой другой непустой токен — успешную оплату
+type sandboxProvider struct {
+	mu       sync.Mutex
+	secret   []byte
+	seq      int
+	payments map[string]*sandboxPayment
+	byKey    map[string]string
+}
+
+type sandboxPayment struct {
+	amount   Money
+	captured int64
+	refunded int64
+	status   string
+}
+
+func newSandboxProvider(secret string) *sandboxProvider {
+	return &sandboxProvider{
+		secret:   []byte(secret),
+		payments: map[string]*sandboxPayment{},
+		byKey:    map[string]string{},
+	}
+}
+
+func (p *sandboxProvider) Name() string { return "sandbox" }
+
+func (p *sandboxProvider) Authorize(req AuthorizeRequest) (PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Повтор с тем же ключом возвращает ранее созданный платёж
+	if ref, ok := p.byKey[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		pay := p.payments[ref]
+		return PaymentResult{ProviderRef: ref, Status: pay.status, Amount: pay.amount}, nil
+	}
+	if req.PaymentToken == "" || req.PaymentToken == "tok_declined" {
+		return PaymentResult{}, errPaymentDeclined
+	}
+
+	p.seq++
+	ref := fmt.Sprintf("sb_%06d", p.seq)
+	p.payments[ref] = &sandboxPayment{amount: req.Amount, status: paymentAuthorized}
+	if req.IdempotencyKey != "" {
+		p.byKey[req.IdempotencyKey] = ref
+	}
+	return PaymentResult{ProviderRef: ref, Status: paymentAuthorized, Amount: req.Amount}, nil
+}
+
+func (p *sandboxProvider) Capture(providerRef string, amount Money) (PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pay, ok := p.payments[providerRef]
+	if !ok {
+		return PaymentResult{}, errPaymentNotFound
+	}
+	if pay.status == paymentCaptured {
+		return PaymentResult{ProviderRef: providerRef, Status: pay.status, Amount: Money{pay.captured, pay.amount.Currency}}, nil
+	}
+	if pay.status != paymentAuthorized || amount.Currency != pay.amount.Currency || amount.Amount > pay.amount.Amount {
+		return PaymentResult{}, errPaymentDeclined
+	}
+	pay.captured = amount.Amount
+	pay.status = paymentCaptured
+	return PaymentResult{ProviderRef: providerRef, Status: paymentCaptured, Amount: amount}, nil
+}
+
+func (p *sandboxProvider) Refund(providerRef string, amount Money) (PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pay, ok := p.payments[providerRef]
+	if !ok {
+		return PaymentResult{}, errPaymentNotFound
+	}
+	if pay.status != paymentCaptured && pay.status != paymentRefunded {
+		return PaymentResult{}, errPaymentDeclined
+	}
+	if amount.Currency != pay.amount.Currency || pay.refunded+amount.Amount > pay.captured {
+		return PaymentResult{}, errRefundTooLarge
+	}
+	pay.refunded += amount.Amount
+	if pay.refunded == pay.captured {
+		pay.status = paymentRefunded
+	}
+	return PaymentResult{ProviderRef: providerRef, Status: pay.status, Amount: amount}, nil
+}
+
+// Подпись тела webhook: hex(HMAC-SHA256(secret, body)) в заголовке X-Sandbox-Signature
+func (p *sandboxProvider) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *sandboxProvider) ParseWebhook(r *http.Request) (WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	signature, err := hex.DecodeString(r.Header.Get("X-Sandbox-Signature"))
+	if err != nil {
+		return WebhookEvent{}, errInvalidWebhook
+	}
+	expected, _ := hex.DecodeString(p.Sign(body))
+	if !hmac.Equal(signature, expected) {
+		return WebhookEvent{}, errInvalidWebhook
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return WebhookEvent{}, err
+	}
+	return event, nil
+}
+
+// Платёж по бронированию
+type Payment struct {
+	ID             int    `json:"id"`
+	BookingID      int    `json:"booking_id"`
+	Provider       string `json:"provider"`
+	ProviderRef    string `json:"provider_ref"`
+	Status         string `json:"status"`
+	Amount         Money  `json:"amount"`
+	RefundedAmount Money  `json:"refunded_amount"`
+}
+
+// Функция загрузки платежа с блокировкой строки до конца транзакции
+func loadPaymentForUpdate(tx *sql.Tx, paymentID int) (Payment, error) {
+	var p Payment
+	err := tx.QueryRow(`SELECT id, booking_id, provider, provider_ref, status, amount, refunded_amount, currency
+		FROM payments WHERE id = $1 FOR UPDATE`, paymentID).
+		Scan(&p.ID, &p.BookingID, &p.Provider, &p.ProviderRef, &p.Status, &p.Amount.Amount, &p.RefundedAmount.Amount, &p.Amount.Currency)
+	p.RefundedAmount.Currency = p.Amount.Currency
+	if err == sql.ErrNoRows {
+		return p, errPaymentNotFound
+	}
+	return p, err
+}
+
+// Ключ идемпотентности для провайдера. Провайдер хранит ключи всех клиентов
+// вместе, поэтому ключ из заголовка дополняется пользователем, операцией и
+// объектом оплаты — иначе совпавший ключ вернёт чужой платёж. Пустой ключ
+// остаётся пустым
+func providerIdempotencyKey(userID int, route string, objectID int, key string) string {
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s:%d:%s", userID, route, objectID, key)
+}
+
+// Запрос блокировки суммы за бронирование
+type BookingPaymentRequest struct {
+	BookingID    int    `json:"booking_id" validate:"required"`
+	PaymentToken string `json:"payment_token" validate:"max=255"`
+}
+
+// Обработчик авторизации оплаты бронирования
+func handlePaymentAuthorize(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req BookingPaymentRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		var amount Money
+		var status string
+		var expired bool
+		err = db.QueryRow(`SELECT total_price, currency, status, COALESCE(expires_at <= NOW(), false) FROM bookings WHERE id = $1 AND user_id = $2`,
+			req.BookingID, userID).Scan(&amount.Amount, &amount.Currency, &status, &expired)
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, "Бронирование не найдено")
+			return
+		}
+		if status != bookingPending {
+			writeErrorCode(w, r, http.StatusConflict, "booking_not_pending", "Бронирование не ожидает оплаты")
+			return
+		}
+		if expired {
+			writeErrorCode(w, r, http.StatusGone, "hold_expired", "Время удержания истекло, выберите объект заново")
+			return
+		}
+
+		result, err := paymentProvider.Authorize(AuthorizeRequest{
+			Amount:         amount,
+			PaymentToken:   req.PaymentToken,
+			IdempotencyKey: providerIdempotencyKey(userID, r.URL.Path, req.BookingID, r.Header.Get(idempotencyHeader)),
+			Description:    fmt.Sprintf("Бронирование #%d", req.BookingID),
+		})
+		if err != nil {
+			log.Println("Ошибка авторизации платежа:", err)
+			writeErrorCode(w, r, http.StatusPaymentRequired, "payment_declined", "Платёж отклонён")
+			return
+		}
+
+		payment := Payment{
+			BookingID:      req.BookingID,
+			Provider:       paymentProvider.Name(),
+			ProviderRef:    result.ProviderRef,
+			Status:         result.Status,
+			Amount:         result.Amount,
+			RefundedAmount: Money{Currency: result.Amount.Currency},
+		}
+		err = db.QueryRow(`INSERT INTO payments (booking_id, provider, provider_ref, status, amount, currency)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+			payment.BookingID, payment.Provider, payment.ProviderRef, payment.Status, payment.Amount.Amount, payment.Amount.Currency).Scan(&payment.ID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Сумма заблокирована", map[string]interface{}{
+			"payment": payment,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Функция списания авторизованного платежа. Бронирование подтверждается
+// в той же транзакции, что и запись об успешном списании. Авторизация на сумму,
+// которая уже не совпадает с ценой бронирования, отменяется без списания
+func capturePayment(paymentID int) (Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Payment{}, err
+	}
+	defer tx.Rollback()
+
+	payment, err := loadPaymentForUpdate(tx, paymentID)
+	if err != nil {
+		return Payment{}, err
+	}
+	if payment.Status == paymentCaptured {
+		return payment, nil
+	}
+	if payment.Status != paymentAuthorized {
+		return Payment{}, errPaymentDeclined
+	}
+
+	var total Money
+	err = tx.QueryRow(`SELECT total_price, currency FROM bookings WHERE id = $1 FOR UPDATE`, payment.BookingID).
+		Scan(&total.Amount, &total.Currency)
+	if err != nil {
+		return Payment{}, err
+	}
+	if total != payment.Amount {
+		if _, err := tx.Exec(`UPDATE payments SET status = $1 WHERE id = $2`, paymentFailed, payment.ID); err != nil {
+			return Payment{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return Payment{}, err
+		}
+		return Payment{}, errPaymentOutdated
+	}
+
+	// Деньги списываются, только если бронирование ещё ожидает оплаты
+	if err := transitionBooking(tx, payment.BookingID, bookingConfirmed); err != nil {
+		return Payment{}, err
+	}
+
+	result, err := paymentProvider.Capture(payment.ProviderRef, payment.Amount)
+	if err != nil {
+		tx.Rollback()
+		db.Exec(`UPDATE payments SET status = $1 WHERE id = $2`, paymentFailed, payment.ID)
+		return Payment{}, err
+	}
+
+	payment.Status = result.Status
+	if _, err := tx.Exec(`UPDATE payments SET status = $1 WHERE id = $2`, payment.Status, payment.ID); err != nil {
+		return Payment{}, err
+	}
+	return payment, tx.Commit()
+}
+
+// Функция возврата части или всей оплаченной суммы
+func refundPayment(paymentID int, amount Money) (Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Payment{}, err
+	}
+	defer tx.Rollback()
+
+	payment, err := loadPaymentForUpdate(tx, paymentID)
+	if err != nil {
+		return Payment{}, err
+	}
+	if amount.Currency != payment.Amount.Currency || payment.RefundedAmount.Amount+amount.Amount > payment.Amount.Amount {
+		return Payment{}, errRefundTooLarge
+	}
+
+	result, err := paymentProvider.Refund(payment.ProviderRef, amount)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	payment.Status = result.Status
+	payment.RefundedAmount.Amount += amount.Amount
+	_, err = tx.Exec(`UPDATE payments SET status = $1, refunded_amount = $2 WHERE id = $3`,
+		payment.Status, payment.RefundedAmount.Amount, payment.ID)
+	if err != nil {
+		return Payment{}, err
+	}
+	return payment, tx.Commit()
+}
+
+// Ссылка на платёж
+type PaymentRef struct {
+	PaymentID int `json:"payment_id" validate:"required"`
+}
+
+// Обработчик списания оплаты
+func handlePaymentCapture(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req PaymentRef
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		var owner int
+		err = db.QueryRow(`SELECT b.user_id FROM payments p JOIN bookings b ON b.id = p.booking_id WHERE p.id = $1`,
+			req.PaymentID).Scan(&owner)
+		if err != nil || owner != userID {
+			writeError(w, r, http.StatusNotFound, "Платёж не найден")
+			return
+		}
+
+		payment, err := capturePayment(req.PaymentID)
+		if errors.Is(err, errHoldExpired) {
+			writeErrorCode(w, r, http.StatusGone, "hold_expired", "Время удержания истекло, выберите объект заново")
+			return
+		}
+		if errors.Is(err, errPaymentOutdated) {
+			writeErrorCode(w, r, http.StatusConflict, "payment_outdated", "Стоимость бронирования изменилась, оплатите его заново")
+			return
+		}
+		if err != nil {
+			log.Println("Ошибка списания платежа:", err)
+			writeErrorCode(w, r, http.StatusPaymentRequired, "payment_declined", "Не удалось списать оплату")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Оплата получена, бронирование подтверждено", map[string]interface{}{
+			"payment": payment,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос возврата; amount — сумма в валюте платежа
+type RefundRequest struct {
+	PaymentID int   `json:"payment_id" validate:"required"`
+	Amount    Money `json:"amount"`
+}
+
+// Проверка суммы возврата
+func (req RefundRequest) Validate() []FieldError {
+	var errs []FieldError
+	if req.Amount.Amount <= 0 {
+		errs = append(errs, FieldError{"amount.amount", "Сумма должна быть больше нуля"})
+	}
+	if _, ok := currencyExponents[req.Amount.Currency]; !ok {
+		errs = append(errs, FieldError{"amount.currency", "Неизвестная валюта"})
+	}
+	return errs
+}
+
+// Обработчик возврата оплаты администратором
+func adminRefundHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		var req RefundRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		payment, err := refundPayment(req.PaymentID, req.Amount)
+		if err != nil {
+			log.Println("Ошибка возврата платежа:", err)
+			writeErrorCode(w, r, http.StatusConflict, "refund_failed", "Не удалось выполнить возврат")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Возврат выполнен", map[string]interface{}{
+			"payment": payment,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Функция обработки асинхронного подтверждения списания от провайдера
+func applyCapturedWebhook(providerRef string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var paymentID, bookingID int
+	var status string
+	err = tx.QueryRow(`SELECT id, booking_id, status FROM payments WHERE provider = $1 AND provider_ref = $2 FOR UPDATE`,
+		paymentProvider.Name(), providerRef).Scan(&paymentID, &bookingID, &status)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status == paymentCaptured || status == paymentRefunded {
+		return nil
+	}
+
+	if _, err := tx.Exec(`UPDATE payments SET status = $1 WHERE id = $2`, paymentCaptured, paymentID); err != nil {
+		return err
+	}
+	// Бронирование могли отменить, пока шло списание — тогда статус не меняется
+	if err := transitionBooking(tx, bookingID, bookingConfirmed); err != nil && !errors.Is(err, errInvalidTransition) {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Функция учёта возврата, о котором сообщил провайдер. total — общая сумма
+// возвратов по платежу у провайдера, поэтому повтор события или уведомление
+// о возврате, уже записанном refundPayment, ничего не меняют. Статус refunded
+// ставится, только когда возвращена вся оплата
+func applyRefundedWebhook(providerRef string, total Money) error {
+	if total.Amount <= 0 {
+		log.Println("Webhook возврата без суммы:", providerRef)
+		return nil
+	}
+	_, err := db.Exec(`UPDATE payments SET refunded_amount = GREATEST(refunded_amount, LEAST(amount, $1)),
+			status = CASE WHEN $1 >= amount THEN $2 ELSE status END
+		WHERE provider = $3 AND provider_ref = $4 AND currency = $5 AND status IN ($6, $2)`,
+		total.Amount, paymentRefunded, paymentProvider.Name(), providerRef, total.Currency, paymentCaptured)
+	return err
+}
+
+// Обработчик уведомлений от платёжного провайдера
+func handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		event, err := paymentProvider.ParseWebhook(r)
+		if err != nil {
+			log.Println("Отклонён webhook:", err)
+			writeError(w, r, http.StatusBadRequest, "Некорректная подпись")
+			return
+		}
+
+		switch event.Type {
+		case "payment.captured":
+			err = applyCapturedWebhook(event.ProviderRef)
+		case "payment.refunded":
+			err = applyRefundedWebhook(event.ProviderRef, event.RefundedAmount)
+		case "payment.failed":
+			_, err = db.Exec(`UPDATE payments SET status = $1 WHERE provider = $2 AND provider_ref = $3 AND status = $4`,
+				paymentFailed, paymentProvider.Name(), event.ProviderRef, paymentAuthorized)
+		default:
+			log.Println("Неизвестный тип события:", event.Type)
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
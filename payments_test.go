@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест полного цикла платежа в песочнице: авторизация, списание, частичный и полный возврат
+func TestSandboxProviderLifecycle(t *testing.T) {
+	p := newSandboxProvider("secret")
+
+	auth, err := p.Authorize(AuthorizeRequest{Amount: major(100, "USD"), PaymentToken: "tok_visa", IdempotencyKey: "key-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, paymentAuthorized, auth.Status)
+
+	// Повтор с тем же ключом не создаёт второй платёж
+	again, err := p.Authorize(AuthorizeRequest{Amount: major(100, "USD"), PaymentToken: "tok_visa", IdempotencyKey: "key-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, auth.ProviderRef, again.ProviderRef)
+
+	_, err = p.Capture(auth.ProviderRef, major(100, "USD"))
+	assert.NoError(t, err)
+
+	res, err := p.Refund(auth.ProviderRef, major(40, "USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, paymentCaptured, res.Status)
+
+	_, err = p.Refund(auth.ProviderRef, major(70, "USD"))
+	assert.ErrorIs(t, err, errRefundTooLarge)
+
+	res, err = p.Refund(auth.ProviderRef, major(60, "USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, paymentRefunded, res.Status)
+}
+
+// Тест отказа банка
+func TestSandboxProviderDeclined(t *testing.T) {
+	p := newSandboxProvider("secret")
+	_, err := p.Authorize(AuthorizeRequest{Amount: major(10, "USD"), PaymentToken: "tok_declined"})
+	assert.ErrorIs(t, err, errPaymentDeclined)
+}
+
+// Тест проверки подписи webhook
+func TestSandboxProviderWebhookSignature(t *testing.T) {
+	p := newSandboxProvider("secret")
+	body := `{"type":"payment.captured","provider_ref":"sb_000001"}`
+
+	req := httptest.NewRequest("POST", "/payments/webhook", strings.NewReader(body))
+	req.Header.Set("X-Sandbox-Signature", p.Sign([]byte(body)))
+	event, err := p.ParseWebhook(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "sb_000001", event.ProviderRef)
+
+	req = httptest.NewRequest("POST", "/payments/webhook", strings.NewReader(body))
+	req.Header.Set("X-Sandbox-Signature", newSandboxProvider("other").Sign([]byte(body)))
+	_, err = p.ParseWebhook(req)
+	assert.ErrorIs(t, err, errInvalidWebhook)
+}
+
+// Тест: ключ идемпотентности провайдера различается для пользователей, маршрутов и бронирований
+func TestProviderIdempotencyKey(t *testing.T) {
+	key := providerIdempotencyKey(1, "/payments/authorize", 10, "k")
+	assert.Equal(t, "1:/payments/authorize:10:k", key)
+	assert.NotEqual(t, key, providerIdempotencyKey(2, "/payments/authorize", 10, "k"))
+	assert.NotEqual(t, key, providerIdempotencyKey(1, "/holds/checkout", 10, "k"))
+	assert.NotEqual(t, key, providerIdempotencyKey(1, "/payments/authorize", 11, "k"))
+	assert.Empty(t, providerIdempotencyKey(1, "/payments/authorize", 10, ""))
+}
+
+// Тест: webhook возврата записывает общую сумму возвратов, повтор события её не удваивает
+func TestPaymentWebhookPartialRefund(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	sandbox := newSandboxProvider("secret")
+	defer func(prev PaymentProvider) { paymentProvider = prev }(paymentProvider)
+	paymentProvider = sandbox
+
+	// Повтор события с той же общей суммой возврата не увеличивает её
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec("UPDATE payments SET refunded_amount = GREATEST\\(refunded_amount, LEAST\\(amount, \\$1\\)\\)").
+			WithArgs(int64(4000), paymentRefunded, "sandbox", "sb_000001", "USD", paymentCaptured).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	body := `{"type":"payment.refunded","provider_ref":"sb_000001","amount":{"amount":1000,"currency":"USD"},"refunded_amount":{"amount":4000,"currency":"USD"}}`
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/payments/webhook", strings.NewReader(body))
+		req.Header.Set("X-Sandbox-Signature", sandbox.Sign([]byte(body)))
+		rr := httptest.NewRecorder()
+		handlePaymentWebhook(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: возврат без суммы или с неизвестной валютой отклоняется с ошибками по полям
+func TestAdminRefundValidation(t *testing.T) {
+	rr := httptest.NewRecorder()
+	body := `{"payment_id": 3, "amount": {"amount": 0, "currency": "XXX"}}`
+	adminRefundHandler(rr, httptest.NewRequest("POST", "/admin/payments/refund", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"validation_failed"`)
+	assert.Contains(t, rr.Body.String(), `"field":"amount.amount"`)
+	assert.Contains(t, rr.Body.String(), `"field":"amount.currency"`)
+}
+
+// Тест: неоплаченное бронирование с авторизованным платежом нельзя изменить до списания,
+// поэтому списывается авторизованная сумма; авторизация на устаревшую сумму отменяется
+func TestModifyThenCaptureAuthorizedBooking(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	sandbox := newSandboxProvider("secret")
+	defer func(prev PaymentProvider) { paymentProvider = prev }(paymentProvider)
+	paymentProvider = sandbox
+	auth, err := sandbox.Authorize(AuthorizeRequest{Amount: major(200, "USD"), PaymentToken: "tok_visa"})
+	assert.NoError(t, err)
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT item_type, item_id, start_date, end_date, status, total_price").
+		WithArgs(9, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"item_type", "item_id", "start_date", "end_date", "status", "total_price", "currency",
+			"pickup_branch_id", "dropoff_branch_id", "package_discount", "trip_id", "expired"}).
+			AddRow("room", 1, date("2025-07-01"), date("2025-07-03"), bookingPending, int64(20000), "USD", nil, nil, int64(0), nil, false))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM payments WHERE booking_id = \\$1 AND status = \\$2\\)").
+		WithArgs(9, paymentAuthorized).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	_, err = modifyBooking(3, ModifyBookingRequest{BookingID: 9, StartDate: "2025-07-01", EndDate: "2025-07-05"}, "", now)
+	assert.ErrorIs(t, err, errPaymentInProgress)
+
+	paymentRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "booking_id", "provider", "provider_ref", "status", "amount", "refunded_amount", "currency"}).
+			AddRow(5, 9, "sandbox", auth.ProviderRef, paymentAuthorized, int64(20000), int64(0), "USD")
+	}
+
+	// Цена не менялась — списывается авторизованная сумма
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, booking_id, provider, provider_ref").WithArgs(5).WillReturnRows(paymentRow())
+	mock.ExpectQuery("SELECT total_price, currency FROM bookings").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"total_price", "currency"}).AddRow(int64(20000), "USD"))
+	mock.ExpectQuery("SELECT status, COALESCE\\(expires_at <= NOW\\(\\), false\\) FROM bookings").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "expired"}).AddRow(bookingPending, false))
+	mock.ExpectExec("UPDATE bookings SET status").WithArgs(bookingConfirmed, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE payments SET status").WithArgs(paymentCaptured, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	payment, err := capturePayment(5)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentCaptured, payment.Status)
+	assert.Equal(t, major(200, "USD"), payment.Amount)
+
+	// Цена изменилась после авторизации — денег не списываем
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, booking_id, provider, provider_ref").WithArgs(5).WillReturnRows(paymentRow())
+	mock.ExpectQuery("SELECT total_price, currency FROM bookings").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"total_price", "currency"}).AddRow(int64(25000), "USD"))
+	mock.ExpectExec("UPDATE payments SET status").WithArgs(paymentFailed, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err = capturePayment(5)
+	assert.ErrorIs(t, err, errPaymentOutdated)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
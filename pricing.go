@@ -0,0 +1,381 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Сезон с надбавкой или скидкой к базовому тарифу. Границы задаются как месяц и день,
+// поэтому правило повторяется каждый год; сезон может переходить через Новый год
+type SeasonRate struct {
+	Name      string
+	FromMonth time.Month
+	FromDay   int
+	ToMonth   time.Month
+	ToDay     int
+	Percent   int
+}
+
+// Скидка за длительность аренды или проживания
+type LengthDiscount struct {
+	MinDays int
+	Percent int
+}
+
+// Надбавка при высокой загрузке однотипных объектов
+type OccupancySurcharge struct {
+	MinOccupancy float64
+	Percent      int
+}
+
+// Набор правил ценообразования для одного вида объектов
+type PricingRules struct {
+	Seasons             []SeasonRate
+	WeekendPercent      int // Надбавка за ночь с пятницы на субботу и с субботы на воскресенье
+	WeekdayPercent      int // Надбавка (или скидка) за остальные дни
+	LengthDiscounts     []LengthDiscount
+	LastMinuteDays      int // Бронирование менее чем за столько дней до начала
+	LastMinutePercent   int
+	EarlyBirdDays       int // Бронирование не менее чем за столько дней до начала
+	EarlyBirdPercent    int
+	OccupancySurcharges []OccupancySurcharge
+}
+
+var carPricingRules = PricingRules{
+	Seasons: []SeasonRate{
+		{"Летний сезон", time.June, 1, time.August, 31, 20},
+		{"Новогодние праздники", time.December, 25, time.January, 8, 30},
+	},
+	WeekendPercent:    15,
+	LengthDiscounts:   []LengthDiscount{{7, 10}, {30, 25}},
+	LastMinuteDays:    2,
+	LastMinutePercent: 10,
+	EarlyBirdDays:     60,
+	EarlyBirdPercent:  -10,
+	OccupancySurcharges: []OccupancySurcharge{
+		{0.7, 10},
+		{0.9, 25},
+	},
+}
+
+var roomPricingRules = PricingRules{
+	Seasons: []SeasonRate{
+		{"Летний сезон", time.June, 15, time.August, 31, 25},
+		{"Новогодние праздники", time.December, 28, time.January, 5, 40},
+	},
+	WeekendPercent:    20,
+	WeekdayPercent:    -5,
+	LengthDiscounts:   []LengthDiscount{{5, 5}, {14, 15}},
+	LastMinuteDays:    1,
+	LastMinutePercent: -15,
+	EarlyBirdDays:     90,
+	EarlyBirdPercent:  -10,
+	OccupancySurcharges: []OccupancySurcharge{
+		{0.8, 15},
+	},
+}
+
+// Строка расчёта цены
+type QuoteLine struct {
+	Description string `json:"description"`
+	Amount      Money  `json:"amount"`
+}
+
+// Расчёт стоимости объекта за период
+type Quote struct {
+	ItemType  string      `json:"item_type"`
+	ItemID    int         `json:"item_id"`
+	StartDate string      `json:"start_date"`
+	EndDate   string      `json:"end_date"`
+	Days      int         `json:"days"`
+	Lines     []QuoteLine `json:"lines"`
+	Total     Money       `json:"total"`
+	BaseTotal *Money      `json:"base_total,omitempty"` // Итог в валюте объекта, если расчёт пересчитан
+
+	texts []quoteText // Ключи каталога для описаний строк Lines, по порядку
+}
+
+// Описание строки расчёта до перевода: ключ каталога и аргументы шаблона
+type quoteText struct {
+	key  string
+	args []interface{}
+}
+
+// Функция добавления строки расчёта. Описание сохраняется по-русски, а ключ
+// и аргументы — отдельно, чтобы обработчик перевёл строку на язык запроса
+func (q *Quote) addLine(amount Money, key string, args ...interface{}) {
+	q.Lines = append(q.Lines, QuoteLine{translate(defaultLocale, key, args...), amount})
+	q.texts = append(q.texts, quoteText{key, args})
+	q.Total.Amount += amount.Amount
+}
+
+// Функция перевода описаний строк на язык запроса. Строковые аргументы —
+// названия сезонов — тоже ключи каталога
+func (q Quote) Localized(r *http.Request) Quote {
+	localized := q
+	localized.Lines = append([]QuoteLine(nil), q.Lines...)
+	for i, text := range q.texts {
+		args := make([]interface{}, len(text.args))
+		for j, arg := range text.args {
+			if s, ok := arg.(string); ok {
+				arg = localize(r, s)
+			}
+			args[j] = arg
+		}
+		localized.Lines[i].Description = localize(r, text.key, args...)
+	}
+	return localized
+}
+
+// Входные данные расчёта. Все величины, зависящие от времени и загрузки,
+// передаются явно, чтобы один и тот же запрос всегда давал одинаковую цену
+type PricingInput struct {
+	BasePrice Money // Цена за сутки или ночь
+	Start     time.Time
+	End       time.Time
+	BookedAt  time.Time // Дата оформления, от неё считаются last-minute и early-bird
+	Occupancy float64   // Доля занятых однотипных объектов в периоде, от 0 до 1
+}
+
+// Проверка, попадает ли день в сезон
+func (s SeasonRate) contains(day time.Time) bool {
+	md := int(day.Month())*100 + day.Day()
+	from := int(s.FromMonth)*100 + s.FromDay
+	to := int(s.ToMonth)*100 + s.ToDay
+	if from <= to {
+		return md >= from && md <= to
+	}
+	return md >= from || md <= to
+}
+
+// Функция расчёта цены по правилам. Посуточные надбавки (сезон, выходные)
+// начисляются на базовый тариф, затем к промежуточному итогу применяются
+// скидка за длительность, last-minute, early-bird и надбавка за загрузку
+func (rules PricingRules) Quote(in PricingInput) Quote {
+	days := rentalDays(in.Start, in.End)
+	quote := Quote{
+		StartDate: in.Start.Format(dateLayout),
+		EndDate:   in.End.Format(dateLayout),
+		Days:      days,
+		Total:     Money{Currency: in.BasePrice.Currency},
+	}
+	add := func(amount Money, key string, args ...interface{}) {
+		if amount.Amount != 0 {
+			quote.addLine(amount, key, args...)
+		}
+	}
+
+	add(in.BasePrice.Mul(days), "Базовый тариф: %d × %s", days, in.BasePrice)
+
+	// Посуточные надбавки
+	seasonDays := make([]int, len(rules.Seasons))
+	weekendDays, weekdayDays := 0, 0
+	for d := in.Start; d.Before(in.End); d = d.AddDate(0, 0, 1) {
+		for i, s := range rules.Seasons {
+			if s.contains(d) {
+				seasonDays[i]++
+				break
+			}
+		}
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			weekendDays++
+		} else {
+			weekdayDays++
+		}
+	}
+	for i, s := range rules.Seasons {
+		if seasonDays[i] > 0 {
+			add(in.BasePrice.Percent(s.Percent).Mul(seasonDays[i]), "%s (%+d%%): %d сут.", s.Name, s.Percent, seasonDays[i])
+		}
+	}
+	if rules.WeekendPercent != 0 && weekendDays > 0 {
+		add(in.BasePrice.Percent(rules.WeekendPercent).Mul(weekendDays), "Выходные дни (%+d%%): %d сут.", rules.WeekendPercent, weekendDays)
+	}
+	if rules.WeekdayPercent != 0 && weekdayDays > 0 {
+		add(in.BasePrice.Percent(rules.WeekdayPercent).Mul(weekdayDays), "Будние дни (%+d%%): %d сут.", rules.WeekdayPercent, weekdayDays)
+	}
+
+	// Правила от промежуточного итога
+	subtotal := quote.Total
+
+	lengthPercent := 0
+	for _, ld := range rules.LengthDiscounts {
+		if days >= ld.MinDays && ld.Percent > lengthPercent {
+			lengthPercent = ld.Percent
+		}
+	}
+	if lengthPercent > 0 {
+		add(subtotal.Percent(-lengthPercent), "Скидка за длительность (-%d%%)", lengthPercent)
+	}
+
+	daysAhead := int(in.Start.Sub(in.BookedAt.Truncate(24*time.Hour)).Hours() / 24)
+	if rules.LastMinuteDays > 0 && daysAhead < rules.LastMinuteDays {
+		add(subtotal.Percent(rules.LastMinutePercent), "Бронирование в последний момент (%+d%%)", rules.LastMinutePercent)
+	} else if rules.EarlyBirdDays > 0 && daysAhead >= rules.EarlyBirdDays {
+		add(subtotal.Percent(rules.EarlyBirdPercent), "Раннее бронирование (%+d%%)", rules.EarlyBirdPercent)
+	}
+
+	occupancyPercent := 0
+	for _, oc := range rules.OccupancySurcharges {
+		if in.Occupancy >= oc.MinOccupancy && oc.Percent > occupancyPercent {
+			occupancyPercent = oc.Percent
+		}
+	}
+	if occupancyPercent > 0 {
+		add(subtotal.Percent(occupancyPercent), "Высокий спрос (+%d%%)", occupancyPercent)
+	}
+
+	return quote
+}
+
+// Функция пересчёта расчёта в другую валюту для отображения. Каждая строка
+// пересчитывается и округляется отдельно, итог — сумма пересчитанных строк,
+// поэтому строки всегда сходятся с итогом
+func (q Quote) Convert(currency string) (Quote, error) {
+	if currency == "" || currency == q.Total.Currency {
+		return q, nil
+	}
+
+	converted := q
+	converted.Lines = make([]QuoteLine, len(q.Lines))
+	converted.Total = Money{Currency: currency}
+	for i, line := range q.Lines {
+		amount, err := convertMoney(line.Amount, currency)
+		if err != nil {
+			return Quote{}, err
+		}
+		converted.Lines[i] = QuoteLine{line.Description, amount}
+		converted.Total.Amount += amount.Amount
+	}
+	baseTotal := q.Total
+	converted.BaseTotal = &baseTotal
+	return converted, nil
+}
+
+// Доля занятых объектов группы
+func occupancyRate(group []int, busy map[int]bool) float64 {
+	if len(group) == 0 {
+		return 0
+	}
+	taken := 0
+	for _, id := range group {
+		if busy[id] {
+			taken++
+		}
+	}
+	return float64(taken) / float64(len(group))
+}
+
+// Функция расчёта цены автомобиля. Загрузка считается по автомобилям той же категории
+func quoteCar(car Car, start, end, bookedAt time.Time, busy map[int]bool) Quote {
+	var group []int
+	catalogMu.RLock()
+	for _, c := range cars {
+		if c.Category == car.Category {
+			group = append(group, c.ID)
+		}
+	}
+	catalogMu.RUnlock()
+
+	quote := carPricingRules.Quote(PricingInput{
+		BasePrice: car.Price,
+		Start:     start,
+		End:       end,
+		BookedAt:  bookedAt,
+		Occupancy: occupancyRate(group, busy),
+	})
+	quote.ItemType = "car"
+	quote.ItemID = car.ID
+	return quote
+}
+
+// Функция расчёта цены номера. Загрузка считается по номерам того же отеля
+func quoteRoom(room Room, start, end, bookedAt time.Time, busy map[int]bool) Quote {
+	var group []int
+	catalogMu.RLock()
+	for _, r := range rooms {
+		if r.Hotel == room.Hotel {
+			group = append(group, r.ID)
+		}
+	}
+	catalogMu.RUnlock()
+
+	quote := roomPricingRules.Quote(PricingInput{
+		BasePrice: room.Price,
+		Start:     start,
+		End:       end,
+		BookedAt:  bookedAt,
+		Occupancy: occupancyRate(group, busy),
+	})
+	quote.ItemType = "room"
+	quote.ItemID = room.ID
+	return quote
+}
+
+// Функция расчёта цены любого объекта каталога на текущую дату
+func quoteItem(itemType string, itemID int, start, end time.Time) (Quote, bool, error) {
+	busy, err := busyItems(itemType, start, end)
+	if err != nil {
+		return Quote{}, false, err
+	}
+
+	switch itemType {
+	case "car":
+		car, ok := findCar(itemID)
+		if !ok {
+			return Quote{}, false, nil
+		}
+		return quoteCar(car, start, end, time.Now(), busy), true, nil
+	case "room":
+		room, ok := findRoom(itemID)
+		if !ok {
+			return Quote{}, false, nil
+		}
+		return quoteRoom(room, start, end, time.Now(), busy), true, nil
+	}
+	return Quote{}, false, nil
+}
+
+// Обработчик расчёта стоимости: /quote?type=car&id=3&from=2025-06-01&to=2025-06-05&currency=KZT
+func handleQuote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		itemType := r.URL.Query().Get("type")
+		itemID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Некорректный идентификатор")
+			return
+		}
+
+		start, end, err := parseDateRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		}
+
+		quote, ok, err := quoteItem(itemType, itemID, start, end)
+		if err != nil {
+			log.Println("Ошибка расчёта стоимости:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "Объект не найден")
+			return
+		}
+
+		quote, err = quote.Convert(r.URL.Query().Get("currency"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Конвертация в эту валюту недоступна")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", quote.Localized(r))
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
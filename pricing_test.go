@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse(dateLayout, s)
+	return d
+}
+
+// Тест расчёта цены: летний сезон, выходные и надбавка за загрузку
+func TestPricingRulesQuoteSeasonWeekendOccupancy(t *testing.T) {
+	quote := carPricingRules.Quote(PricingInput{
+		BasePrice: major(100, "USD"),
+		Start:     date("2025-06-06"), // пятница
+		End:       date("2025-06-09"),
+		BookedAt:  date("2025-05-01"),
+		Occupancy: 0.75,
+	})
+
+	assert.Equal(t, 3, quote.Days)
+	assert.Equal(t, []QuoteLine{
+		{"Базовый тариф: 3 × 100.00 USD", Money{30000, "USD"}},
+		{"Летний сезон (+20%): 3 сут.", Money{6000, "USD"}},
+		{"Выходные дни (+15%): 2 сут.", Money{3000, "USD"}},
+		{"Высокий спрос (+10%)", Money{3900, "USD"}},
+	}, quote.Lines)
+	assert.Equal(t, Money{42900, "USD"}, quote.Total)
+}
+
+// Тест расчёта цены: скидка за неделю аренды и бронирование в последний момент
+func TestPricingRulesQuoteLengthAndLastMinute(t *testing.T) {
+	quote := carPricingRules.Quote(PricingInput{
+		BasePrice: major(50, "USD"),
+		Start:     date("2025-03-03"),
+		End:       date("2025-03-10"),
+		BookedAt:  date("2025-03-02").Add(15 * time.Hour),
+	})
+
+	assert.Equal(t, []QuoteLine{
+		{"Базовый тариф: 7 × 50.00 USD", Money{35000, "USD"}},
+		{"Выходные дни (+15%): 2 сут.", Money{1500, "USD"}},
+		{"Скидка за длительность (-10%)", Money{-3650, "USD"}},
+		{"Бронирование в последний момент (+10%)", Money{3650, "USD"}},
+	}, quote.Lines)
+	assert.Equal(t, Money{36500, "USD"}, quote.Total)
+
+	// Повторный расчёт с теми же данными даёт ту же цену
+	again := carPricingRules.Quote(PricingInput{
+		BasePrice: major(50, "USD"),
+		Start:     date("2025-03-03"),
+		End:       date("2025-03-10"),
+		BookedAt:  date("2025-03-02").Add(15 * time.Hour),
+	})
+	assert.Equal(t, quote, again)
+}
+
+// Тест сезона, переходящего через Новый год
+func TestSeasonRateContainsAcrossNewYear(t *testing.T) {
+	season := SeasonRate{"Новогодние праздники", time.December, 25, time.January, 8, 30}
+	assert.True(t, season.contains(date("2025-12-30")))
+	assert.True(t, season.contains(date("2026-01-08")))
+	assert.False(t, season.contains(date("2026-01-09")))
+	assert.False(t, season.contains(date("2025-12-24")))
+}
+
+// Тест: строки расчёта переводятся на язык запроса вместе с названием сезона
+func TestQuoteLocalized(t *testing.T) {
+	quote, err := carPricingRules.Quote(PricingInput{
+		BasePrice: major(100, "USD"),
+		Start:     date("2025-06-06"),
+		End:       date("2025-06-09"),
+		BookedAt:  date("2025-05-01"),
+	}).Convert("USD")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/quote", nil)
+	req.Header.Set("Accept-Language", "en")
+	localized := quote.Localized(req)
+
+	assert.Equal(t, "Base rate: 3 × 100.00 USD", localized.Lines[0].Description)
+	assert.Equal(t, "Summer season (+20%): 3 days", localized.Lines[1].Description)
+	assert.Equal(t, "Weekend days (+15%): 2 days", localized.Lines[2].Description)
+	assert.Equal(t, "Базовый тариф: 3 × 100.00 USD", quote.Lines[0].Description, "исходный расчёт не меняется")
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/lib/pq"
+)
+
+const (
+	avatarsDir    = uploadsDir + "/avatars"
+	maxAvatarSize = 5 << 20
+)
+
+var (
+	errWrongPassword     = errors.New("неверный пароль")
+	errSameEmail         = errors.New("новый email совпадает с текущим")
+	errEmailTaken        = errors.New("email уже занят")
+	errEmailTokenInvalid = errors.New("некорректный или устаревший токен смены email")
+	errHasActiveBookings = errors.New("есть оплаченные бронирования")
+)
+
+// Профиль пользователя. pending_email — новый адрес, ожидающий подтверждения
+type Profile struct {
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	PendingEmail string `json:"pending_email,omitempty"`
+	Phone        string `json:"phone"`
+	Locale       string `json:"locale"`
+	Currency     string `json:"currency"`
+	AvatarURL    string `json:"avatar_url,omitempty"`
+	TwoFactor    bool   `json:"two_factor_enabled"`
+}
+
+// Изменяемые поля профиля. Пустые телефон, язык и валюта сбрасывают значение
+type ProfileUpdate struct {
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	LastName  string `json:"last_name" validate:"required,max=100"`
+	Phone     string `json:"phone" validate:"phone"`
+	Locale    string `json:"locale" validate:"oneof=ru|en|kk"`
+	Currency  string `json:"currency" validate:"oneof=USD|EUR|KZT|RUB"`
+}
+
+// Функция загрузки профиля пользователя
+func loadProfile(userID int) (Profile, error) {
+	var p Profile
+	var avatar string
+	err := db.QueryRow(`SELECT first_name, last_name, email, COALESCE(pending_email, ''), COALESCE(phone, ''),
+			COALESCE(locale, ''), COALESCE(currency, ''), COALESCE(avatar, ''), totp_enabled
+		FROM users WHERE id = $1`, userID).
+		Scan(&p.FirstName, &p.LastName, &p.Email, &p.PendingEmail, &p.Phone, &p.Locale, &p.Currency, &avatar, &p.TwoFactor)
+	if avatar != "" {
+		p.AvatarURL = "/avatars/" + avatar
+	}
+	return p, err
+}
+
+// Функция проверки текущего пароля перед чувствительными действиями
+func checkPassword(q dbtx, userID int, password string) error {
+	var stored string
+	if err := q.QueryRow(`SELECT password FROM users WHERE id = $1`, userID).Scan(&stored); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+		return errWrongPassword
+	}
+	return nil
+}
+
+// Функция запроса смены email. Адрес меняется только после перехода по ссылке
+// из письма на новый адрес. Если адрес занят другим аккаунтом, письмо не
+// отправляется, а ответ остаётся тем же, чтобы по нему нельзя было проверить email
+func requestEmailChange(userID int, newEmail, password, locale string) error {
+	if err := checkPassword(db, userID, password); err != nil {
+		return err
+	}
+
+	var current string
+	var taken bool
+	err := db.QueryRow(`SELECT lower(email), EXISTS (SELECT 1 FROM users WHERE lower(email) = $2) FROM users WHERE id = $1`,
+		userID, newEmail).Scan(&current, &taken)
+	if err != nil {
+		return err
+	}
+	if current == newEmail {
+		return errSameEmail
+	}
+	if taken {
+		return nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE users SET pending_email = $1, email_change_token = $2, email_change_sent_at = NOW() WHERE id = $3`,
+		newEmail, hashToken(token), userID)
+	if err != nil {
+		return err
+	}
+
+	subject, body := renderEmail(locale, "email_change", appBaseURL, token, int(confirmationLifetime.Hours()))
+	return emailSender.SendEmail([]string{newEmail}, subject, body)
+}
+
+// Функция подтверждения смены email по токену из письма
+func confirmEmailChange(token string) error {
+	var userID int
+	err := db.QueryRow(`UPDATE users SET email = pending_email, pending_email = NULL, email_change_token = NULL
+		WHERE email_change_token = $1 AND email_change_sent_at > NOW() - make_interval(secs => $2)
+		RETURNING id`, hashToken(token), confirmationLifetime.Seconds()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return errEmailTokenInvalid
+	}
+	// Адрес мог занять другой пользователь, пока письмо ждало подтверждения
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return errEmailTaken
+	}
+	return err
+}
+
+// Функция удаления аккаунта. Оплаченные будущие бронирования нужно сначала
+// отменить, неоплаченные отменяются автоматически. Бронирования остаются
+// в отчётности без привязки к пользователю, сообщения чата теряют автора
+// (ON DELETE SET NULL); сессии, удержания, лист ожидания, поездки, обращения
+// в поддержку с вложениями и архивы выгрузки данных удаляются.
+// Возвращает освободившиеся объекты для листа ожидания
+func deleteAccount(userID int, password string) ([]itemRef, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkPassword(tx, userID, password); err != nil {
+		return nil, err
+	}
+
+	var active bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM bookings b WHERE b.user_id = $1 AND (
+			(b.status IN ($2, $3) AND b.end_date >= CURRENT_DATE)
+			OR (b.status = $4 AND EXISTS (SELECT 1 FROM payments p WHERE p.booking_id = b.id AND p.status = $5))
+		))`, userID, bookingConfirmed, bookingCheckedIn, bookingPending, paymentAuthorized).Scan(&active)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, errHasActiveBookings
+	}
+
+	rows, err := tx.Query(`UPDATE bookings SET status = $1, updated_at = NOW() WHERE user_id = $2 AND status = $3
+		RETURNING item_type, item_id`, bookingCancelled, userID, bookingPending)
+	if err != nil {
+		return nil, err
+	}
+	freed, err := collectItemRefs(rows)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = tx.Query(`DELETE FROM holds WHERE user_id = $1 RETURNING item_type, item_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	released, err := collectItemRefs(rows)
+	if err != nil {
+		return nil, err
+	}
+	freed = append(freed, released...)
+
+	if _, err := tx.Exec(`UPDATE bookings SET user_id = NULL, trip_id = NULL WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	// В сохранённых ответах идемпотентных запросов есть личные данные
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	// В обращениях остаются email и текст, поэтому они удаляются целиком,
+	// в том числе отправленные на этот email без входа в аккаунт
+	rows, err = tx.Query(`DELETE FROM support_messages
+		WHERE user_id = $1 OR lower(email) = (SELECT lower(email) FROM users WHERE id = $1)
+		RETURNING COALESCE(attachment, '')`, userID)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := collectNames(rows)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = tx.Query(`DELETE FROM data_exports WHERE user_id = $1 RETURNING COALESCE(file, '')`, userID)
+	if err != nil {
+		return nil, err
+	}
+	archives, err := collectNames(rows)
+	if err != nil {
+		return nil, err
+	}
+	var avatar string
+	if err := tx.QueryRow(`DELETE FROM users WHERE id = $1 RETURNING COALESCE(avatar, '')`, userID).Scan(&avatar); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	removeAvatar(avatar)
+	for _, name := range attachments {
+		removeAttachment(name)
+	}
+	for _, name := range archives {
+		removeExportFile(name)
+	}
+	return freed, nil
+}
+
+// Функция чтения имён файлов из результата запроса
+func collectNames(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Функция удаления файла вложения обращения в поддержку
+func removeAttachment(name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(uploadsDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Ошибка удаления вложения %s: %v", name, err)
+	}
+}
+
+// Функция удаления файла аватара
+func removeAvatar(name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(avatarsDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Ошибка удаления аватара %s: %v", name, err)
+	}
+}
+
+// Обработчик просмотра (GET) и изменения (POST) профиля текущего пользователя
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		profile, err := loadProfile(userID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		writeSuccess(w, r, http.StatusOK, "", profile)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req ProfileUpdate
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		_, err = db.Exec(`UPDATE users SET first_name = $1, last_name = $2, phone = NULLIF($3, ''),
+			locale = NULLIF($4, ''), currency = NULLIF($5, '') WHERE id = $6`,
+			req.FirstName, req.LastName, req.Phone, req.Locale, req.Currency, userID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		// Новый язык применяется к следующим ответам; без выбора язык снова
+		// определяется по Accept-Language
+		if req.Locale != "" {
+			setLocaleCookie(w, req.Locale)
+		} else {
+			http.SetCookie(w, &http.Cookie{Name: localeCookieName, Path: "/", MaxAge: -1})
+		}
+
+		profile, err := loadProfile(userID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		writeSuccess(w, r, http.StatusOK, "Профиль обновлён", profile)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик загрузки аватара. Прежний файл удаляется после сохранения нового
+func handleProfileAvatar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize)
+		if err := r.ParseMultipartForm(maxAvatarSize); err != nil {
+			writeErrorCode(w, r, http.StatusBadRequest, "file_too_large", "Файл больше 5 МБ или повреждён")
+			return
+		}
+		filePath, err := saveUpload(r, "avatar", avatarsDir, "avatar", imageUploadTypes)
+		if errors.Is(err, errNoUpload) || errors.Is(err, errUnsupportedUpload) {
+			writeErrorCode(w, r, http.StatusBadRequest, "unsupported_file", "Аватар должен быть изображением JPEG, PNG или WebP")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка сохранения аватара: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Не удалось сохранить файл")
+			return
+		}
+
+		name := filepath.Base(filePath)
+		var previous string
+		err = db.QueryRow(`UPDATE users u SET avatar = $1
+			FROM (SELECT avatar FROM users WHERE id = $2 FOR UPDATE) old
+			WHERE u.id = $2 RETURNING COALESCE(old.avatar, '')`, name, userID).Scan(&previous)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			removeAvatar(name)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		removeAvatar(previous)
+
+		writeSuccess(w, r, http.StatusOK, "Аватар обновлён", map[string]interface{}{
+			"avatar_url": "/avatars/" + name,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик выдачи файлов аватаров. Имя файла берётся без каталогов
+func handleAvatar(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if name == "/" || name == "." {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, filepath.Join(avatarsDir, name))
+}
+
+// Запрос смены email
+type EmailChangeRequest struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,max=128"`
+}
+
+// Обработчик запроса смены email
+func handleProfileEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req EmailChangeRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		err = requestEmailChange(userID, normalizeEmail(req.Email), req.Password, requestLocale(r))
+		switch {
+		case errors.Is(err, errWrongPassword):
+			writeErrorCode(w, r, http.StatusForbidden, "wrong_password", "Неверный пароль")
+			return
+		case errors.Is(err, errSameEmail):
+			writeError(w, r, http.StatusBadRequest, "Это ваш текущий email")
+			return
+		case err != nil:
+			log.Printf("Ошибка смены email: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Мы отправили ссылку для подтверждения на новый адрес. До подтверждения действует прежний email", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик перехода по ссылке подтверждения нового email
+func handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			writeError(w, r, http.StatusBadRequest, "Токен не указан")
+			return
+		}
+
+		err := confirmEmailChange(token)
+		switch {
+		case errors.Is(err, errEmailTokenInvalid):
+			writeErrorCode(w, r, http.StatusBadRequest, "token_invalid", "Ссылка недействительна или устарела")
+			return
+		case errors.Is(err, errEmailTaken):
+			writeErrorCode(w, r, http.StatusConflict, "email_taken", "Этот email уже используется другим аккаунтом")
+			return
+		case err != nil:
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Email изменён", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Подтверждение опасного действия паролем
+type PasswordConfirmation struct {
+	Password string `json:"password" validate:"required,max=128"`
+}
+
+// Обработчик удаления аккаунта
+func handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req PasswordConfirmation
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		freed, err := deleteAccount(userID, req.Password)
+		switch {
+		case errors.Is(err, errWrongPassword):
+			writeErrorCode(w, r, http.StatusForbidden, "wrong_password", "Неверный пароль")
+			return
+		case errors.Is(err, errHasActiveBookings):
+			writeErrorCode(w, r, http.StatusConflict, "active_bookings", "Сначала отмените оплаченные бронирования")
+			return
+		case err != nil:
+			log.Printf("Ошибка удаления аккаунта #%d: %v", userID, err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		for _, item := range freed {
+			notifyWaitlist(item.Type, item.ID)
+		}
+		clearSessionCookie(w)
+		writeSuccess(w, r, http.StatusOK, "Аккаунт удалён", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест: аккаунт с оплаченным будущим бронированием не удаляется
+func TestDeleteAccountWithActiveBookings(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT password FROM users").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("secret"))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(7, bookingConfirmed, bookingCheckedIn, bookingPending, paymentAuthorized).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	freed, err := deleteAccount(7, "secret")
+
+	assert.ErrorIs(t, err, errHasActiveBookings)
+	assert.Empty(t, freed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: неверный пароль не позволяет удалить аккаунт
+func TestDeleteAccountWrongPassword(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT password FROM users").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("secret"))
+	mock.ExpectRollback()
+
+	_, err = deleteAccount(7, "guess")
+
+	assert.ErrorIs(t, err, errWrongPassword)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тест: при удалении аккаунта удаляются обращения в поддержку с его email
+func TestDeleteAccountRemovesSupportMessages(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT password FROM users").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("secret"))
+	mock.ExpectQuery("SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("UPDATE bookings SET status").
+		WillReturnRows(sqlmock.NewRows([]string{"item_type", "item_id"}))
+	mock.ExpectQuery("DELETE FROM holds").
+		WillReturnRows(sqlmock.NewRows([]string{"item_type", "item_id"}).AddRow("car", 3))
+	mock.ExpectExec("UPDATE bookings SET user_id = NULL").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM idempotency_keys").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("DELETE FROM support_messages\\s+WHERE user_id = \\$1 OR lower\\(email\\) = \\(SELECT lower\\(email\\) FROM users WHERE id = \\$1\\)").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"attachment"}).AddRow("").AddRow("attachment-missing.pdf"))
+	mock.ExpectQuery("DELETE FROM data_exports").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"file"}))
+	mock.ExpectQuery("DELETE FROM users").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"avatar"}).AddRow(""))
+	mock.ExpectCommit()
+
+	freed, err := deleteAccount(7, "secret")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []itemRef{{"car", 3}}, freed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
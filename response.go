@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// Единый формат JSON-ответа. status — success, fail (ошибка клиента) или error
+// (ошибка сервера); code — машиночитаемый код ошибки, по которому клиенты
+// выбирают реакцию, не разбирая текст message
+type Response struct {
+	Status    string       `json:"status"`
+	Code      string       `json:"code,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Data      interface{}  `json:"data,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// Входящий идентификатор принимается, только если он короткий и без спецсимволов,
+// чтобы его можно было безопасно писать в логи
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Коды ошибок по умолчанию для HTTP-статусов
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusPaymentRequired:     "payment_required",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusGone:                "gone",
+	http.StatusTooManyRequests:     "too_many_requests",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "bad_gateway",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// Промежуточный обработчик, присваивающий каждому запросу идентификатор.
+// Идентификатор возвращается в заголовке X-Request-ID и в теле JSON-ответов,
+// чтобы обращение в поддержку можно было сопоставить с записью в логе
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			token, err := randomToken()
+			if err != nil {
+				log.Printf("Ошибка генерации идентификатора запроса: %v", err)
+			}
+			id = token[:min(len(token), 16)]
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// Функция получения идентификатора текущего запроса
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// Функция отправки ответа в едином формате. Сообщение переводится на язык запроса
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, resp Response) {
+	locale := requestLocale(r)
+	resp.Message = translate(locale, resp.Message)
+	resp.RequestID = requestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", locale)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Ошибка кодирования ответа: %v", err)
+	}
+}
+
+// Функция успешного ответа с сообщением и необязательными данными
+func writeSuccess(w http.ResponseWriter, r *http.Request, status int, message string, data interface{}) {
+	writeJSON(w, r, status, Response{Status: "success", Message: message, Data: data})
+}
+
+// Функция ответа об ошибке с кодом по умолчанию для HTTP-статуса
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeErrorCode(w, r, status, errorCodes[status], message)
+}
+
+// Функция ответа об ошибке с явным машиночитаемым кодом
+func writeErrorCode(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	resp := Response{Status: "fail", Code: code, Message: message}
+	if status >= http.StatusInternalServerError {
+		resp.Status = "error"
+	}
+	writeJSON(w, r, status, resp)
+}
+
+// Функция ответа на запрос с неподдерживаемым методом
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "Метод не поддерживается")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест ответа об ошибке: JSON-тип, код и идентификатор запроса в заголовке и теле
+func TestWriteErrorEnvelope(t *testing.T) {
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, "Бронирование не найдено")
+	}))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/bookings/my", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "abc-123", rr.Header().Get("X-Request-ID"))
+	assert.JSONEq(t, `{"status":"fail","code":"not_found","message":"Бронирование не найдено","request_id":"abc-123"}`, rr.Body.String())
+}
+
+// Тест генерации идентификатора, если клиент не передал корректный
+func TestWithRequestIDGeneratesID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r)
+		writeErrorCode(w, r, http.StatusInternalServerError, "internal_error", "Ошибка базы данных")
+	}))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/trips", nil)
+	req.Header.Set("X-Request-ID", "bad id\n")
+	handler.ServeHTTP(rr, req)
+
+	assert.Len(t, seen, 16)
+	assert.Equal(t, seen, rr.Header().Get("X-Request-ID"))
+	assert.Contains(t, rr.Body.String(), `"status":"error"`)
+}
@@ -0,0 +1,36 @@
+package main
+
+// Номер в отеле. Каждая запись — один номер, который можно забронировать
+type Room struct {
+	ID       int     `json:"id"`
+	Hotel    string  `json:"hotel"`
+	Type     string  `json:"type"`
+	Price    Money   `json:"price"` // Цена за ночь в базовой валюте отеля
+	Rating   float64 `json:"rating"`
+	Capacity int     `json:"capacity"`
+	Policy   string  `json:"cancellation_policy"` // flexible, moderate или strict
+}
+
+var rooms = []Room{
+	{1, "Hotel California", "Standard", major(200, "USD"), 4.5, 2, "flexible"},
+	{2, "Hotel California", "Standard", major(200, "USD"), 4.5, 2, "flexible"},
+	{3, "Hotel California", "Suite", major(350, "USD"), 4.5, 4, "flexible"},
+	{4, "Grand Budapest", "Standard", major(150, "USD"), 4.8, 2, "moderate"},
+	{5, "Grand Budapest", "Deluxe", major(220, "USD"), 4.8, 3, "moderate"},
+	{6, "The Plaza", "Standard", major(300, "USD"), 4.7, 2, "moderate"},
+	{7, "The Plaza", "Suite", major(550, "USD"), 4.7, 4, "moderate"},
+	{8, "Ritz Carlton", "Deluxe", major(350, "USD"), 4.9, 2, "strict"},
+	{9, "Ritz Carlton", "Suite", major(700, "USD"), 4.9, 4, "strict"},
+}
+
+// Функция поиска номера по идентификатору
+func findRoom(id int) (Room, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for _, r := range rooms {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Room{}, false
+}
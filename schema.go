@@ -0,0 +1,293 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Таблицы, которые сервер создаёт сам при запуске.
+// Таблицы users и messages по-прежнему создаются вручную, здесь к ним только добавляются столбцы.
+var schemaStatements = []string{
+	// Бронирования автомобилей и номеров: item_type — "car" или "room",
+	// end_date не входит в период (день возврата свободен для следующей брони)
+	`CREATE TABLE IF NOT EXISTS bookings (
+		id SERIAL PRIMARY KEY,
+		item_type VARCHAR(16) NOT NULL,
+		item_id INTEGER NOT NULL,
+		user_id INTEGER REFERENCES users(id),
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		status VARCHAR(32) NOT NULL DEFAULT 'pending',
+		total_price INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		CHECK (end_date > start_date)
+	)`,
+	`CREATE INDEX IF NOT EXISTS bookings_item_idx ON bookings (item_type, item_id, start_date, end_date)`,
+
+	// Филиалы получения и возврата автомобиля
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS pickup_branch_id INTEGER`,
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS dropoff_branch_id INTEGER`,
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS one_way_fee INTEGER NOT NULL DEFAULT 0`,
+
+	// Суммы хранятся в минимальных единицах валюты. Старые записи были в целых долларах
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS currency CHAR(3)`,
+	`ALTER TABLE bookings ALTER COLUMN total_price TYPE BIGINT`,
+	`ALTER TABLE bookings ALTER COLUMN one_way_fee TYPE BIGINT`,
+	`UPDATE bookings SET total_price = total_price * 100, one_way_fee = one_way_fee * 100, currency = 'USD' WHERE currency IS NULL`,
+	`ALTER TABLE bookings ALTER COLUMN currency SET NOT NULL`,
+
+	// Жизненный цикл бронирования и сумма возврата при отмене
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW()`,
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS refund_amount BIGINT`,
+	// Состояние возврата refund_amount: pending, completed или failed
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS refund_status VARCHAR(16)`,
+	`CREATE INDEX IF NOT EXISTS bookings_user_idx ON bookings (user_id)`,
+
+	// Сессии пользователей, в таблице хранится только хеш токена из cookie
+	`CREATE TABLE IF NOT EXISTS sessions (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP NOT NULL
+	)`,
+
+	// Платежи по бронированиям. Суммы в минимальных единицах валюты
+	`CREATE TABLE IF NOT EXISTS payments (
+		id SERIAL PRIMARY KEY,
+		booking_id INTEGER NOT NULL REFERENCES bookings(id),
+		provider VARCHAR(32) NOT NULL,
+		provider_ref VARCHAR(128) NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		amount BIGINT NOT NULL,
+		refunded_amount BIGINT NOT NULL DEFAULT 0,
+		currency CHAR(3) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (provider, provider_ref)
+	)`,
+
+	// Ключи идемпотентности платёжных запросов и сохранённые ответы
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key VARCHAR(255) NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		status_code INTEGER,
+		response_body BYTEA,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (key, path, user_id)
+	)`,
+
+	// Email уникален без учёта регистра. Если в таблице уже есть дубликаты,
+	// миграция остановится, и их нужно объединить вручную
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email))`,
+
+	// Токен подтверждения email хранится в виде хеша; срок действия отсчитывается от времени отправки.
+	// Старые токены в открытом виде перестают подходить — такие пользователи запрашивают письмо повторно
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS confirmation_sent_at TIMESTAMP DEFAULT NOW()`,
+
+	// Язык интерфейса и писем, выбранный пользователем; NULL — определяется по запросу
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(5)`,
+
+	// Контакты и предпочтения из профиля, имя файла аватара в uploads/avatars
+	// и новый email, ожидающий подтверждения по ссылке
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS phone VARCHAR(20)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS currency CHAR(3)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar VARCHAR(255)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_change_token VARCHAR(64)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_change_sent_at TIMESTAMP`,
+
+	// Автор сообщения чата. При удалении аккаунта сообщения остаются без автора
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+
+	// Роль пользователя (user или admin) и двухфакторная аутентификация:
+	// секрет TOTP, признак включения и шаг последнего принятого кода, чтобы код нельзя было повторить
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0`,
+
+	// Неудачные попытки входа подряд и время, до которого вход заблокирован
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP`,
+
+	// Сессия, при входе в которую был пройден второй фактор
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE`,
+
+	// Одноразовые коды восстановления для входа без приложения-аутентификатора, хранятся хеши
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash VARCHAR(64) NOT NULL,
+		used_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes (user_id)`,
+
+	// Входы, ожидающие кода второго фактора после проверки пароля
+	`CREATE TABLE IF NOT EXISTS mfa_challenges (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+
+	// Учётные записи внешних OpenID Connect провайдеров, привязанные к пользователям
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		provider VARCHAR(64) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (provider, subject)
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id)`,
+
+	// Начатые входы через провайдера: state (хеш), nonce и code_verifier для PKCE
+	`CREATE TABLE IF NOT EXISTS oidc_logins (
+		state_hash VARCHAR(64) PRIMARY KEY,
+		provider VARCHAR(64) NOT NULL,
+		code_verifier VARCHAR(128) NOT NULL,
+		nonce VARCHAR(128) NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+
+	// Партнёры API и их ключи. Бронирования партнёра оформляются на привязанный аккаунт;
+	// в таблице ключей хранится только хеш, prefix — начало ключа для опознания
+	`CREATE TABLE IF NOT EXISTS partners (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		partner_id INTEGER NOT NULL REFERENCES partners(id) ON DELETE CASCADE,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) NOT NULL UNIQUE,
+		scope VARCHAR(16) NOT NULL,
+		rate_limit INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	)`,
+	// Число запросов по ключу за каждый день
+	`CREATE TABLE IF NOT EXISTS api_key_usage (
+		key_id INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		day DATE NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (key_id, day)
+	)`,
+
+	// Одноразовые токены сброса пароля, в таблице хранится только хеш
+	`CREATE TABLE IF NOT EXISTS password_resets (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP
+	)`,
+
+	// Временные удержания объектов на время оформления и оплаты
+	`CREATE TABLE IF NOT EXISTS holds (
+		id SERIAL PRIMARY KEY,
+		item_type VARCHAR(16) NOT NULL,
+		item_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		pickup_branch_id INTEGER,
+		dropoff_branch_id INTEGER,
+		total_price BIGINT NOT NULL,
+		one_way_fee BIGINT NOT NULL DEFAULT 0,
+		currency CHAR(3) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP NOT NULL,
+		CHECK (end_date > start_date)
+	)`,
+	`CREATE INDEX IF NOT EXISTS holds_item_idx ON holds (item_type, item_id, expires_at)`,
+
+	// Неоплаченное бронирование держит объект до expires_at, потом его отменяет
+	// фоновая задача. Старым неоплаченным бронированиям даётся сутки на оплату
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP`,
+	`UPDATE bookings SET expires_at = NOW() + INTERVAL '1 day' WHERE status = 'pending' AND expires_at IS NULL`,
+
+	// Лист ожидания на занятые даты. Когда объект освобождается, первому подходящему
+	// пользователю выдаётся удержание hold_id
+	`CREATE TABLE IF NOT EXISTS waitlist_entries (
+		id SERIAL PRIMARY KEY,
+		item_type VARCHAR(16) NOT NULL,
+		item_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'waiting',
+		hold_id INTEGER,
+		offered_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		CHECK (end_date > start_date)
+	)`,
+	`CREATE INDEX IF NOT EXISTS waitlist_entries_item_idx ON waitlist_entries (item_type, item_id, status, created_at)`,
+
+	// Поездки: несколько бронирований (номер и автомобиль), оплачиваемых вместе
+	`CREATE TABLE IF NOT EXISTS trips (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		currency CHAR(3) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS trip_id INTEGER REFERENCES trips(id)`,
+	`ALTER TABLE bookings ADD COLUMN IF NOT EXISTS package_discount BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS bookings_trip_idx ON bookings (trip_id)`,
+
+	// Обращения в поддержку. attachment — имя файла вложения в uploads;
+	// user_id заполняется, если обращение отправлено из аккаунта
+	`CREATE TABLE IF NOT EXISTS support_messages (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		email VARCHAR(255) NOT NULL,
+		message TEXT NOT NULL,
+		attachment VARCHAR(255),
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	// Таблица могла быть создана раньше без части колонок
+	`ALTER TABLE support_messages ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE support_messages ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE support_messages ADD COLUMN IF NOT EXISTS message TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE support_messages ADD COLUMN IF NOT EXISTS attachment VARCHAR(255)`,
+	`ALTER TABLE support_messages ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW()`,
+
+	// Выгрузки данных пользователя: архив в exports, ссылка на скачивание
+	// действует до expires_at, в таблице хранится только хеш токена
+	`CREATE TABLE IF NOT EXISTS data_exports (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status VARCHAR(16) NOT NULL,
+		file VARCHAR(255),
+		token_hash VARCHAR(64) UNIQUE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMP,
+		expires_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS data_exports_user_idx ON data_exports (user_id, created_at)`,
+
+	// Периоды обслуживания, когда автомобиль недоступен для аренды
+	`CREATE TABLE IF NOT EXISTS maintenance_blocks (
+		id SERIAL PRIMARY KEY,
+		item_type VARCHAR(16) NOT NULL,
+		item_id INTEGER NOT NULL,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		CHECK (end_date > start_date)
+	)`,
+}
+
+// Функция создания недостающих таблиц
+func migrate(db *sql.DB) error {
+	for _, stmt := range schemaStatements {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("миграция схемы: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	sessionCookieName = "session_token"
+	sessionLifetime   = 7 * 24 * time.Hour
+)
+
+var errNoSession = errors.New("сессия не найдена")
+
+// Функция генерации случайного токена из crypto/rand
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// В базе хранится только SHA-256 от токена, чтобы утечка таблицы не давала доступ к аккаунтам
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Функция создания сессии пользователя и установки cookie.
+// mfa отмечает, что при входе был пройден второй фактор
+func startSession(w http.ResponseWriter, userID int, mfa bool) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(sessionLifetime)
+	_, err = db.Exec(`INSERT INTO sessions (token_hash, user_id, expires_at, mfa) VALUES ($1, $2, $3, $4)`,
+		hashToken(token), userID, expiresAt, mfa)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Функция возвращает идентификатор пользователя текущей сессии.
+// Для запросов API партнёров это аккаунт партнёра, которому выдан ключ
+func currentUserID(r *http.Request) (int, error) {
+	if key, ok := apiKeyFromContext(r); ok {
+		return key.UserID, nil
+	}
+	userID, _, err := currentSession(r)
+	return userID, err
+}
+
+// Функция возвращает пользователя текущей сессии и признак пройденного второго фактора
+func currentSession(r *http.Request) (int, bool, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return 0, false, errNoSession
+	}
+
+	var userID int
+	var mfa bool
+	err = db.QueryRow(`SELECT user_id, mfa FROM sessions WHERE token_hash = $1 AND expires_at > NOW()`,
+		hashToken(cookie.Value)).Scan(&userID, &mfa)
+	if err != nil {
+		return 0, false, errNoSession
+	}
+	return userID, mfa, nil
+}
+
+// Функция удаления cookie сессии в браузере
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Обработчик выхода из аккаунта
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			db.Exec(`DELETE FROM sessions WHERE token_hash = $1`, hashToken(cookie.Value))
+		}
+
+		clearSessionCookie(w)
+		writeSuccess(w, r, http.StatusOK, "Вы вышли из аккаунта", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,45 @@
+// CSRF-токен для изменяющих запросов. Скрипт подключается первым на каждой
+// странице и добавляет заголовок X-CSRF-Token ко всем POST/PUT/DELETE-запросам
+// fetch на этот же сайт. Токен привязан к сессии и меняется после входа
+// и выхода, поэтому при ответе csrf_invalid он запрашивается заново, а запрос
+// повторяется один раз
+(function () {
+    const originalFetch = window.fetch.bind(window);
+    const safeMethods = ['GET', 'HEAD', 'OPTIONS'];
+    let tokenPromise = null;
+
+    function loadToken() {
+        tokenPromise = originalFetch('/csrf-token', { credentials: 'same-origin' })
+            .then(response => response.json())
+            .then(result => result.data.token)
+            .catch(() => {
+                tokenPromise = null;
+                return '';
+            });
+        return tokenPromise;
+    }
+
+    function withToken(init, token) {
+        const headers = new Headers(init.headers || {});
+        headers.set('X-CSRF-Token', token);
+        return { ...init, headers };
+    }
+
+    window.fetch = async function (input, init = {}) {
+        const method = (init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+        const url = new URL(input instanceof Request ? input.url : input, window.location.href);
+        if (safeMethods.includes(method) || url.origin !== window.location.origin) {
+            return originalFetch(input, init);
+        }
+
+        const response = await originalFetch(input, withToken(init, await (tokenPromise || loadToken())));
+        if (response.status !== 403) {
+            return response;
+        }
+        const result = await response.clone().json().catch(() => ({}));
+        if (result.code !== 'csrf_invalid') {
+            return response;
+        }
+        return originalFetch(input, withToken(init, await loadToken()));
+    };
+})();
@@ -1,3 +1,10 @@
+// Форматирование цены из API: { amount, currency }, где amount — в минимальных
+// единицах валюты (центах, тиынах). Символ и число знаков берутся из валюты
+function formatPrice(price) {
+    const format = new Intl.NumberFormat(undefined, { style: 'currency', currency: price.currency });
+    return format.format(price.amount / 10 ** format.resolvedOptions().maximumFractionDigits);
+}
+
 // Обработка формы для отелей
 document.getElementById('hotelForm')?.addEventListener('submit', function (e) {
     e.preventDefault();
@@ -17,7 +24,14 @@ document.getElementById('carForm')?.addEventListener('submit', function (e) {
     const dropoffDate = document.getElementById('car-dropoff-date').value;
     const carType = document.getElementById('car-type').value;
 
-    alert(`Searching ${carType} cars for pickup at ${pickup} from ${pickupDate} to ${dropoffDate}.`);
+    if (dropoffDate <= pickupDate) {
+        alert("Drop-off date must be after pickup date.");
+        return;
+    }
+
+    const params = new URLSearchParams({ pickup_date: pickupDate, dropoff_date: dropoffDate });
+    if (carType) params.set('category', carType);
+    window.location.href = `/cars?${params.toString()}`;
 });
 
 // Обработка формы "Contact Us"
@@ -50,9 +64,9 @@ document.getElementById('contactForm')?.addEventListener('submit', async functio
 
 // Динамическое отображение отелей
 const hotels = [
-    { name: "Luxury Inn", price: 200, rating: 4.5 },
-    { name: "Economy Stay", price: 50, rating: 3.5 },
-    { name: "Comfort Suites", price: 100, rating: 4.0 },
+    { name: "Luxury Inn", price: { amount: 20000, currency: "USD" }, rating: 4.5 },
+    { name: "Economy Stay", price: { amount: 5000, currency: "USD" }, rating: 3.5 },
+    { name: "Comfort Suites", price: { amount: 10000, currency: "USD" }, rating: 4.0 },
 ];
 
 const hotelList = document.getElementById("hotelList");
@@ -65,7 +79,7 @@ const displayHotels = (hotels) => {
             hotelList.innerHTML += `
                 <div class="hotel">
                     <h3>${hotel.name}</h3>
-                    <p>Price: $${hotel.price} / night</p>
+                    <p>Price: ${formatPrice(hotel.price)} / night</p>
                     <p>Rating: ${hotel.rating} stars</p>
                 </div>
             `;
@@ -76,7 +90,7 @@ const displayHotels = (hotels) => {
 hotelSortSelect?.addEventListener("change", () => {
     const criteria = hotelSortSelect.value;
     const sortedHotels = [...hotels];
-    if (criteria === "price") sortedHotels.sort((a, b) => a.price - b.price);
+    if (criteria === "price") sortedHotels.sort((a, b) => a.price.amount - b.price.amount);
     else if (criteria === "rating") sortedHotels.sort((a, b) => b.rating - a.rating);
     else if (criteria === "name") sortedHotels.sort((a, b) => a.name.localeCompare(b.name));
     displayHotels(sortedHotels);
@@ -114,10 +128,10 @@ document.getElementById('loginForm').addEventListener('submit', function(e) {
 
 document.addEventListener("DOMContentLoaded", () => {
     const hotels = [
-        { name: "Hotel California", price: 200, rating: 4.5 },
-        { name: "Grand Budapest", price: 150, rating: 4.8 },
-        { name: "The Plaza", price: 300, rating: 4.7 },
-        { name: "Ritz Carlton", price: 350, rating: 4.9 },
+        { name: "Hotel California", price: { amount: 20000, currency: "USD" }, rating: 4.5 },
+        { name: "Grand Budapest", price: { amount: 15000, currency: "USD" }, rating: 4.8 },
+        { name: "The Plaza", price: { amount: 30000, currency: "USD" }, rating: 4.7 },
+        { name: "Ritz Carlton", price: { amount: 35000, currency: "USD" }, rating: 4.9 },
     ];
 
     const hotelList = document.getElementById("hotelList");
@@ -132,7 +146,7 @@ document.addEventListener("DOMContentLoaded", () => {
             hotelDiv.classList.add("car");
             hotelDiv.innerHTML = `
                 <h3>${hotel.name}</h3>
-                <p>Price: $${hotel.price}</p>
+                <p>Price: ${formatPrice(hotel.price)}</p>
                 <p>Rating: ${hotel.rating}</p>
             `;
             hotelList.appendChild(hotelDiv);
@@ -153,7 +167,7 @@ document.addEventListener("DOMContentLoaded", () => {
         // Apply sort
         const sortValue = sortSelect.value;
         if (sortValue === "price") {
-            filteredHotels.sort((a, b) => a.price - b.price);
+            filteredHotels.sort((a, b) => a.price.amount - b.price.amount);
         } else if (sortValue === "rating") {
             filteredHotels.sort((a, b) => b.rating - a.rating);
         } else if (sortValue === "name") {
@@ -184,13 +198,16 @@ document.getElementById('addCarForm').addEventListener('submit', async (event) =
                 'Content-Type': 'application/json',
                 'Role': 'admin', // Имитация отправки роли, заменить на реальный механизм аутентификации
             },
-            body: JSON.stringify({ Model: carName, Price: carPrice, Category: carCategory }),
+            // Цена передаётся в минимальных единицах валюты (центах)
+            body: JSON.stringify({ Model: carName, Price: { amount: carPrice * 100, currency: 'USD' }, Category: carCategory }),
         });
 
         if (response.ok) {
             alert('Car added successfully!');
         } else {
-            alert('Failed to add car');
+            const data = await response.json().catch(() => ({}));
+            const details = (data.errors || []).map(e => e.field + ': ' + e.message).join('\n');
+            alert('Failed to add car' + (details ? '\n' + details : ''));
         }
     } catch (error) {
         console.error('Error:', error);
@@ -236,7 +253,7 @@ async function loadCars() {
             carItem.className = "car-item";
             carItem.innerHTML = `
                 <h3>${car.name}</h3>
-                <p>Price: $${car.price}</p>
+                <p>Price: ${formatPrice(car.price)}</p>
                 <p>Category: ${car.category}</p>
             `;
             carList.appendChild(carItem);
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Секрет "12345678901234567890" из тестовых векторов RFC 6238
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Тест: коды совпадают с тестовыми векторами RFC 6238 (последние 6 цифр)
+func TestTOTPCode(t *testing.T) {
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, want := range cases {
+		code, err := totpCode(rfcTOTPSecret, ts/totpPeriod)
+		assert.NoError(t, err)
+		assert.Equal(t, want, code, "время %d", ts)
+	}
+}
+
+// Тест: код принимается с допуском в один шаг и не принимается повторно
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := verifyTOTP(rfcTOTPSecret, "081804", now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/totpPeriod, step)
+
+	_, ok = verifyTOTP(rfcTOTPSecret, "081804", now.Add(totpPeriod*time.Second), 0)
+	assert.True(t, ok, "код предыдущего шага")
+
+	_, ok = verifyTOTP(rfcTOTPSecret, "081804", now, step)
+	assert.False(t, ok, "повторное использование")
+
+	_, ok = verifyTOTP(rfcTOTPSecret, "081804", now.Add(3*totpPeriod*time.Second), 0)
+	assert.False(t, ok, "устаревший код")
+
+	_, ok = verifyTOTP(rfcTOTPSecret, "000000", now, 0)
+	assert.False(t, ok)
+}
+
+// Тест: коды восстановления уникальны и не зависят от регистра и дефисов при вводе
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, c)
+		seen[c] = true
+	}
+	assert.Len(t, seen, recoveryCodeCount)
+
+	assert.Equal(t, "abcdefgh", normalizeRecoveryCode(" ABCD-efgh "))
+}
+
+// Тест: администратор без второго фактора в текущей сессии не проходит в админку
+func TestAdminMiddlewareRequiresMFA(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT user_id, mfa FROM sessions").
+		WithArgs(hashToken("token")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "mfa"}).AddRow(1, false))
+	mock.ExpectQuery("SELECT role, totp_enabled FROM users").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "totp_enabled"}).AddRow(roleAdmin, true))
+
+	called := false
+	handler := adminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	req := httptest.NewRequest("GET", "/admin/rates", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "token"})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"mfa_required"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Скидка за пакет «номер + автомобиль» на пересекающиеся даты, в процентах
+var packageDiscountPercent = envInt("PACKAGE_DISCOUNT_PERCENT", 10)
+
+var (
+	errTripNotFound = errors.New("поездка не найдена")
+	errTripNothing  = errors.New("в поездке нет неоплаченных бронирований")
+)
+
+// Поездка: набор бронирований пользователя, которые можно оплатить и отменить вместе
+type Trip struct {
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	Currency        string    `json:"currency"`
+	Items           []Booking `json:"items"`
+	Package         bool      `json:"package"` // Есть номер и автомобиль на пересекающиеся даты
+	Subtotal        Money     `json:"subtotal"`
+	PackageDiscount Money     `json:"package_discount"`
+	Total           Money     `json:"total"`
+}
+
+// Бронирование учитывается в поездке, пока оно не отменено
+func tripItemActive(b Booking) bool {
+	return b.Status != bookingCancelled && b.Status != bookingNoShow
+}
+
+// Функция проверки условия пакета: в поездке есть действующие бронирования
+// номера и автомобиля, периоды которых пересекаются
+func qualifiesForPackage(items []Booking) bool {
+	for _, room := range items {
+		if room.ItemType != "room" || !tripItemActive(room) {
+			continue
+		}
+		for _, car := range items {
+			if car.ItemType != "car" || !tripItemActive(car) {
+				continue
+			}
+			// Даты в формате 2006-01-02 сравниваются как строки
+			if room.StartDate < car.EndDate && car.StartDate < room.EndDate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Функция подсчёта итогов поездки. У оплаченных бронирований скидка уже
+// учтена в цене, у неоплаченных показывается скидка, которую даст совместная оплата
+func summarizeTrip(trip *Trip, percent int) {
+	trip.Package = qualifiesForPackage(trip.Items)
+	trip.Subtotal = Money{Currency: trip.Currency}
+	trip.PackageDiscount = Money{Currency: trip.Currency}
+	for _, b := range trip.Items {
+		if !tripItemActive(b) {
+			continue
+		}
+		discount := Money{Currency: trip.Currency}
+		if b.PackageDiscount != nil {
+			discount = *b.PackageDiscount
+		}
+		if b.Status == bookingPending && trip.Package {
+			discount = b.Total.Percent(percent)
+		}
+		subtotal := b.Total.Amount
+		if b.Status != bookingPending {
+			subtotal += discount.Amount
+		}
+		trip.Subtotal.Amount += subtotal
+		trip.PackageDiscount.Amount += discount.Amount
+	}
+	trip.Total = Money{trip.Subtotal.Amount - trip.PackageDiscount.Amount, trip.Currency}
+}
+
+// Функция загрузки бронирований поездки
+func loadTripItems(q dbtx, tripID int) ([]Booking, error) {
+	rows, err := q.Query(`SELECT id, item_type, item_id, start_date, end_date, status, total_price, currency, package_discount, refund_amount, COALESCE(refund_status, '')
+		FROM bookings WHERE trip_id = $1 ORDER BY start_date, id`, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []Booking{}
+	for rows.Next() {
+		var b Booking
+		var start, end time.Time
+		var discount int64
+		var refund sql.NullInt64
+		if err := rows.Scan(&b.ID, &b.ItemType, &b.ItemID, &start, &end, &b.Status, &b.Total.Amount, &b.Total.Currency, &discount, &refund, &b.RefundStatus); err != nil {
+			return nil, err
+		}
+		b.StartDate = start.Format(dateLayout)
+		b.EndDate = end.Format(dateLayout)
+		b.ItemName = itemName(b.ItemType, b.ItemID)
+		b.Policy = policyFor(b.ItemType, b.ItemID)
+		b.TripID = &tripID
+		if discount != 0 {
+			b.PackageDiscount = &Money{discount, b.Total.Currency}
+		}
+		if refund.Valid {
+			b.RefundAmount = &Money{refund.Int64, b.Total.Currency}
+		}
+		items = append(items, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		items[i].Paid, err = paidAmount(q, items[i].ID, items[i].Total.Currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// Функция загрузки поездки пользователя вместе с бронированиями и итогами
+func loadTrip(userID, tripID int) (Trip, error) {
+	trip := Trip{ID: tripID}
+	err := db.QueryRow(`SELECT name, currency FROM trips WHERE id = $1 AND user_id = $2`, tripID, userID).
+		Scan(&trip.Name, &trip.Currency)
+	if err == sql.ErrNoRows {
+		return Trip{}, errTripNotFound
+	}
+	if err != nil {
+		return Trip{}, err
+	}
+	trip.Items, err = loadTripItems(db, tripID)
+	if err != nil {
+		return Trip{}, err
+	}
+	summarizeTrip(&trip, packageDiscountPercent)
+	return trip, nil
+}
+
+// Функция снятия скидки пакета, если после отмены поездка перестала отвечать его
+// условию. Бронирования из cancelling считаются уже отменёнными. Цена оставшихся
+// бронирований не меняется, а сумма снятых скидок возвращается вызывающему:
+// она удерживается из возврата за отменённое бронирование
+func withdrawPackageDiscount(tx *sql.Tx, tripID int, cancelling map[int]bool) (int64, error) {
+	items, err := loadTripItems(tx, tripID)
+	if err != nil {
+		return 0, err
+	}
+	for i := range items {
+		if cancelling[items[i].ID] {
+			items[i].Status = bookingCancelled
+		}
+	}
+	if qualifiesForPackage(items) {
+		return 0, nil
+	}
+
+	var withdrawn int64
+	for _, b := range items {
+		if !tripItemActive(b) || b.PackageDiscount == nil {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE bookings SET package_discount = 0 WHERE id = $1`, b.ID); err != nil {
+			return 0, err
+		}
+		withdrawn += b.PackageDiscount.Amount
+	}
+	return withdrawn, nil
+}
+
+// Функция добавления в поездку бронирования номера или автомобиля. Бронирование
+// создаётся неоплаченным в валюте поездки и, как удержание, занимает объект
+// только до expires_at
+func addTripItem(userID, tripID int, req HoldRequest) (Booking, error) {
+	var currency string
+	err := db.QueryRow(`SELECT currency FROM trips WHERE id = $1 AND user_id = $2`, tripID, userID).Scan(&currency)
+	if err == sql.ErrNoRows {
+		return Booking{}, errTripNotFound
+	}
+	if err != nil {
+		return Booking{}, err
+	}
+
+	item, err := priceItemRequest(&req, currency)
+	if err != nil {
+		return Booking{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Booking{}, err
+	}
+	defer tx.Rollback()
+
+	if err := lockItem(tx, req.ItemType, req.ItemID); err != nil {
+		return Booking{}, err
+	}
+	available, err := itemAvailableFor(tx, req.ItemType, req.ItemID, item.Start, item.End, userID, 0)
+	if err != nil {
+		return Booking{}, err
+	}
+	if !available {
+		return Booking{}, errItemUnavailable
+	}
+
+	booking := Booking{
+		ItemType:  req.ItemType,
+		ItemID:    req.ItemID,
+		ItemName:  itemName(req.ItemType, req.ItemID),
+		StartDate: item.Start.Format(dateLayout),
+		EndDate:   item.End.Format(dateLayout),
+		Status:    bookingPending,
+		Total:     item.Quote.Total,
+		Paid:      Money{Currency: currency},
+		Policy:    policyFor(req.ItemType, req.ItemID),
+		TripID:    &tripID,
+	}
+	err = tx.QueryRow(`INSERT INTO bookings (item_type, item_id, user_id, start_date, end_date, total_price, currency, pickup_branch_id, dropoff_branch_id, one_way_fee, trip_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW() + make_interval(secs => $12)) RETURNING id, expires_at`,
+		req.ItemType, req.ItemID, userID, item.Start, item.End, item.Quote.Total.Amount, currency,
+		nullableID(req.PickupBranchID), nullableID(req.DropoffBranchID), item.OneWayFee.Amount, tripID, holdTTL.Seconds()).
+		Scan(&booking.ID, &booking.ExpiresAt)
+	if err != nil {
+		return Booking{}, err
+	}
+	return booking, tx.Commit()
+}
+
+// Функция совместной оплаты поездки. Каждое неоплаченное бронирование списывается
+// отдельным платежом, чтобы его можно было отменить и вернуть независимо от остальных;
+// если одно списание не прошло, уже списанные возвращаются и ничего не подтверждается
+func checkoutTrip(userID, tripID int, paymentToken, idempotencyKey string, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currency string
+	err = tx.QueryRow(`SELECT currency FROM trips WHERE id = $1 AND user_id = $2 FOR UPDATE`, tripID, userID).Scan(&currency)
+	if err == sql.ErrNoRows {
+		return errTripNotFound
+	}
+	if err != nil {
+		return err
+	}
+	items, err := loadTripItems(tx, tripID)
+	if err != nil {
+		return err
+	}
+	discounted := qualifiesForPackage(items)
+
+	var charged []PaymentResult
+	refundCharged := func() {
+		for _, c := range charged {
+			if _, err := paymentProvider.Refund(c.ProviderRef, c.Amount); err != nil {
+				log.Printf("Не удалось вернуть платёж %s по поездке #%d: %v", c.ProviderRef, tripID, err)
+			}
+		}
+	}
+
+	for _, b := range items {
+		if b.Status != bookingPending {
+			continue
+		}
+		if b.StartDate <= now.Format(dateLayout) {
+			refundCharged()
+			return fmt.Errorf("%w: бронирование #%d уже началось", errInvalidTransition, b.ID)
+		}
+
+		discount := Money{Currency: currency}
+		if discounted {
+			discount = b.Total.Percent(packageDiscountPercent)
+		}
+		amount := Money{b.Total.Amount - discount.Amount, currency}
+		_, err = tx.Exec(`UPDATE bookings SET total_price = $1, package_discount = $2 WHERE id = $3`, amount.Amount, discount.Amount, b.ID)
+		if err == nil {
+			err = transitionBooking(tx, b.ID, bookingConfirmed)
+		}
+		if err != nil {
+			refundCharged()
+			return err
+		}
+
+		auth, err := paymentProvider.Authorize(AuthorizeRequest{
+			Amount:         amount,
+			PaymentToken:   paymentToken,
+			IdempotencyKey: providerIdempotencyKey(userID, "/trips/checkout", b.ID, idempotencyKey),
+			Description:    fmt.Sprintf("Поездка #%d, бронирование #%d", tripID, b.ID),
+		})
+		if err != nil {
+			refundCharged()
+			return err
+		}
+		captured, err := paymentProvider.Capture(auth.ProviderRef, amount)
+		if err != nil {
+			refundCharged()
+			return err
+		}
+		charged = append(charged, captured)
+
+		_, err = tx.Exec(`INSERT INTO payments (booking_id, provider, provider_ref, status, amount, currency) VALUES ($1, $2, $3, $4, $5, $6)`,
+			b.ID, paymentProvider.Name(), captured.ProviderRef, paymentCaptured, amount.Amount, currency)
+		if err != nil {
+			refundCharged()
+			return err
+		}
+	}
+	if len(charged) == 0 {
+		return errTripNothing
+	}
+
+	if err := tx.Commit(); err != nil {
+		refundCharged()
+		return err
+	}
+	return nil
+}
+
+// Функция отмены всей поездки: отменяются все бронирования, которые ещё не начались.
+// Возврат по каждому считается по его собственным правилам отмены; если хотя бы
+// один возврат не удался, у итога статус failed
+func cancelTrip(userID, tripID int, now time.Time) (RefundResult, error) {
+	trip, err := loadTrip(userID, tripID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+
+	// Бронирования, которые отменяются вместе: скидка пакета между ними не удерживается
+	cancelling := map[int]bool{}
+	for _, b := range trip.Items {
+		if (b.Status == bookingPending || b.Status == bookingConfirmed) && b.StartDate > now.UTC().Format(dateLayout) {
+			cancelling[b.ID] = true
+		}
+	}
+
+	refunded := RefundResult{Amount: Money{Currency: trip.Currency}}
+	for _, b := range trip.Items {
+		if b.Status != bookingPending && b.Status != bookingConfirmed {
+			continue
+		}
+		refund, err := cancelTripBooking(userID, b.ID, now, cancelling)
+		if errors.Is(err, errInvalidTransition) {
+			continue
+		}
+		if err != nil {
+			return refunded, err
+		}
+		refunded.Amount.Amount += refund.Amount.Amount
+		if refund.Status == refundFailed || refunded.Status == "" {
+			refunded.Status = refund.Status
+		}
+	}
+	return refunded, nil
+}
+
+// Запрос создания поездки
+type TripCreateRequest struct {
+	Name     string `json:"name" validate:"max=255"`
+	Currency string `json:"currency" validate:"oneof=USD|EUR|KZT|RUB"`
+}
+
+// Обработчик поездок: GET — список поездок пользователя, POST — новая поездка
+func handleTrips(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rows, err := db.Query(`SELECT id FROM trips WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
+		if err != nil {
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				log.Println("Ошибка обработки строки:", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка обработки данных")
+				return
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		trips := []Trip{}
+		for _, id := range ids {
+			trip, err := loadTrip(userID, id)
+			if err != nil {
+				log.Println("Ошибка запроса к базе данных:", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+				return
+			}
+			trips = append(trips, trip)
+		}
+		writeSuccess(w, r, http.StatusOK, "", trips)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req TripCreateRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+		if req.Currency == "" {
+			req.Currency = baseCurrency
+		}
+		if _, ok := currencyExponents[req.Currency]; !ok {
+			writeError(w, r, http.StatusBadRequest, "Неизвестная валюта")
+			return
+		}
+
+		trip := Trip{Name: req.Name, Currency: req.Currency, Items: []Booking{}}
+		err := db.QueryRow(`INSERT INTO trips (user_id, name, currency) VALUES ($1, $2, $3) RETURNING id`,
+			userID, req.Name, req.Currency).Scan(&trip.ID)
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+		summarizeTrip(&trip, packageDiscountPercent)
+
+		writeSuccess(w, r, http.StatusCreated, "Поездка создана", map[string]interface{}{
+			"trip": trip,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос добавления объекта в поездку
+type TripItemRequest struct {
+	TripID int `json:"trip_id" validate:"required"`
+	HoldRequest
+}
+
+// Обработчик добавления номера или автомобиля в поездку
+func handleAddTripItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req TripItemRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		booking, err := addTripItem(userID, req.TripID, req.HoldRequest)
+		switch {
+		case err == nil:
+		case errors.Is(err, errTripNotFound):
+			writeError(w, r, http.StatusNotFound, "Поездка не найдена")
+			return
+		case errors.Is(err, errInvalidDateRange):
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		case errors.Is(err, errItemNotFound):
+			writeError(w, r, http.StatusNotFound, "Объект или филиал не найден")
+			return
+		case errors.Is(err, errWrongPickupPoint):
+			writeErrorCode(w, r, http.StatusConflict, "wrong_branch", "Автомобиль недоступен в выбранном филиале")
+			return
+		case errors.Is(err, errItemUnavailable):
+			writeErrorCode(w, r, http.StatusConflict, "item_unavailable", "Объект занят на выбранные даты")
+			return
+		default:
+			log.Printf("Ошибка добавления в поездку: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+
+		trip, err := loadTrip(userID, req.TripID)
+		if err != nil {
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Бронирование добавлено в поездку", map[string]interface{}{
+			"booking": booking,
+			"trip":    trip,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос оплаты поездки
+type TripCheckoutRequest struct {
+	TripID       int    `json:"trip_id" validate:"required"`
+	PaymentToken string `json:"payment_token" validate:"max=255"`
+}
+
+// Обработчик совместной оплаты поездки
+func handleTripCheckout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req TripCheckoutRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		err = checkoutTrip(userID, req.TripID, req.PaymentToken, r.Header.Get(idempotencyHeader), time.Now())
+		switch {
+		case err == nil:
+		case errors.Is(err, errTripNotFound):
+			writeError(w, r, http.StatusNotFound, "Поездка не найдена")
+			return
+		case errors.Is(err, errTripNothing):
+			writeErrorCode(w, r, http.StatusConflict, "nothing_to_pay", "В поездке нет неоплаченных бронирований")
+			return
+		case errors.Is(err, errHoldExpired):
+			writeErrorCode(w, r, http.StatusGone, "hold_expired", "Время удержания истекло, выберите объект заново")
+			return
+		case errors.Is(err, errInvalidTransition):
+			writeErrorCode(w, r, http.StatusConflict, "booking_started", "Одно из бронирований уже началось")
+			return
+		case errors.Is(err, errPaymentDeclined):
+			writeErrorCode(w, r, http.StatusPaymentRequired, "payment_declined", "Платёж отклонён")
+			return
+		default:
+			log.Printf("Ошибка оплаты поездки: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		trip, err := loadTrip(userID, req.TripID)
+		if err != nil {
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Поездка оплачена", map[string]interface{}{
+			"trip": trip,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Ссылка на поездку
+type TripRef struct {
+	TripID int `json:"trip_id" validate:"required"`
+}
+
+// Обработчик отмены всей поездки
+func handleCancelTrip(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req TripRef
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		refund, err := cancelTrip(userID, req.TripID, time.Now())
+		if errors.Is(err, errTripNotFound) {
+			writeError(w, r, http.StatusNotFound, "Поездка не найдена")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка отмены поездки: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		message := "Поездка отменена"
+		if refund.Status == refundFailed {
+			message = "Поездка отменена, но вернуть деньги не удалось. Мы вернём их вручную"
+		}
+		writeSuccess(w, r, http.StatusOK, message, map[string]interface{}{
+			"refund":        refund.Amount,
+			"refund_status": refund.Status,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
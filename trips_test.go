@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест условия пакета: номер и автомобиль должны пересекаться по датам
+func TestQualifiesForPackage(t *testing.T) {
+	room := Booking{ItemType: "room", StartDate: "2025-07-01", EndDate: "2025-07-05", Status: bookingPending}
+	car := Booking{ItemType: "car", StartDate: "2025-07-04", EndDate: "2025-07-08", Status: bookingPending}
+	assert.True(t, qualifiesForPackage([]Booking{room, car}))
+
+	// День возврата не входит в период
+	car.StartDate = "2025-07-05"
+	assert.False(t, qualifiesForPackage([]Booking{room, car}))
+
+	car.StartDate = "2025-07-02"
+	car.Status = bookingCancelled
+	assert.False(t, qualifiesForPackage([]Booking{room, car}))
+	assert.False(t, qualifiesForPackage([]Booking{room}))
+}
+
+// Тест итогов поездки: скидка считается с неоплаченных бронирований,
+// у оплаченных она уже входит в цену
+func TestSummarizeTrip(t *testing.T) {
+	trip := Trip{Currency: "USD", Items: []Booking{
+		{ItemType: "room", StartDate: "2025-07-01", EndDate: "2025-07-05", Status: bookingConfirmed,
+			Total: Money{72000, "USD"}, PackageDiscount: &Money{8000, "USD"}},
+		{ItemType: "car", StartDate: "2025-07-02", EndDate: "2025-07-04", Status: bookingPending,
+			Total: Money{10000, "USD"}},
+		{ItemType: "car", StartDate: "2025-07-02", EndDate: "2025-07-04", Status: bookingCancelled,
+			Total: Money{50000, "USD"}},
+	}}
+	summarizeTrip(&trip, 10)
+
+	assert.True(t, trip.Package)
+	assert.Equal(t, Money{90000, "USD"}, trip.Subtotal)
+	assert.Equal(t, Money{9000, "USD"}, trip.PackageDiscount)
+	assert.Equal(t, Money{81000, "USD"}, trip.Total)
+}
+
+// Тест: после отмены номера из пакета скидка с оставшегося автомобиля снимается
+// и возвращается для удержания, а при отмене всей поездки не удерживается
+func TestWithdrawPackageDiscount(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	tripRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "item_type", "item_id", "start_date", "end_date", "status", "total_price", "currency", "package_discount", "refund_amount", "refund_status"}).
+			AddRow(1, "room", 1, date("2025-07-01"), date("2025-07-05"), bookingCancelled, 72000, "USD", 8000, nil, "").
+			AddRow(2, "car", 1, date("2025-07-02"), date("2025-07-04"), bookingConfirmed, 9000, "USD", 1000, nil, "")
+	}
+	expectPaid := func() {
+		for _, id := range []int{1, 2} {
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount - refunded_amount\\), 0\\) FROM payments").WithArgs(id).
+				WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+		}
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, item_type, item_id, start_date, end_date, status").WithArgs(4).WillReturnRows(tripRows())
+	expectPaid()
+	mock.ExpectExec("UPDATE bookings SET package_discount = 0 WHERE id = \\$1").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, item_type, item_id, start_date, end_date, status").WithArgs(4).WillReturnRows(tripRows())
+	expectPaid()
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	withdrawn, err := withdrawPackageDiscount(tx, 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), withdrawn)
+
+	withdrawn, err = withdrawPackageDiscount(tx, 4, map[int]bool{1: true, 2: true})
+	assert.NoError(t, err)
+	assert.Zero(t, withdrawn)
+	tx.Rollback()
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
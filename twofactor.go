@@ -0,0 +1,410 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Срок, за который нужно ввести код после пароля, и число попыток ввода
+const (
+	mfaChallengeLifetime = 5 * time.Minute
+	mfaMaxAttempts       = 5
+)
+
+var (
+	errInvalidMFACode      = errors.New("неверный код двухфакторной аутентификации")
+	errMFAChallengeInvalid = errors.New("вход не найден или устарел")
+	errMFAAlreadyEnabled   = errors.New("двухфакторная аутентификация уже включена")
+	errMFANotSetUp         = errors.New("настройка двухфакторной аутентификации не начата")
+	errMFARequiredForAdmin = errors.New("администратор не может отключить двухфакторную аутентификацию")
+)
+
+// Ответ на вход по паролю, когда нужен второй фактор
+type MFAChallenge struct {
+	Required bool   `json:"mfa_required"`
+	Token    string `json:"mfa_token"`
+}
+
+// Данные для добавления аккаунта в приложение-аутентификатор
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Функция создания незавершённого входа, ожидающего код второго фактора
+func createMFAChallenge(userID int) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hashToken(token), userID, time.Now().Add(mfaChallengeLifetime))
+	return token, err
+}
+
+// Функция проверки второго фактора: кода TOTP или неиспользованного кода восстановления.
+// Вызывается внутри транзакции; принятый код больше не подойдёт
+func checkSecondFactor(q dbtx, userID int, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := q.QueryRow(`SELECT totp_secret, totp_last_step FROM users WHERE id = $1 AND totp_enabled FOR UPDATE`, userID).
+		Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := verifyTOTP(secret, code, time.Now(), lastStep); ok {
+		_, err := q.Exec(`UPDATE users SET totp_last_step = $1 WHERE id = $2`, step, userID)
+		return err == nil, err
+	}
+
+	res, err := q.Exec(`UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Функция завершения входа кодом второго фактора. После mfaMaxAttempts
+// неверных кодов вход нужно начинать заново с пароля
+func completeMFAChallenge(token, code string) (int, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var locale string
+	err = tx.QueryRow(`SELECT c.user_id, COALESCE(u.locale, '') FROM mfa_challenges c JOIN users u ON u.id = c.user_id
+		WHERE c.token_hash = $1 AND c.expires_at > NOW() AND c.attempts < $2 FOR UPDATE OF c`,
+		hashToken(token), mfaMaxAttempts).Scan(&userID, &locale)
+	if err == sql.ErrNoRows {
+		return 0, "", errMFAChallengeInvalid
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	ok, err := checkSecondFactor(tx, userID, code)
+	if err != nil {
+		return 0, "", err
+	}
+	if !ok {
+		if _, err := tx.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1`, hashToken(token)); err != nil {
+			return 0, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", err
+		}
+		return 0, "", errInvalidMFACode
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_challenges WHERE token_hash = $1`, hashToken(token)); err != nil {
+		return 0, "", err
+	}
+	return userID, locale, tx.Commit()
+}
+
+// Функция начала настройки TOTP: создаёт новый секрет, который начнёт
+// действовать только после подтверждения кодом из приложения
+func startTOTPSetup(userID int, password string) (TOTPSetup, error) {
+	if err := checkPassword(db, userID, password); err != nil {
+		return TOTPSetup{}, err
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	var email string
+	err = db.QueryRow(`UPDATE users SET totp_secret = $1 WHERE id = $2 AND NOT totp_enabled RETURNING email`,
+		secret, userID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return TOTPSetup{}, errMFAAlreadyEnabled
+	}
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	return TOTPSetup{Secret: secret, URI: totpURI(secret, email)}, nil
+}
+
+// Функция включения TOTP после проверки первого кода. Возвращает коды
+// восстановления, в базе хранятся только их хеши. Текущая сессия считается
+// прошедшей второй фактор, остальные сессии пользователя завершаются
+func enableTOTP(userID int, code, sessionHash string) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabled bool
+	err = tx.QueryRow(`SELECT COALESCE(totp_secret, ''), totp_enabled FROM users WHERE id = $1 FOR UPDATE`, userID).
+		Scan(&secret, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, errMFAAlreadyEnabled
+	}
+	if secret == "" {
+		return nil, errMFANotSetUp
+	}
+	step, ok := verifyTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2`, step, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashToken(normalizeRecoveryCode(c))); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET mfa = TRUE WHERE token_hash = $1`, sessionHash); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = $1 AND token_hash <> $2`, userID, sessionHash); err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Функция отключения TOTP по паролю и действующему коду. Администраторам
+// второй фактор обязателен, и отключить его нельзя
+func disableTOTP(userID int, password, code string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkPassword(tx, userID, password); err != nil {
+		return err
+	}
+	var role string
+	if err := tx.QueryRow(`SELECT role FROM users WHERE id = $1`, userID).Scan(&role); err != nil {
+		return err
+	}
+	if role == roleAdmin {
+		return errMFARequiredForAdmin
+	}
+	ok, err := checkSecondFactor(tx, userID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInvalidMFACode
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET mfa = FALSE WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Второй шаг входа: токен из первого шага и код
+type MFALoginRequest struct {
+	Token string `json:"mfa_token" validate:"required,max=128"`
+	Code  string `json:"code" validate:"required,max=32"`
+}
+
+// Обработчик второго шага входа: код из приложения-аутентификатора или код восстановления
+func handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		if ok, wait := loginIPLimiter.allow(clientIP(r), time.Now()); !ok {
+			writeTooManyAttempts(w, r, wait)
+			return
+		}
+
+		var req MFALoginRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		userID, locale, err := completeMFAChallenge(req.Token, req.Code)
+		switch {
+		case errors.Is(err, errMFAChallengeInvalid):
+			writeErrorCode(w, r, http.StatusUnauthorized, "token_invalid", "Вход не завершён вовремя, войдите заново")
+			return
+		case errors.Is(err, errInvalidMFACode):
+			writeErrorCode(w, r, http.StatusUnauthorized, "invalid_mfa_code", "Неверный код")
+			return
+		case err != nil:
+			log.Printf("Ошибка проверки второго фактора: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		if err := startSession(w, userID, true); err != nil {
+			log.Printf("Ошибка создания сессии: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+		if isSupportedLocale(locale) {
+			setLocaleCookie(w, locale)
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Вы успешно вошли", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик начала настройки TOTP: возвращает секрет и URI для QR-кода
+func handleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req PasswordConfirmation
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		setup, err := startTOTPSetup(userID, req.Password)
+		switch {
+		case errors.Is(err, errWrongPassword):
+			writeErrorCode(w, r, http.StatusForbidden, "wrong_password", "Неверный пароль")
+			return
+		case errors.Is(err, errMFAAlreadyEnabled):
+			writeErrorCode(w, r, http.StatusConflict, "mfa_already_enabled", "Двухфакторная аутентификация уже включена")
+			return
+		case err != nil:
+			log.Printf("Ошибка настройки двухфакторной аутентификации: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Отсканируйте QR-код в приложении-аутентификаторе и введите код из него", setup)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Код из приложения-аутентификатора
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// Обработчик включения TOTP первым кодом из приложения
+func handleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+		cookie, _ := r.Cookie(sessionCookieName)
+
+		var req TOTPCodeRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		codes, err := enableTOTP(userID, req.Code, hashToken(cookie.Value))
+		switch {
+		case errors.Is(err, errInvalidMFACode):
+			writeErrorCode(w, r, http.StatusBadRequest, "invalid_mfa_code", "Неверный код")
+			return
+		case errors.Is(err, errMFAAlreadyEnabled):
+			writeErrorCode(w, r, http.StatusConflict, "mfa_already_enabled", "Двухфакторная аутентификация уже включена")
+			return
+		case errors.Is(err, errMFANotSetUp):
+			writeErrorCode(w, r, http.StatusConflict, "mfa_not_set_up", "Сначала начните настройку двухфакторной аутентификации")
+			return
+		case err != nil:
+			log.Printf("Ошибка включения двухфакторной аутентификации: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Двухфакторная аутентификация включена. Сохраните коды восстановления, они показываются один раз",
+			map[string][]string{"recovery_codes": codes})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Запрос отключения двухфакторной аутентификации
+type TOTPDisableRequest struct {
+	Password string `json:"password" validate:"required,max=128"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// Обработчик отключения TOTP
+func handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req TOTPDisableRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		err = disableTOTP(userID, req.Password, req.Code)
+		switch {
+		case errors.Is(err, errWrongPassword):
+			writeErrorCode(w, r, http.StatusForbidden, "wrong_password", "Неверный пароль")
+			return
+		case errors.Is(err, errMFARequiredForAdmin):
+			writeErrorCode(w, r, http.StatusForbidden, "mfa_required_for_admin", "Администраторы не могут отключить двухфакторную аутентификацию")
+			return
+		case errors.Is(err, errInvalidMFACode):
+			writeErrorCode(w, r, http.StatusBadRequest, "invalid_mfa_code", "Неверный код")
+			return
+		case err != nil:
+			log.Printf("Ошибка отключения двухфакторной аутентификации: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сервера")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Двухфакторная аутентификация отключена", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const uploadsDir = "./uploads"
+
+var (
+	errNoUpload          = errors.New("файл не передан")
+	errUnsupportedUpload = errors.New("недопустимый тип файла")
+)
+
+// Допустимые типы файлов и расширения, с которыми они сохраняются.
+// Тип определяется по содержимому, а не по имени файла от клиента
+var (
+	imageUploadTypes = map[string]string{
+		"image/jpeg": ".jpg",
+		"image/png":  ".png",
+		"image/webp": ".webp",
+	}
+	attachmentUploadTypes = map[string]string{
+		"image/jpeg":      ".jpg",
+		"image/png":       ".png",
+		"application/pdf": ".pdf",
+	}
+)
+
+// Функция сохранения файла из поля multipart-формы в каталог dir под случайным
+// именем prefix-*.ext. Возвращает путь к сохранённому файлу или errNoUpload,
+// если файл не передан. Форма должна быть уже разобрана ParseMultipartForm
+func saveUpload(r *http.Request, field, dir, prefix string, allowed map[string]string) (string, error) {
+	if r.MultipartForm == nil || r.MultipartForm.File[field] == nil {
+		return "", errNoUpload
+	}
+	src, _, err := r.FormFile(field)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	return storeUpload(src, dir, prefix, allowed)
+}
+
+// Функция записи содержимого src в новый файл каталога dir. При ошибке
+// чтения или записи недописанный файл удаляется
+func storeUpload(src io.Reader, dir, prefix string, allowed map[string]string) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	ext, ok := allowed[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", errUnsupportedUpload
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	dst, err := os.CreateTemp(dir, prefix+"-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	_, err = dst.Write(head[:n])
+	if err == nil {
+		_, err = io.Copy(dst, src)
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return filepath.Clean(dst.Name()), nil
+}
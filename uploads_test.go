@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Читатель, который отдаёт данные, а затем возвращает ошибку
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+// Тест: ошибка чтения посреди файла не оставляет недописанного вложения
+func TestStoreUploadReadError(t *testing.T) {
+	dir := t.TempDir()
+	readErr := errors.New("соединение прервано")
+	src := &failingReader{data: strings.NewReader("%PDF-1.4\n" + strings.Repeat("x", 2048)), err: readErr}
+
+	path, err := storeUpload(src, dir, "support", attachmentUploadTypes)
+	assert.ErrorIs(t, err, readErr)
+	assert.Empty(t, path)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// Тест: файл допустимого типа сохраняется целиком
+func TestStoreUpload(t *testing.T) {
+	dir := t.TempDir()
+	content := "%PDF-1.4\n" + strings.Repeat("x", 2048)
+
+	path, err := storeUpload(strings.NewReader(content), dir, "support", attachmentUploadTypes)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(path, ".pdf"))
+
+	saved, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(saved))
+
+	_, err = storeUpload(strings.NewReader("просто текст"), dir, "support", attachmentUploadTypes)
+	assert.ErrorIs(t, err, errUnsupportedUpload)
+}
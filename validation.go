@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Ошибка проверки одного поля запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Проверки, которые не выражаются тегами (например, связь нескольких полей),
+// структура описывает сама
+type selfValidator interface {
+	Validate() []FieldError
+}
+
+var (
+	emailPattern = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+	phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,19}$`)
+)
+
+// Функция проверки структуры по тегам validate. Правила перечисляются через запятую:
+//
+//	required     — строка не пустая, число не равно нулю
+//	email        — адрес электронной почты
+//	phone        — номер телефона: цифры, пробелы, скобки, дефисы и + в начале
+//	date         — дата в формате 2006-01-02
+//	min=N, max=N — длина строки в символах или значение числа
+//	oneof=a|b|c  — одно из перечисленных значений
+//
+// Правила, кроме required, к пустым значениям не применяются. Поля встроенных
+// структур проверяются как собственные. Имя поля в ошибке берётся из тега json
+func validate(v interface{}) []FieldError {
+	return validateLocale(v, defaultLocale)
+}
+
+// Функция проверки структуры с текстами ошибок на указанном языке
+func validateLocale(v interface{}, locale string) []FieldError {
+	errs := []FieldError{}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		value := rv.Field(i)
+		if field.Anonymous && value.Kind() == reflect.Struct {
+			errs = append(errs, validateLocale(value.Interface(), locale)...)
+			continue
+		}
+
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+			name = tag
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			if msg := checkRule(rule, value, locale); msg != "" {
+				errs = append(errs, FieldError{name, msg})
+				break
+			}
+		}
+	}
+
+	if sv, ok := v.(selfValidator); ok {
+		for _, e := range sv.Validate() {
+			errs = append(errs, FieldError{e.Field, translate(locale, e.Message)})
+		}
+	}
+	return errs
+}
+
+// Функция проверки одного правила; возвращает текст ошибки или пустую строку
+func checkRule(rule string, value reflect.Value, locale string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		if value.IsZero() {
+			return translate(locale, "Обязательное поле")
+		}
+		return ""
+	}
+	if value.IsZero() {
+		return ""
+	}
+
+	switch name {
+	case "email":
+		if !emailPattern.MatchString(value.String()) {
+			return translate(locale, "Некорректный email")
+		}
+	case "phone":
+		if !phonePattern.MatchString(value.String()) {
+			return translate(locale, "Некорректный номер телефона")
+		}
+	case "date":
+		if _, err := time.Parse(dateLayout, value.String()); err != nil {
+			return translate(locale, "Дата должна быть в формате ГГГГ-ММ-ДД")
+		}
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(arg, 64)
+		var n float64
+		isString := value.Kind() == reflect.String
+		switch {
+		case isString:
+			n = float64(utf8.RuneCountInString(value.String()))
+		case value.CanInt():
+			n = float64(value.Int())
+		case value.CanFloat():
+			n = value.Float()
+		}
+		switch {
+		case name == "min" && n < limit && isString:
+			return translate(locale, "Не короче %s символов", arg)
+		case name == "max" && n > limit && isString:
+			return translate(locale, "Не длиннее %s символов", arg)
+		case name == "min" && n < limit:
+			return translate(locale, "Не меньше %s", arg)
+		case name == "max" && n > limit:
+			return translate(locale, "Не больше %s", arg)
+		}
+	case "oneof":
+		allowed := strings.Split(arg, "|")
+		for _, a := range allowed {
+			if fmt.Sprint(value.Interface()) == a {
+				return ""
+			}
+		}
+		return translate(locale, "Допустимые значения: %s", strings.Join(allowed, ", "))
+	}
+	return ""
+}
+
+// Функция ответа со списком ошибок по полям
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	writeJSON(w, r, http.StatusBadRequest, Response{
+		Status:  "fail",
+		Code:    "validation_failed",
+		Message: "Некорректные данные формы",
+		Errors:  errs,
+	})
+}
+
+// Функция чтения JSON-тела запроса с проверкой. Если данные некорректны,
+// ответ уже отправлен и функция возвращает false
+func decodeAndValidate(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, "invalid_json", "Некорректные данные формы")
+		return false
+	}
+	if errs := validateLocale(dst, requestLocale(r)); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return false
+	}
+	return true
+}
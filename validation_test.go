@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тест правил проверки: обязательность, email, длина, диапазон и перечисление
+func TestValidateRules(t *testing.T) {
+	errs := validate(User{FirstName: "", LastName: "Doe", Email: "not-an-email", Password: "short"})
+	assert.Equal(t, []FieldError{
+		{"first_name", "Обязательное поле"},
+		{"email", "Некорректный email"},
+		{"password", "Не короче 8 символов"},
+	}, errs)
+
+	errs = validate(Car{Model: "Test", Price: Money{-100, "USD"}, Rating: 7, Category: "Truck", Brand: "X", BranchID: 1})
+	assert.Equal(t, []FieldError{
+		{"Rating", "Не больше 5"},
+		{"Category", "Допустимые значения: Sedan, SUV, Sports, Electric, Van"},
+		{"Price.amount", "Цена должна быть больше нуля"},
+	}, errs)
+
+	assert.Empty(t, validate(HoldRequest{ItemType: "room", ItemID: 3, StartDate: "2025-07-01", EndDate: "2025-07-04"}))
+}
+
+// Тест ответа со списком ошибок по полям
+func TestDecodeAndValidateWritesFieldErrors(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/holds", strings.NewReader(`{"item_type":"boat","item_id":1,"start_date":"01.07.2025","end_date":"2025-07-04"}`))
+
+	var hold HoldRequest
+	assert.False(t, decodeAndValidate(rr, req, &hold))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"status":"fail","code":"validation_failed","message":"Некорректные данные формы","errors":[
+		{"field":"item_type","message":"Допустимые значения: car, room"},
+		{"field":"start_date","message":"Дата должна быть в формате ГГГГ-ММ-ДД"}
+	]}`, rr.Body.String())
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Статусы записи в листе ожидания
+const (
+	waitlistWaiting   = "waiting"   // Ждёт освобождения объекта
+	waitlistOffered   = "offered"   // Пользователю выдано удержание
+	waitlistFulfilled = "fulfilled" // Удержание оплачено
+	waitlistExpired   = "expired"   // Удержание не оплачено вовремя или даты прошли
+	waitlistCancelled = "cancelled" // Пользователь вышел из листа ожидания
+)
+
+// Сколько держится удержание, выданное из листа ожидания. Пользователь узнаёт
+// о нём из письма, поэтому срок больше, чем при обычном оформлении
+var waitlistHoldTTL = time.Duration(envInt("WAITLIST_HOLD_MINUTES", 60)) * time.Minute
+
+var (
+	errAlreadyWaiting   = errors.New("пользователь уже в листе ожидания")
+	errItemStillFree    = errors.New("объект свободен на выбранные даты")
+	errWaitlistNotFound = errors.New("запись в листе ожидания не найдена")
+)
+
+// Запись в листе ожидания
+type WaitlistEntry struct {
+	ID        int        `json:"id"`
+	ItemType  string     `json:"item_type"`
+	ItemID    int        `json:"item_id"`
+	ItemName  string     `json:"item_name"`
+	StartDate string     `json:"start_date"`
+	EndDate   string     `json:"end_date"`
+	Status    string     `json:"status"`
+	HoldID    *int       `json:"hold_id,omitempty"`
+	ExpiresAt *time.Time `json:"hold_expires_at,omitempty"`
+}
+
+// Предложение, о котором нужно сообщить пользователю после фиксации транзакции
+type waitlistOffer struct {
+	userID int
+	hold   Hold
+}
+
+// Функция записи в лист ожидания. Встать в очередь можно только на занятые даты
+func joinWaitlist(userID int, itemType string, itemID int, startDate, endDate string, now time.Time) (int, error) {
+	start, end, err := parseDateRange(startDate, endDate)
+	if err != nil {
+		return 0, err
+	}
+	if !now.Before(start) {
+		return 0, errInvalidDateRange
+	}
+	if itemName(itemType, itemID) == "" {
+		return 0, errItemNotFound
+	}
+
+	available, err := itemAvailableFor(db, itemType, itemID, start, end, userID, 0)
+	if err != nil {
+		return 0, err
+	}
+	if available {
+		return 0, errItemStillFree
+	}
+
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM waitlist_entries
+		WHERE user_id = $1 AND item_type = $2 AND item_id = $3 AND start_date = $4 AND end_date = $5 AND status IN ('waiting', 'offered'))`,
+		userID, itemType, itemID, start, end).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, errAlreadyWaiting
+	}
+
+	var id int
+	err = db.QueryRow(`INSERT INTO waitlist_entries (item_type, item_id, user_id, start_date, end_date) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		itemType, itemID, userID, start, end).Scan(&id)
+	return id, err
+}
+
+// Функция раздачи освободившегося объекта листу ожидания. Записи просматриваются
+// в порядке очереди; каждая, чьи даты теперь свободны, получает удержание на
+// waitlistHoldTTL. Записи пользователя, который уже держит объект на эти даты,
+// пропускаются. Если удержание истечёт неоплаченным, объект снова освобождается
+// и предлагается следующему в очереди
+func offerWaitlist(itemType string, itemID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockItem(tx, itemType, itemID); err != nil {
+		return err
+	}
+
+	// Предложения, удержание по которым снято или истекло, больше не действуют
+	_, err = tx.Exec(`UPDATE waitlist_entries w SET status = $1
+		WHERE w.item_type = $2 AND w.item_id = $3 AND (
+			(w.status = $4 AND NOT EXISTS (SELECT 1 FROM holds h WHERE h.id = w.hold_id AND h.expires_at > NOW()))
+			OR (w.status = $5 AND w.start_date <= CURRENT_DATE)
+		)`, waitlistExpired, itemType, itemID, waitlistOffered, waitlistWaiting)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id, user_id, start_date, end_date FROM waitlist_entries
+		WHERE item_type = $1 AND item_id = $2 AND status = $3 ORDER BY created_at, id`, itemType, itemID, waitlistWaiting)
+	if err != nil {
+		return err
+	}
+	type candidate struct {
+		id, userID int
+		start, end time.Time
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.userID, &c.start, &c.end); err != nil {
+			rows.Close()
+			return err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var offers []waitlistOffer
+	for _, c := range candidates {
+		// Пользователь уже держит объект на пересекающиеся даты — по другой записи
+		// из этого прохода или оформляя заказ сам. Новое удержание заменило бы
+		// прежнее, поэтому запись остаётся в очереди
+		var held bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM holds
+			WHERE user_id = $1 AND item_type = $2 AND item_id = $3 AND expires_at > NOW() AND start_date < $5 AND end_date > $4)`,
+			c.userID, itemType, itemID, c.start, c.end).Scan(&held)
+		if err != nil {
+			return err
+		}
+		if held {
+			continue
+		}
+
+		hold, _, err := placeHold(tx, c.userID, HoldRequest{
+			ItemType:  itemType,
+			ItemID:    itemID,
+			StartDate: c.start.Format(dateLayout),
+			EndDate:   c.end.Format(dateLayout),
+		}, waitlistHoldTTL)
+		if errors.Is(err, errItemUnavailable) || errors.Is(err, errTooManyHolds) || errors.Is(err, errInvalidDateRange) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE waitlist_entries SET status = $1, hold_id = $2, offered_at = NOW() WHERE id = $3`,
+			waitlistOffered, hold.ID, c.id)
+		if err != nil {
+			return err
+		}
+		offers = append(offers, waitlistOffer{c.userID, hold})
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, offer := range offers {
+		if err := sendWaitlistOffer(offer); err != nil {
+			log.Printf("Ошибка отправки предложения из листа ожидания пользователю #%d: %v", offer.userID, err)
+		}
+	}
+	return nil
+}
+
+// Функция уведомления пользователя о выданном удержании
+func sendWaitlistOffer(offer waitlistOffer) error {
+	var email, locale string
+	err := db.QueryRow(`SELECT email, COALESCE(locale, $2) FROM users WHERE id = $1`, offer.userID, defaultLocale).Scan(&email, &locale)
+	if err != nil {
+		return err
+	}
+
+	// Письмо отправляется вне запроса, поэтому язык берётся из профиля
+	subject, body := renderEmail(locale, "waitlist_offer",
+		itemName(offer.hold.ItemType, offer.hold.ItemID), offer.hold.StartDate, offer.hold.EndDate,
+		offer.hold.ExpiresAt.Format("2006-01-02 15:04"), offer.hold.TotalPrice, offer.hold.ID)
+	return emailSender.SendEmail([]string{email}, subject, body)
+}
+
+// Функция, вызываемая при освобождении объекта: ошибки только логируются,
+// чтобы не срывать основную операцию
+func notifyWaitlist(itemType string, itemID int) {
+	if err := offerWaitlist(itemType, itemID); err != nil {
+		log.Printf("Ошибка обработки листа ожидания для %s #%d: %v", itemType, itemID, err)
+	}
+}
+
+// Функция выхода из листа ожидания. Если по записи уже выдано удержание,
+// оно снимается и объект предлагается следующему в очереди
+func leaveWaitlist(userID, entryID int) error {
+	var itemType string
+	var itemID int
+	var holdID *int
+	err := db.QueryRow(`UPDATE waitlist_entries SET status = $1
+		WHERE id = $2 AND user_id = $3 AND status IN ('waiting', 'offered')
+		RETURNING item_type, item_id, hold_id`, waitlistCancelled, entryID, userID).Scan(&itemType, &itemID, &holdID)
+	if err != nil {
+		return errWaitlistNotFound
+	}
+	if holdID != nil {
+		if _, err := db.Exec(`DELETE FROM holds WHERE id = $1`, *holdID); err != nil {
+			return err
+		}
+		notifyWaitlist(itemType, itemID)
+	}
+	return nil
+}
+
+// Запрос записи в лист ожидания
+type WaitlistRequest struct {
+	ItemType  string `json:"item_type" validate:"required,oneof=car|room"`
+	ItemID    int    `json:"item_id" validate:"required"`
+	StartDate string `json:"start_date" validate:"required,date"`
+	EndDate   string `json:"end_date" validate:"required,date"`
+}
+
+// Обработчик записи в лист ожидания
+func handleJoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req WaitlistRequest
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		id, err := joinWaitlist(userID, req.ItemType, req.ItemID, req.StartDate, req.EndDate, time.Now())
+		switch {
+		case err == nil:
+		case errors.Is(err, errInvalidDateRange):
+			writeError(w, r, http.StatusBadRequest, "Некорректный период дат")
+			return
+		case errors.Is(err, errItemNotFound):
+			writeError(w, r, http.StatusNotFound, "Объект не найден")
+			return
+		case errors.Is(err, errItemStillFree):
+			writeErrorCode(w, r, http.StatusConflict, "item_available", "Объект свободен на эти даты, его можно забронировать")
+			return
+		case errors.Is(err, errAlreadyWaiting):
+			writeErrorCode(w, r, http.StatusConflict, "already_waiting", "Вы уже в листе ожидания на эти даты")
+			return
+		default:
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка сохранения данных в базе")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusCreated, "Вы в листе ожидания. Мы сообщим, когда объект освободится", map[string]interface{}{
+			"entry_id": id,
+		})
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Обработчик получения записей пользователя в листе ожидания
+func handleMyWaitlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		rows, err := db.Query(`SELECT w.id, w.item_type, w.item_id, w.start_date, w.end_date, w.status, h.id, h.expires_at
+			FROM waitlist_entries w LEFT JOIN holds h ON h.id = w.hold_id AND w.status = 'offered'
+			WHERE w.user_id = $1 ORDER BY w.created_at DESC, w.id DESC`, userID)
+		if err != nil {
+			log.Println("Ошибка запроса к базе данных:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка получения данных")
+			return
+		}
+		defer rows.Close()
+
+		entries := []WaitlistEntry{}
+		for rows.Next() {
+			var e WaitlistEntry
+			var start, end time.Time
+			if err := rows.Scan(&e.ID, &e.ItemType, &e.ItemID, &start, &end, &e.Status, &e.HoldID, &e.ExpiresAt); err != nil {
+				log.Println("Ошибка обработки строки:", err)
+				writeError(w, r, http.StatusInternalServerError, "Ошибка обработки данных")
+				return
+			}
+			e.StartDate = start.Format(dateLayout)
+			e.EndDate = end.Format(dateLayout)
+			e.ItemName = itemName(e.ItemType, e.ItemID)
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			log.Println("Ошибка итерации строк:", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка обработки данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "", entries)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
+
+// Ссылка на запись в листе ожидания
+type WaitlistRef struct {
+	EntryID int `json:"entry_id" validate:"required"`
+}
+
+// Обработчик выхода из листа ожидания
+func handleLeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost {
+		userID, err := currentUserID(r)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusUnauthorized, "auth_required", "Необходимо войти в аккаунт")
+			return
+		}
+
+		var req WaitlistRef
+		if !decodeAndValidate(w, r, &req) {
+			return
+		}
+
+		err = leaveWaitlist(userID, req.EntryID)
+		if errors.Is(err, errWaitlistNotFound) {
+			writeError(w, r, http.StatusNotFound, "Запись в листе ожидания не найдена")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка SQL: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Ошибка базы данных")
+			return
+		}
+
+		writeSuccess(w, r, http.StatusOK, "Вы вышли из листа ожидания", nil)
+		return
+	}
+
+	writeMethodNotAllowed(w, r)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Отправитель, запоминающий адресатов писем
+type recordingEmailSender struct {
+	sent [][]string
+}
+
+func (s *recordingEmailSender) SendEmail(to []string, subject, body string) error {
+	s.sent = append(s.sent, to)
+	return nil
+}
+
+// Тест: две пересекающиеся записи одного пользователя дают одно предложение,
+// вторая запись остаётся в очереди и не заменяет выданное удержание
+func TestOfferWaitlistSameUserTwice(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания мока: %v", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+
+	sender := &recordingEmailSender{}
+	defer func(prev EmailSender) { emailSender = prev }(emailSender)
+	emailSender = sender
+
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 10)
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE waitlist_entries w SET status").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, user_id, start_date, end_date FROM waitlist_entries").
+		WithArgs("room", 1, waitlistWaiting).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "start_date", "end_date"}).
+			AddRow(11, 7, day, day.AddDate(0, 0, 3)).
+			AddRow(12, 7, day.AddDate(0, 0, 1), day.AddDate(0, 0, 4)))
+
+	// Первая запись получает удержание
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM holds").
+		WithArgs(7, "room", 1, day, day.AddDate(0, 0, 3)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT item_id FROM bookings").WillReturnRows(sqlmock.NewRows([]string{"item_id"}))
+	mock.ExpectQuery("SELECT EXISTS \\(\\s*SELECT 1 FROM bookings").WillReturnRows(sqlmock.NewRows([]string{"taken"}).AddRow(false))
+	mock.ExpectExec("UPDATE waitlist_entries SET status = \\$1 WHERE status = \\$2 AND hold_id IN").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM holds WHERE user_id").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM holds").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("INSERT INTO holds").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expires_at"}).AddRow(100, time.Now().Add(waitlistHoldTTL)))
+	mock.ExpectExec("UPDATE waitlist_entries SET status = \\$1, hold_id = \\$2").
+		WithArgs(waitlistOffered, 100, 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Вторая запись того же пользователя пропускается
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM holds").
+		WithArgs(7, "room", 1, day.AddDate(0, 0, 1), day.AddDate(0, 0, 4)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery("SELECT email, COALESCE\\(locale").
+		WithArgs(7, defaultLocale).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "locale"}).AddRow("user@example.com", "ru"))
+
+	assert.NoError(t, offerWaitlist("room", 1))
+	assert.Equal(t, [][]string{{"user@example.com"}}, sender.sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}